@@ -8,7 +8,7 @@ import (
 )
 
 func main() {
-	bpmnChaincode, err := contractapi.NewChaincode(&chaincode.SmartContract{})
+	bpmnChaincode, err := contractapi.NewChaincode(chaincode.NewSmartContract())
 	if err != nil {
 		log.Panicf("Error creating bpmn chaincode: %v", err)
 	}
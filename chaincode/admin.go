@@ -0,0 +1,251 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	contractVersion = "1.0.0"
+
+	metadataKey       = "LedgerMetadata"
+	archiveObjectType = "instance~archive"
+)
+
+// LedgerMetadata records who initialized the chaincode and with which version.
+// It lives in the world state, so every peer and every restart sees the same status.
+type LedgerMetadata struct {
+	Initialized   bool   `json:"initialized"`
+	Version       string `json:"version"`
+	InitializedBy string `json:"initializedBy"` // client identity ID
+	MspID         string `json:"mspID"`
+	TxID          string `json:"txID"`
+}
+
+// InstanceArchive keeps the state of a run that was discarded by ResetInstance
+type InstanceArchive struct {
+	Instance     *Instance                  `json:"instance"`
+	Participants []*ParticipantBinding      `json:"participants"` // bindings of the run, including late ones
+	Elements     map[string]json.RawMessage `json:"elements"`
+	Memory       StateMemory                `json:"memory"`
+	ArchivedBy   string                     `json:"archivedBy"`
+	TxID         string                     `json:"txID"`
+}
+
+// ReadLedgerMetadata returns the initialization status of the chaincode
+func (cc *SmartContract) ReadLedgerMetadata(ctx contractapi.TransactionContextInterface) (*LedgerMetadata, error) {
+	metadata, err := cc.readLedgerMetadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if metadata == nil {
+		errorMessage := "Chaincode has not been initialized"
+		fmt.Println(errorMessage)
+		return nil, errors.New(errorMessage)
+	}
+	return metadata, nil
+}
+
+func (cc *SmartContract) readLedgerMetadata(ctx contractapi.TransactionContextInterface) (*LedgerMetadata, error) {
+	metadataJSON, err := ctx.GetStub().GetState(metadataKey)
+	if err != nil {
+		return nil, fmt.Errorf("获取状态数据时出错: %v", err)
+	}
+	if metadataJSON == nil {
+		return nil, nil
+	}
+
+	var metadata LedgerMetadata
+	if err := json.Unmarshal(metadataJSON, &metadata); err != nil {
+		return nil, err
+	}
+	return &metadata, nil
+}
+
+// putLedgerMetadata marks the ledger as initialized by the calling identity
+func (cc *SmartContract) putLedgerMetadata(ctx contractapi.TransactionContextInterface) error {
+	clientID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return err
+	}
+	clientMspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return err
+	}
+
+	metadataJSON, err := json.Marshal(&LedgerMetadata{
+		Initialized:   true,
+		Version:       contractVersion,
+		InitializedBy: clientID,
+		MspID:         clientMspID,
+		TxID:          ctx.GetStub().GetTxID(),
+	})
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(metadataKey, metadataJSON)
+}
+
+// requireAdmin only lets the identity that initialized the ledger through
+func (cc *SmartContract) requireAdmin(ctx contractapi.TransactionContextInterface) (string, error) {
+	metadata, err := cc.ReadLedgerMetadata(ctx)
+	if err != nil {
+		return "", err
+	}
+	clientID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return "", err
+	}
+	clientMspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return "", err
+	}
+	if clientID != metadata.InitializedBy || clientMspID != metadata.MspID {
+		errorMessage := "Only the administrator may perform this operation"
+		fmt.Println(errorMessage)
+		return "", errors.New(errorMessage)
+	}
+	return clientID, nil
+}
+
+// ResetInstance archives the current run of an instance and seeds it again from
+// its definition. Only the administrator that initialized the ledger may call it.
+// The participants are bound as they were at CreateInstance, late bindings of the
+// discarded run are dropped and its private payloads removed from their collections.
+func (cc *SmartContract) ResetInstance(ctx contractapi.TransactionContextInterface, instanceID string) error {
+	stub := ctx.GetStub()
+	adminID, err := cc.requireAdmin(ctx)
+	if err != nil {
+		return err
+	}
+	instance, chor, err := cc.readInstance(ctx, instanceID)
+	if err != nil {
+		return err
+	}
+
+	archive := &InstanceArchive{
+		Instance:   instance,
+		Elements:   make(map[string]json.RawMessage),
+		ArchivedBy: adminID,
+		TxID:       stub.GetTxID(),
+	}
+	for _, id := range sortedElementIDs(chor) {
+		recordIDs := []string{id}
+		if el := chor.Elements[id]; el.Type == ChoreographyTaskElement {
+			recordIDs = el.Messages
+		}
+		for _, recordID := range recordIDs {
+			key, err := elementKey(ctx, instanceID, recordID)
+			if err != nil {
+				return err
+			}
+			recordJSON, err := stub.GetState(key)
+			if err != nil {
+				return fmt.Errorf("获取状态数据时出错: %v", err)
+			}
+			if recordJSON == nil {
+				continue
+			}
+			archive.Elements[recordID] = recordJSON
+
+			// 重新开始的消息都是 DISABLE，清除旧的待办事项
+			if docTypeOf(recordJSON) == MessageDocType {
+				var msg Message
+				if err := unmarshalNumbers(recordJSON, &msg); err != nil {
+					return err
+				}
+				previous := msg.MsgState
+				msg.MsgState = DISABLE
+				if err := cc.updateWorklist(ctx, instanceID, &msg, previous); err != nil {
+					return err
+				}
+				if msg.PayloadCollection != "" {
+					if err := cc.deletePrivatePayload(ctx, instanceID, &msg); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	if archive.Memory, err = cc.ReadMemory(ctx, instanceID); err != nil {
+		return err
+	}
+	if archive.Participants, err = cc.GetParticipants(ctx, instanceID); err != nil {
+		return err
+	}
+
+	archiveKey, err := stub.CreateCompositeKey(archiveObjectType, []string{instanceID, strconv.Itoa(instance.Run)})
+	if err != nil {
+		return err
+	}
+	archiveJSON, err := json.Marshal(archive)
+	if err != nil {
+		return fmt.Errorf("序列化归档数据时出错: %v", err)
+	}
+	if err := stub.PutState(archiveKey, archiveJSON); err != nil {
+		return fmt.Errorf("保存归档数据时出错: %v", err)
+	}
+
+	// 归档后重新开始一轮
+	reset := *instance
+	reset.Run++
+	reset.Status = InstanceRunning
+	reset.Pending = nil
+	if err := cc.putInstance(ctx, &reset); err != nil {
+		return err
+	}
+	// 恢复创建实例时的绑定，上一轮的晚绑定不再有效
+	if reset.Bindings != "" {
+		bindings, err := parseBindings(chor, instanceID, reset.Bindings)
+		if err != nil {
+			return err
+		}
+		for _, participantID := range sortedParticipantIDs(chor) {
+			if err := cc.putParticipant(ctx, bindings[participantID]); err != nil {
+				return err
+			}
+		}
+	}
+	if err := cc.seedInstance(ctx, &reset, chor); err != nil {
+		return err
+	}
+	if err := cc.putMemory(ctx, instanceID, StateMemory{}); err != nil {
+		return err
+	}
+
+	return stub.SetEvent("resetInstanceEvent", []byte(instanceID))
+}
+
+// GetInstanceArchives returns the archived runs of an instance, oldest first
+func (cc *SmartContract) GetInstanceArchives(ctx contractapi.TransactionContextInterface, instanceID string) ([]*InstanceArchive, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(archiveObjectType, []string{instanceID})
+	if err != nil {
+		return nil, fmt.Errorf("获取状态数据时出错: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	var archives []*InstanceArchive
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("迭代状态数据时出错: %v", err)
+		}
+
+		var archive InstanceArchive
+		if err := unmarshalNumbers(queryResponse.Value, &archive); err != nil {
+			return nil, fmt.Errorf("反序列化归档数据时出错: %v", err)
+		}
+		archives = append(archives, &archive)
+	}
+
+	// 组合键按字符串排序，"10" 会排在 "2" 之前
+	sort.Slice(archives, func(i, j int) bool {
+		return archives[i].Instance.Run < archives[j].Instance.Run
+	})
+	return archives, nil
+}
@@ -0,0 +1,533 @@
+package chaincode
+
+import (
+	_ "embed"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// hotelBookingBPMN is the choreography seeded by InitLedger
+//
+//go:embed bpmn/hotel_booking.bpmn
+var hotelBookingBPMN []byte
+
+type ElementType string
+
+const (
+	StartEventElement        ElementType = "startEvent"
+	EndEventElement          ElementType = "endEvent"
+	ChoreographyTaskElement  ElementType = "choreographyTask"
+	ExclusiveGatewayElement  ElementType = "exclusiveGateway"
+	EventBasedGatewayElement ElementType = "eventBasedGateway"
+	ParallelGatewayElement   ElementType = "parallelGateway"
+	InclusiveGatewayElement  ElementType = "inclusiveGateway"
+)
+
+// Choreography is the execution graph built from a BPMN 2.0 choreography model.
+// Maps are marshalled with sorted keys, so the stored definition is deterministic.
+type Choreography struct {
+	ChoreographyID string                        `json:"choreographyID"`
+	Name           string                        `json:"name"`
+	Participants   map[string]*Participant       `json:"participants"`
+	Messages       map[string]*MessageDefinition `json:"messages"`
+	Elements       map[string]*FlowElement       `json:"elements"`
+	Flows          map[string]*SequenceFlow      `json:"flows"`
+	Lifecycle      LifecyclePolicy               `json:"lifecycle"`
+}
+
+type Participant struct {
+	ParticipantID string `json:"participantID"`
+	Name          string `json:"name"`
+}
+
+type MessageDefinition struct {
+	MessageID          string `json:"messageID"`
+	Name               string `json:"name"`
+	Format             string `json:"format"`
+	SendParticipant    string `json:"sendParticipant"`
+	ReceiveParticipant string `json:"receiveParticipant"`
+	TaskID             string `json:"taskID"`
+	MaxRejections      int    `json:"maxRejections"`         // rejections allowed before the instance fails
+	DecisionRef        string `json:"decisionRef,omitempty"` // decision applied to the payload when the message is sent
+}
+
+// PolicyTextFormat marks the documentation of a message that carries its MessagePolicy, of a
+// gateway that carries its GatewayPolicy, or of the choreography that carries its LifecyclePolicy, e.g.
+// <bpmn2:documentation textFormat="application/vnd.choreography-policy+json">{"maxRejections":1}</bpmn2:documentation>
+const PolicyTextFormat = "application/vnd.choreography-policy+json"
+
+// DefaultMaxRejections applies to messages whose policy does not limit rejections
+const DefaultMaxRejections = 3
+
+// MessagePolicy governs how a message may be handled besides being sent and confirmed
+type MessagePolicy struct {
+	MaxRejections *int   `json:"maxRejections"`
+	DecisionRef   string `json:"decisionRef"` // deployed decision whose outputs become process variables
+}
+
+// GatewayPolicy lets an exclusive or inclusive gateway evaluate a deployed decision before
+// its conditions, which then read the outputs of the decision, e.g. {"decisionRef":"Decision_refund"}
+type GatewayPolicy struct {
+	DecisionRef string `json:"decisionRef"`
+}
+
+type FlowElement struct {
+	ElementID   string      `json:"elementID"`
+	Type        ElementType `json:"type"`
+	Name        string      `json:"name"`
+	Incoming    []string    `json:"incoming"`
+	Outgoing    []string    `json:"outgoing"`
+	Messages    []string    `json:"messages,omitempty"` // 初始消息在前，返回消息在后
+	Default     string      `json:"default,omitempty"`
+	Join        string      `json:"join,omitempty"`        // converging gateway merging the branches of an inclusive split
+	DecisionRef string      `json:"decisionRef,omitempty"` // decision evaluated before the conditions of the gateway
+}
+
+type SequenceFlow struct {
+	FlowID    string `json:"flowID"`
+	Name      string `json:"name"`
+	SourceRef string `json:"sourceRef"`
+	TargetRef string `json:"targetRef"`
+	Condition string `json:"condition,omitempty"`
+}
+
+// XML layout of the BPMN 2.0 elements produced by Chor-js.
+// encoding/xml matches local names, so the bpmn2: prefix does not matter.
+type bpmnDefinitions struct {
+	XMLName        xml.Name           `xml:"definitions"`
+	Messages       []bpmnMessage      `xml:"message"`
+	Choreographies []bpmnChoreography `xml:"choreography"`
+}
+
+type bpmnMessage struct {
+	ID            string              `xml:"id,attr"`
+	Name          string              `xml:"name,attr"`
+	Documentation []bpmnDocumentation `xml:"documentation"`
+}
+
+type bpmnDocumentation struct {
+	TextFormat string `xml:"textFormat,attr"`
+	Text       string `xml:",chardata"`
+}
+
+type bpmnParticipant struct {
+	ID   string `xml:"id,attr"`
+	Name string `xml:"name,attr"`
+}
+
+type bpmnMessageFlow struct {
+	ID         string `xml:"id,attr"`
+	SourceRef  string `xml:"sourceRef,attr"`
+	TargetRef  string `xml:"targetRef,attr"`
+	MessageRef string `xml:"messageRef,attr"`
+}
+
+type bpmnFlowNode struct {
+	ID            string              `xml:"id,attr"`
+	Name          string              `xml:"name,attr"`
+	Default       string              `xml:"default,attr"`
+	Documentation []bpmnDocumentation `xml:"documentation"`
+}
+
+type bpmnChoreographyTask struct {
+	bpmnFlowNode
+	InitiatingParticipantRef string   `xml:"initiatingParticipantRef,attr"`
+	ParticipantRefs          []string `xml:"participantRef"`
+	MessageFlowRefs          []string `xml:"messageFlowRef"`
+}
+
+type bpmnSequenceFlow struct {
+	ID                  string `xml:"id,attr"`
+	Name                string `xml:"name,attr"`
+	SourceRef           string `xml:"sourceRef,attr"`
+	TargetRef           string `xml:"targetRef,attr"`
+	ConditionExpression string `xml:"conditionExpression"`
+}
+
+type bpmnChoreography struct {
+	ID                 string                 `xml:"id,attr"`
+	Name               string                 `xml:"name,attr"`
+	Documentation      []bpmnDocumentation    `xml:"documentation"`
+	Participants       []bpmnParticipant      `xml:"participant"`
+	MessageFlows       []bpmnMessageFlow      `xml:"messageFlow"`
+	StartEvents        []bpmnFlowNode         `xml:"startEvent"`
+	EndEvents          []bpmnFlowNode         `xml:"endEvent"`
+	ChoreographyTasks  []bpmnChoreographyTask `xml:"choreographyTask"`
+	ExclusiveGateways  []bpmnFlowNode         `xml:"exclusiveGateway"`
+	EventBasedGateways []bpmnFlowNode         `xml:"eventBasedGateway"`
+	ParallelGateways   []bpmnFlowNode         `xml:"parallelGateway"`
+	InclusiveGateways  []bpmnFlowNode         `xml:"inclusiveGateway"`
+	SequenceFlows      []bpmnSequenceFlow     `xml:"sequenceFlow"`
+}
+
+// ParseChoreography builds the execution graph of the first choreography in a BPMN 2.0 document
+func ParseChoreography(data []byte) (*Choreography, error) {
+	var defs bpmnDefinitions
+	if err := xml.Unmarshal(data, &defs); err != nil {
+		return nil, fmt.Errorf("failed to parse BPMN: %v", err)
+	}
+	if len(defs.Choreographies) == 0 {
+		return nil, fmt.Errorf("BPMN document does not contain a choreography")
+	}
+	src := defs.Choreographies[0]
+
+	chor := &Choreography{
+		ChoreographyID: src.ID,
+		Name:           src.Name,
+		Participants:   make(map[string]*Participant),
+		Messages:       make(map[string]*MessageDefinition),
+		Elements:       make(map[string]*FlowElement),
+		Flows:          make(map[string]*SequenceFlow),
+	}
+
+	for _, p := range src.Participants {
+		chor.Participants[p.ID] = &Participant{ParticipantID: p.ID, Name: p.Name}
+	}
+
+	// 实例的暂停、恢复和取消规则
+	for _, doc := range src.Documentation {
+		if doc.TextFormat != PolicyTextFormat {
+			continue
+		}
+		if err := json.Unmarshal([]byte(doc.Text), &chor.Lifecycle); err != nil {
+			return nil, fmt.Errorf("choreography %s: policy is not valid JSON: %v", src.ID, err)
+		}
+		if err := chor.Lifecycle.validate(); err != nil {
+			return nil, fmt.Errorf("choreography %s: %v", src.ID, err)
+		}
+	}
+
+	messageNames := make(map[string]string)
+	messageSchemas := make(map[string]string)
+	messagePolicies := make(map[string]MessagePolicy)
+	for _, m := range defs.Messages {
+		messageNames[m.ID] = m.Name
+		for _, doc := range m.Documentation {
+			switch doc.TextFormat {
+			case SchemaTextFormat:
+				messageSchemas[m.ID] = doc.Text
+			case PolicyTextFormat:
+				var policy MessagePolicy
+				if err := json.Unmarshal([]byte(doc.Text), &policy); err != nil {
+					return nil, fmt.Errorf("message %s: policy is not valid JSON: %v", m.ID, err)
+				}
+				if policy.MaxRejections != nil && *policy.MaxRejections < 0 {
+					return nil, fmt.Errorf("message %s: maxRejections can not be negative", m.ID)
+				}
+				messagePolicies[m.ID] = policy
+			}
+		}
+	}
+	messageFlows := make(map[string]bpmnMessageFlow)
+	for _, mf := range src.MessageFlows {
+		messageFlows[mf.ID] = mf
+	}
+
+	addElement := func(node bpmnFlowNode, elementType ElementType) error {
+		if node.ID == "" {
+			return fmt.Errorf("%s without id", elementType)
+		}
+		if _, exists := chor.Elements[node.ID]; exists {
+			return fmt.Errorf("duplicate element %s", node.ID)
+		}
+		el := &FlowElement{
+			ElementID: node.ID,
+			Type:      elementType,
+			Name:      node.Name,
+			Incoming:  []string{},
+			Outgoing:  []string{},
+			Default:   node.Default,
+		}
+		for _, doc := range node.Documentation {
+			if doc.TextFormat != PolicyTextFormat || elementType == ChoreographyTaskElement {
+				continue
+			}
+			var policy GatewayPolicy
+			if err := json.Unmarshal([]byte(doc.Text), &policy); err != nil {
+				return fmt.Errorf("%s %s: policy is not valid JSON: %v", elementType, node.ID, err)
+			}
+			if policy.DecisionRef != "" && elementType != ExclusiveGatewayElement && elementType != InclusiveGatewayElement {
+				return fmt.Errorf("%s %s can not reference a decision", elementType, node.ID)
+			}
+			el.DecisionRef = policy.DecisionRef
+		}
+		chor.Elements[node.ID] = el
+		return nil
+	}
+
+	nodeGroups := []struct {
+		nodes       []bpmnFlowNode
+		elementType ElementType
+	}{
+		{src.StartEvents, StartEventElement},
+		{src.EndEvents, EndEventElement},
+		{src.ExclusiveGateways, ExclusiveGatewayElement},
+		{src.EventBasedGateways, EventBasedGatewayElement},
+		{src.ParallelGateways, ParallelGatewayElement},
+		{src.InclusiveGateways, InclusiveGatewayElement},
+	}
+	for _, group := range nodeGroups {
+		for _, node := range group.nodes {
+			if err := addElement(node, group.elementType); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, task := range src.ChoreographyTasks {
+		if err := addElement(task.bpmnFlowNode, ChoreographyTaskElement); err != nil {
+			return nil, err
+		}
+		if len(task.MessageFlowRefs) == 0 || len(task.MessageFlowRefs) > 2 {
+			return nil, fmt.Errorf("choreography task %s must have one or two messages", task.ID)
+		}
+
+		var initiating, returning []string
+		for _, ref := range task.MessageFlowRefs {
+			mf, ok := messageFlows[ref]
+			if !ok {
+				return nil, fmt.Errorf("message flow %s of task %s does not exist", ref, task.ID)
+			}
+			name, ok := messageNames[mf.MessageRef]
+			if !ok {
+				return nil, fmt.Errorf("message %s of task %s does not exist", mf.MessageRef, task.ID)
+			}
+			if _, exists := chor.Messages[mf.MessageRef]; exists {
+				return nil, fmt.Errorf("message %s is used by more than one task", mf.MessageRef)
+			}
+			if _, ok := chor.Participants[mf.SourceRef]; !ok {
+				return nil, fmt.Errorf("participant %s of message %s does not exist", mf.SourceRef, mf.MessageRef)
+			}
+			if _, ok := chor.Participants[mf.TargetRef]; !ok {
+				return nil, fmt.Errorf("participant %s of message %s does not exist", mf.TargetRef, mf.MessageRef)
+			}
+
+			// 有 JSON Schema 时优先使用，否则从消息签名推导字段
+			format := formatFromSignature(name)
+			if schema, ok := messageSchemas[mf.MessageRef]; ok {
+				format = schema
+			}
+			compiled, err := CompileFormat(format)
+			if err != nil {
+				return nil, fmt.Errorf("message %s: %v", mf.MessageRef, err)
+			}
+			format = compiled.String()
+			policy := messagePolicies[mf.MessageRef]
+			maxRejections := DefaultMaxRejections
+			if policy.MaxRejections != nil {
+				maxRejections = *policy.MaxRejections
+			}
+			chor.Messages[mf.MessageRef] = &MessageDefinition{
+				MessageID:          mf.MessageRef,
+				Name:               name,
+				Format:             format,
+				SendParticipant:    mf.SourceRef,
+				ReceiveParticipant: mf.TargetRef,
+				TaskID:             task.ID,
+				MaxRejections:      maxRejections,
+				DecisionRef:        policy.DecisionRef,
+			}
+			if mf.SourceRef == task.InitiatingParticipantRef {
+				initiating = append(initiating, mf.MessageRef)
+			} else {
+				returning = append(returning, mf.MessageRef)
+			}
+		}
+		if len(initiating) != 1 {
+			return nil, fmt.Errorf("choreography task %s must have exactly one initiating message", task.ID)
+		}
+		chor.Elements[task.ID].Messages = append(initiating, returning...)
+	}
+
+	for _, sf := range src.SequenceFlows {
+		if _, exists := chor.Flows[sf.ID]; exists {
+			return nil, fmt.Errorf("duplicate sequence flow %s", sf.ID)
+		}
+		source, ok := chor.Elements[sf.SourceRef]
+		if !ok {
+			return nil, fmt.Errorf("source %s of sequence flow %s does not exist", sf.SourceRef, sf.ID)
+		}
+		target, ok := chor.Elements[sf.TargetRef]
+		if !ok {
+			return nil, fmt.Errorf("target %s of sequence flow %s does not exist", sf.TargetRef, sf.ID)
+		}
+		chor.Flows[sf.ID] = &SequenceFlow{
+			FlowID:    sf.ID,
+			Name:      sf.Name,
+			SourceRef: sf.SourceRef,
+			TargetRef: sf.TargetRef,
+			Condition: strings.TrimSpace(sf.ConditionExpression),
+		}
+		source.Outgoing = append(source.Outgoing, sf.ID)
+		target.Incoming = append(target.Incoming, sf.ID)
+	}
+
+	if err := chor.validate(); err != nil {
+		return nil, err
+	}
+	return chor, nil
+}
+
+func (chor *Choreography) validate() error {
+	starts := 0
+	for _, el := range chor.Elements {
+		switch el.Type {
+		case StartEventElement:
+			starts++
+			if len(el.Outgoing) != 1 {
+				return fmt.Errorf("start event %s must have exactly one outgoing flow", el.ElementID)
+			}
+		case EndEventElement:
+			if len(el.Outgoing) != 0 {
+				return fmt.Errorf("end event %s can not have outgoing flows", el.ElementID)
+			}
+		case ChoreographyTaskElement:
+			if len(el.Outgoing) != 1 {
+				return fmt.Errorf("choreography task %s must have exactly one outgoing flow", el.ElementID)
+			}
+		case ExclusiveGatewayElement, EventBasedGatewayElement, InclusiveGatewayElement:
+			if len(el.Outgoing) == 0 {
+				return fmt.Errorf("gateway %s has no outgoing flow", el.ElementID)
+			}
+		case ParallelGatewayElement:
+			if len(el.Outgoing) == 0 {
+				return fmt.Errorf("gateway %s has no outgoing flow", el.ElementID)
+			}
+			if el.Default != "" {
+				return fmt.Errorf("parallel gateway %s can not have a default flow", el.ElementID)
+			}
+		}
+
+		if el.Default != "" {
+			flow, ok := chor.Flows[el.Default]
+			if !ok || flow.SourceRef != el.ElementID {
+				return fmt.Errorf("default flow %s is not an outgoing flow of %s", el.Default, el.ElementID)
+			}
+		}
+		if (el.Type == ExclusiveGatewayElement || el.Type == InclusiveGatewayElement) && len(el.Outgoing) > 1 {
+			// 除默认分支外每个分支都需要条件，避免无声地走向某个分支
+			for _, flowID := range el.Outgoing {
+				if flowID == el.Default {
+					continue
+				}
+				condition := chor.Flows[flowID].Condition
+				if condition == "" {
+					return fmt.Errorf("flow %s of gateway %s needs a condition unless it is the default flow", flowID, el.ElementID)
+				}
+				if _, err := ParseExpression(condition); err != nil {
+					return fmt.Errorf("condition of flow %s: %v", flowID, err)
+				}
+			}
+		}
+		if el.Type == EventBasedGatewayElement {
+			for _, flowID := range el.Outgoing {
+				target := chor.Elements[chor.Flows[flowID].TargetRef]
+				if target.Type != ChoreographyTaskElement {
+					return fmt.Errorf("event-based gateway %s must be followed by choreography tasks", el.ElementID)
+				}
+			}
+		}
+	}
+	if starts == 0 {
+		return fmt.Errorf("choreography %s has no start event", chor.ChoreographyID)
+	}
+	return chor.pairInclusiveGateways()
+}
+
+// pairInclusiveGateways finds the join of every inclusive split: the nearest converging
+// inclusive gateway that all of its branches lead to. At runtime the split tells its join
+// how many branches it activated, so the join only waits for those.
+func (chor *Choreography) pairInclusiveGateways() error {
+	isJoin := func(id string) bool {
+		el := chor.Elements[id]
+		return el.Type == InclusiveGatewayElement && len(el.Incoming) > 1
+	}
+
+	paired := make(map[string]bool)
+	for _, id := range sortedElementIDs(chor) {
+		el := chor.Elements[id]
+		if el.Type != InclusiveGatewayElement || len(el.Outgoing) < 2 {
+			continue
+		}
+
+		// 每个分支可到达的汇合网关，按距离排序
+		var candidates []string
+		common := make(map[string]int)
+		for i, flowID := range el.Outgoing {
+			reached := make(map[string]bool)
+			queue := []string{chor.Flows[flowID].TargetRef}
+			for len(queue) > 0 {
+				next := queue[0]
+				queue = queue[1:]
+				if reached[next] || next == id {
+					continue
+				}
+				reached[next] = true
+				if isJoin(next) {
+					if i == 0 {
+						candidates = append(candidates, next)
+					}
+					common[next]++
+				}
+				for _, outID := range chor.Elements[next].Outgoing {
+					queue = append(queue, chor.Flows[outID].TargetRef)
+				}
+			}
+		}
+		for _, candidate := range candidates {
+			if common[candidate] == len(el.Outgoing) {
+				el.Join = candidate
+				paired[candidate] = true
+				break
+			}
+		}
+	}
+
+	for _, id := range sortedElementIDs(chor) {
+		if isJoin(id) && !paired[id] {
+			return fmt.Errorf("inclusive gateway %s does not merge the branches of an inclusive split", id)
+		}
+	}
+	return nil
+}
+
+// DecisionRefs maps the gateways and messages that reference a decision to its ID
+func (chor *Choreography) DecisionRefs() map[string]string {
+	refs := make(map[string]string)
+	for id, el := range chor.Elements {
+		if el.DecisionRef != "" {
+			refs[id] = el.DecisionRef
+		}
+	}
+	for id, msg := range chor.Messages {
+		if msg.DecisionRef != "" {
+			refs[id] = msg.DecisionRef
+		}
+	}
+	return refs
+}
+
+// Chor-js names messages after a call signature, e.g. "Check_room(string date, uint bedrooms)"
+var signaturePattern = regexp.MustCompile(`^[^(]*\((.*)\)\s*$`)
+
+// formatFromSignature turns "Check_room(string date, uint bedrooms)" into "date:string, bedrooms:uint"
+func formatFromSignature(name string) string {
+	match := signaturePattern.FindStringSubmatch(name)
+	if match == nil {
+		return ""
+	}
+
+	var fields []string
+	for _, param := range strings.Split(match[1], ",") {
+		parts := strings.Fields(param)
+		if len(parts) < 2 {
+			continue
+		}
+		// "address payable to" => to:address
+		fields = append(fields, parts[len(parts)-1]+":"+parts[0])
+	}
+	return strings.Join(fields, ", ")
+}
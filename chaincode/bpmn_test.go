@@ -0,0 +1,132 @@
+package chaincode_test
+
+import (
+	"chaincode-go-bpmn/chaincode"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseChoreography(t *testing.T) {
+	data, err := os.ReadFile("bpmn/hotel_booking.bpmn")
+	require.NoError(t, err)
+
+	chor, err := chaincode.ParseChoreography(data)
+	require.NoError(t, err)
+	require.Equal(t, "Choreography_hotel_booking", chor.ChoreographyID)
+	require.Len(t, chor.Participants, 2)
+	require.Len(t, chor.Messages, 10)
+
+	msg := chor.Messages["Message_045i10y"]
+	require.Equal(t, "date:string, bedrooms:uint", msg.Format)
+	require.Equal(t, "Participant_1080bkg", msg.SendParticipant)
+	require.Equal(t, "Participant_0sktaei", msg.ReceiveParticipant)
+	require.Equal(t, chaincode.DefaultMaxRejections, msg.MaxRejections)
+	require.Equal(t, "to:address", chor.Messages["Message_0o8eyir"].Format)
+
+	task := chor.Elements["ChoreographyTask_0olk5ju"]
+	require.Equal(t, chaincode.ChoreographyTaskElement, task.Type)
+	require.Equal(t, []string{"Message_045i10y", "Message_0r9lypd"}, task.Messages)
+
+	gtw := chor.Elements["ExclusiveGateway_106je4z"]
+	require.Equal(t, "SequenceFlow_1ry2vwl", gtw.Default)
+	require.Equal(t, []string{"SequenceFlow_1t0bkcw", "SequenceFlow_1ry2vwl"}, gtw.Outgoing)
+	require.Equal(t, "confirm = true", chor.Flows["SequenceFlow_1t0bkcw"].Condition)
+}
+
+func TestParseChoreographyErrors(t *testing.T) {
+	_, err := chaincode.ParseChoreography([]byte("<definitions"))
+	require.Error(t, err)
+
+	_, err = chaincode.ParseChoreography([]byte(`<definitions></definitions>`))
+	require.EqualError(t, err, "BPMN document does not contain a choreography")
+
+	_, err = chaincode.ParseChoreography([]byte(`<definitions><choreography id="c">
+		<startEvent id="s"/><endEvent id="e"/>
+		<sequenceFlow id="f" sourceRef="s" targetRef="x"/>
+	</choreography></definitions>`))
+	require.EqualError(t, err, "target x of sequence flow f does not exist")
+
+	_, err = chaincode.ParseChoreography([]byte(`<definitions><choreography id="c">
+		<endEvent id="e"/>
+	</choreography></definitions>`))
+	require.EqualError(t, err, "choreography c has no start event")
+
+	_, err = chaincode.ParseChoreography([]byte(`<definitions><choreography id="c">
+		<documentation textFormat="application/vnd.choreography-policy+json">{"suspend":"nobody"}</documentation>
+		<startEvent id="s"/>
+	</choreography></definitions>`))
+	require.EqualError(t, err, "choreography c: unknown rule nobody for suspend")
+
+	_, err = chaincode.ParseChoreography([]byte(`<definitions><choreography id="c">
+		<startEvent id="s"/><parallelGateway id="p" default="f2"/><endEvent id="e"/>
+		<sequenceFlow id="f1" sourceRef="s" targetRef="p"/>
+		<sequenceFlow id="f2" sourceRef="p" targetRef="e"/>
+	</choreography></definitions>`))
+	require.EqualError(t, err, "parallel gateway p can not have a default flow")
+
+	_, err = chaincode.ParseChoreography([]byte(`<definitions><choreography id="c">
+		<startEvent id="s1"/><startEvent id="s2"/><inclusiveGateway id="j"/><endEvent id="e"/>
+		<sequenceFlow id="f1" sourceRef="s1" targetRef="j"/>
+		<sequenceFlow id="f2" sourceRef="s2" targetRef="j"/>
+		<sequenceFlow id="f3" sourceRef="j" targetRef="e"/>
+	</choreography></definitions>`))
+	require.EqualError(t, err, "inclusive gateway j does not merge the branches of an inclusive split")
+
+	_, err = chaincode.ParseChoreography([]byte(`<definitions><choreography id="c">
+		<startEvent id="s"/><exclusiveGateway id="x" default="f3"/><endEvent id="e1"/><endEvent id="e2"/><endEvent id="e3"/>
+		<sequenceFlow id="f1" sourceRef="s" targetRef="x"/>
+		<sequenceFlow id="f2" sourceRef="x" targetRef="e1"><conditionExpression>quotation &lt;= 500 and</conditionExpression></sequenceFlow>
+		<sequenceFlow id="f3" sourceRef="x" targetRef="e2"/>
+	</choreography></definitions>`))
+	require.EqualError(t, err, "condition of flow f2: unexpected end of condition")
+
+	_, err = chaincode.ParseChoreography([]byte(`<definitions><choreography id="c">
+		<startEvent id="s"/><exclusiveGateway id="x" default="f3"/><endEvent id="e1"/><endEvent id="e2"/><endEvent id="e3"/>
+		<sequenceFlow id="f1" sourceRef="s" targetRef="x"/>
+		<sequenceFlow id="f2" sourceRef="x" targetRef="e1"><conditionExpression>confirm</conditionExpression></sequenceFlow>
+		<sequenceFlow id="f3" sourceRef="x" targetRef="e2"/>
+		<sequenceFlow id="f4" sourceRef="x" targetRef="e3"/>
+	</choreography></definitions>`))
+	require.EqualError(t, err, "flow f4 of gateway x needs a condition unless it is the default flow")
+}
+
+func TestParseInclusiveGateway(t *testing.T) {
+	data, err := os.ReadFile("bpmn/extra_services.bpmn")
+	require.NoError(t, err)
+	chor, err := chaincode.ParseChoreography(data)
+	require.NoError(t, err)
+
+	split := chor.Elements["InclusiveGateway_services"]
+	require.Equal(t, chaincode.InclusiveGatewayElement, split.Type)
+	require.Equal(t, "SequenceFlow_no_extras", split.Default)
+	require.Equal(t, "InclusiveGateway_services_join", split.Join)
+	require.Empty(t, chor.Elements["InclusiveGateway_services_join"].Join)
+}
+
+func TestParseDecisionRefs(t *testing.T) {
+	chor, err := chaincode.ParseChoreography([]byte(`<definitions>
+		<message id="m" name="Price_quotation(uint quotation)"><documentation textFormat="application/vnd.choreography-policy+json">{"decisionRef":"Decision_deposit"}</documentation></message>
+		<choreography id="c"><participant id="p1"/><participant id="p2"/>
+		<messageFlow id="mf" sourceRef="p1" targetRef="p2" messageRef="m"/>
+		<startEvent id="s"/><choreographyTask id="t" initiatingParticipantRef="p1"><messageFlowRef>mf</messageFlowRef></choreographyTask>
+		<exclusiveGateway id="x" default="f4"><documentation textFormat="application/vnd.choreography-policy+json">{"decisionRef":"Decision_refund"}</documentation></exclusiveGateway>
+		<endEvent id="e1"/><endEvent id="e2"/>
+		<sequenceFlow id="f1" sourceRef="s" targetRef="t"/>
+		<sequenceFlow id="f2" sourceRef="t" targetRef="x"/>
+		<sequenceFlow id="f3" sourceRef="x" targetRef="e1"><conditionExpression>refund = true</conditionExpression></sequenceFlow>
+		<sequenceFlow id="f4" sourceRef="x" targetRef="e2"/>
+	</choreography></definitions>`))
+	require.NoError(t, err)
+	require.Equal(t, "Decision_refund", chor.Elements["x"].DecisionRef)
+	require.Equal(t, "Decision_deposit", chor.Messages["m"].DecisionRef)
+	require.Equal(t, map[string]string{"x": "Decision_refund", "m": "Decision_deposit"}, chor.DecisionRefs())
+
+	_, err = chaincode.ParseChoreography([]byte(`<definitions><choreography id="c">
+		<startEvent id="s"/><parallelGateway id="p"><documentation textFormat="application/vnd.choreography-policy+json">{"decisionRef":"Decision_refund"}</documentation></parallelGateway><endEvent id="e"/>
+		<sequenceFlow id="f1" sourceRef="s" targetRef="p"/>
+		<sequenceFlow id="f2" sourceRef="p" targetRef="e"/>
+	</choreography></definitions>`))
+	require.EqualError(t, err, "parallelGateway p can not reference a decision")
+}
@@ -0,0 +1,588 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const decisionObjectType = "decision"
+
+// HitPolicy tells which rules of a decision table contribute to its result
+type HitPolicy string
+
+const (
+	UniqueHitPolicy  HitPolicy = "UNIQUE"  // at most one rule may match
+	FirstHitPolicy   HitPolicy = "FIRST"   // the first matching rule in table order wins
+	CollectHitPolicy HitPolicy = "COLLECT" // every matching rule, in table order
+)
+
+// DecisionType is the typeRef of an input or output column
+type DecisionType string
+
+const (
+	StringType  DecisionType = "string"
+	NumberType  DecisionType = "number"
+	BooleanType DecisionType = "boolean"
+)
+
+// DecisionTable is a DMN decision table stored on the ledger. Gateways and messages
+// reference it by DecisionID (see GatewayPolicy and MessagePolicy), so branch decisions follow governed
+// rules instead of conditions hard-coded in the choreography.
+type DecisionTable struct {
+	DecisionID string           `json:"decisionID"`
+	Name       string           `json:"name"`
+	HitPolicy  HitPolicy        `json:"hitPolicy"`
+	Inputs     []DecisionInput  `json:"inputs"`
+	Outputs    []DecisionOutput `json:"outputs"`
+	Rules      []DecisionRule   `json:"rules"`
+}
+
+// DecisionInput is an input column, its value is an expression over the process variables
+type DecisionInput struct {
+	InputID    string       `json:"inputID"`
+	Label      string       `json:"label,omitempty"`
+	Expression string       `json:"expression"`
+	TypeRef    DecisionType `json:"typeRef"`
+}
+
+// DecisionOutput is an output column, its name becomes a process variable
+type DecisionOutput struct {
+	OutputID string       `json:"outputID"`
+	Name     string       `json:"name"`
+	TypeRef  DecisionType `json:"typeRef"`
+}
+
+// DecisionRule has one entry per input and output column. An input entry is a list of
+// unary tests separated by commas, one of which has to hold: "-" matches anything, a value
+// such as 500 or "late" matches it, a comparison such as "<= 500" or a range such as
+// "[100..500]" matches the values within, and not(...) negates the list.
+// An output entry is a value.
+type DecisionRule struct {
+	RuleID        string   `json:"ruleID"`
+	InputEntries  []string `json:"inputEntries"`
+	OutputEntries []string `json:"outputEntries"`
+}
+
+// DecisionResult lists the matching rules and the outputs they produced. Under COLLECT
+// every output holds the list of values of the matching rules.
+type DecisionResult struct {
+	DecisionID string                 `json:"decisionID"`
+	Rules      []string               `json:"rules"`
+	Outputs    map[string]interface{} `json:"outputs"`
+}
+
+// XML layout of DMN 1.3 decision tables
+type dmnDefinitions struct {
+	XMLName   xml.Name      `xml:"definitions"`
+	Decisions []dmnDecision `xml:"decision"`
+}
+
+type dmnDecision struct {
+	ID            string            `xml:"id,attr"`
+	Name          string            `xml:"name,attr"`
+	DecisionTable *dmnDecisionTable `xml:"decisionTable"`
+}
+
+type dmnDecisionTable struct {
+	HitPolicy   string      `xml:"hitPolicy,attr"`
+	Aggregation string      `xml:"aggregation,attr"`
+	Inputs      []dmnInput  `xml:"input"`
+	Outputs     []dmnOutput `xml:"output"`
+	Rules       []dmnRule   `xml:"rule"`
+}
+
+type dmnInput struct {
+	ID              string `xml:"id,attr"`
+	Label           string `xml:"label,attr"`
+	InputExpression struct {
+		TypeRef string `xml:"typeRef,attr"`
+		Text    string `xml:"text"`
+	} `xml:"inputExpression"`
+}
+
+type dmnOutput struct {
+	ID      string `xml:"id,attr"`
+	Name    string `xml:"name,attr"`
+	TypeRef string `xml:"typeRef,attr"`
+}
+
+type dmnRule struct {
+	ID            string     `xml:"id,attr"`
+	InputEntries  []dmnEntry `xml:"inputEntry"`
+	OutputEntries []dmnEntry `xml:"outputEntry"`
+}
+
+type dmnEntry struct {
+	Text string `xml:"text"`
+}
+
+// ParseDecisions reads the decision tables of a DMN document
+func ParseDecisions(data []byte) ([]*DecisionTable, error) {
+	var defs dmnDefinitions
+	if err := xml.Unmarshal(data, &defs); err != nil {
+		return nil, fmt.Errorf("failed to parse DMN: %v", err)
+	}
+
+	var decisions []*DecisionTable
+	for _, d := range defs.Decisions {
+		if d.DecisionTable == nil {
+			continue
+		}
+		decision, err := parseDecisionTable(d)
+		if err != nil {
+			return nil, fmt.Errorf("decision %s: %v", d.ID, err)
+		}
+		decisions = append(decisions, decision)
+	}
+	if len(decisions) == 0 {
+		return nil, errors.New("DMN document does not contain a decision table")
+	}
+	return decisions, nil
+}
+
+func parseDecisionTable(d dmnDecision) (*DecisionTable, error) {
+	src := d.DecisionTable
+	if d.ID == "" {
+		return nil, errors.New("decision without id")
+	}
+	decision := &DecisionTable{DecisionID: d.ID, Name: d.Name, HitPolicy: HitPolicy(src.HitPolicy)}
+	switch decision.HitPolicy {
+	case "":
+		decision.HitPolicy = UniqueHitPolicy
+	case UniqueHitPolicy, FirstHitPolicy, CollectHitPolicy:
+	default:
+		return nil, fmt.Errorf("hit policy %s is not supported", src.HitPolicy)
+	}
+	if src.Aggregation != "" {
+		return nil, fmt.Errorf("aggregation %s is not supported", src.Aggregation)
+	}
+	if len(src.Outputs) == 0 {
+		return nil, errors.New("decision table has no output")
+	}
+
+	for _, in := range src.Inputs {
+		typeRef, err := decisionType(in.InputExpression.TypeRef)
+		if err != nil {
+			return nil, fmt.Errorf("input %s: %v", in.ID, err)
+		}
+		expression := strings.TrimSpace(in.InputExpression.Text)
+		if _, err := ParseExpression(expression); err != nil {
+			return nil, fmt.Errorf("input %s: %v", in.ID, err)
+		}
+		decision.Inputs = append(decision.Inputs, DecisionInput{InputID: in.ID, Label: in.Label, Expression: expression, TypeRef: typeRef})
+	}
+	names := make(map[string]bool)
+	for _, out := range src.Outputs {
+		typeRef, err := decisionType(out.TypeRef)
+		if err != nil {
+			return nil, fmt.Errorf("output %s: %v", out.ID, err)
+		}
+		if out.Name == "" || names[out.Name] {
+			return nil, fmt.Errorf("output %s needs a unique name", out.ID)
+		}
+		names[out.Name] = true
+		decision.Outputs = append(decision.Outputs, DecisionOutput{OutputID: out.ID, Name: out.Name, TypeRef: typeRef})
+	}
+
+	for _, r := range src.Rules {
+		if len(r.InputEntries) != len(decision.Inputs) || len(r.OutputEntries) != len(decision.Outputs) {
+			return nil, fmt.Errorf("rule %s needs %d input and %d output entries", r.ID, len(decision.Inputs), len(decision.Outputs))
+		}
+		rule := DecisionRule{RuleID: r.ID}
+		for i, entry := range r.InputEntries {
+			text := strings.TrimSpace(entry.Text)
+			tests, err := parseUnaryTests(text)
+			if err == nil {
+				err = tests.check(decision.Inputs[i].TypeRef)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("rule %s: input %s: %v", r.ID, decision.Inputs[i].InputID, err)
+			}
+			rule.InputEntries = append(rule.InputEntries, text)
+		}
+		for i, entry := range r.OutputEntries {
+			text := strings.TrimSpace(entry.Text)
+			value, err := parseValue(text)
+			if err == nil {
+				err = checkDecisionValue(decision.Outputs[i].TypeRef, value.Value)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("rule %s: output %s: %v", r.ID, decision.Outputs[i].Name, err)
+			}
+			rule.OutputEntries = append(rule.OutputEntries, text)
+		}
+		decision.Rules = append(decision.Rules, rule)
+	}
+	return decision, nil
+}
+
+// decisionType maps a DMN typeRef onto the types the rules can compare
+func decisionType(typeRef string) (DecisionType, error) {
+	switch typeRef {
+	case "string":
+		return StringType, nil
+	case "number", "integer", "long", "double":
+		return NumberType, nil
+	case "boolean":
+		return BooleanType, nil
+	}
+	return "", fmt.Errorf("unknown type %q", typeRef)
+}
+
+func checkDecisionValue(typeRef DecisionType, value interface{}) error {
+	ok := false
+	switch typeRef {
+	case StringType:
+		_, ok = value.(string)
+	case NumberType:
+		// decimal fields may hold numeric strings such as "12.50"
+		_, ok = toRat(value)
+	case BooleanType:
+		_, ok = value.(bool)
+	}
+	if !ok {
+		return fmt.Errorf("%s is not a %s", describe(value), typeRef)
+	}
+	return nil
+}
+
+// Variables lists the process variables the inputs of the decision read, sorted
+func (d *DecisionTable) Variables() []string {
+	seen := make(map[string]bool)
+	for _, in := range d.Inputs {
+		if expr, err := ParseExpression(in.Expression); err == nil {
+			for _, name := range expr.Variables() {
+				seen[name] = true
+			}
+		}
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Evaluate applies the decision table to the variables. A UNIQUE or FIRST decision that
+// no rule matches fails, as does a UNIQUE one that more than one rule matches.
+func (d *DecisionTable) Evaluate(variables map[string]interface{}) (*DecisionResult, error) {
+	inputs := make([]interface{}, len(d.Inputs))
+	for i, in := range d.Inputs {
+		expr, err := ParseExpression(in.Expression)
+		if err != nil {
+			return nil, err
+		}
+		value, err := expr.value(variables)
+		if err != nil {
+			return nil, fmt.Errorf("decision %s: input %s: %v", d.DecisionID, in.InputID, err)
+		}
+		if err := checkDecisionValue(in.TypeRef, value); err != nil {
+			return nil, fmt.Errorf("decision %s: input %s: %v", d.DecisionID, in.InputID, err)
+		}
+		inputs[i] = value
+	}
+
+	result := &DecisionResult{DecisionID: d.DecisionID, Rules: []string{}, Outputs: make(map[string]interface{})}
+	if d.HitPolicy == CollectHitPolicy {
+		for _, out := range d.Outputs {
+			result.Outputs[out.Name] = []interface{}{}
+		}
+	}
+	for _, rule := range d.Rules {
+		matched, err := d.matches(rule, inputs, variables)
+		if err != nil {
+			return nil, err
+		}
+		if !matched {
+			continue
+		}
+		if d.HitPolicy == UniqueHitPolicy && len(result.Rules) > 0 {
+			return nil, fmt.Errorf("decision %s: rules %s and %s both match", d.DecisionID, result.Rules[0], rule.RuleID)
+		}
+		result.Rules = append(result.Rules, rule.RuleID)
+
+		for i, out := range d.Outputs {
+			value, err := parseValue(rule.OutputEntries[i])
+			if err != nil {
+				return nil, err
+			}
+			if d.HitPolicy == CollectHitPolicy {
+				result.Outputs[out.Name] = append(result.Outputs[out.Name].([]interface{}), value.Value)
+			} else {
+				result.Outputs[out.Name] = value.Value
+			}
+		}
+		if d.HitPolicy == FirstHitPolicy {
+			break
+		}
+	}
+
+	if len(result.Rules) == 0 && d.HitPolicy != CollectHitPolicy {
+		return nil, fmt.Errorf("decision %s: no rule matches", d.DecisionID)
+	}
+	return result, nil
+}
+
+func (d *DecisionTable) matches(rule DecisionRule, inputs []interface{}, variables map[string]interface{}) (bool, error) {
+	for i, entry := range rule.InputEntries {
+		tests, err := parseUnaryTests(entry)
+		if err != nil {
+			return false, err
+		}
+		ok, err := tests.match(inputs[i], variables)
+		if err != nil {
+			return false, fmt.Errorf("decision %s: rule %s: input %s: %v", d.DecisionID, rule.RuleID, d.Inputs[i].InputID, err)
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// unaryTests is a parsed input entry, one of its tests has to hold
+type unaryTests struct {
+	negate bool
+	tests  []unaryTest // empty for "-"
+}
+
+// unaryTest compares the input with every operand, e.g. ">=" 100 and "<=" 500 for a range
+type unaryTest struct {
+	ops      []string
+	operands []*Expression
+}
+
+func parseUnaryTests(entry string) (*unaryTests, error) {
+	tests := &unaryTests{}
+	if entry == "" || entry == "-" {
+		return tests, nil
+	}
+	if strings.HasPrefix(entry, "not(") && strings.HasSuffix(entry, ")") {
+		tests.negate = true
+		entry = strings.TrimSpace(entry[4 : len(entry)-1])
+	}
+
+	for _, part := range splitEntry(entry) {
+		part = strings.TrimSpace(part)
+		var test unaryTest
+		switch {
+		case isRange(part):
+			bounds := strings.SplitN(part[1:len(part)-1], "..", 2)
+			low, err := parseValue(strings.TrimSpace(bounds[0]))
+			if err != nil {
+				return nil, err
+			}
+			high, err := parseValue(strings.TrimSpace(bounds[1]))
+			if err != nil {
+				return nil, err
+			}
+			lowOp, highOp := ">=", "<="
+			if part[0] != '[' {
+				lowOp = ">"
+			}
+			if part[len(part)-1] != ']' {
+				highOp = "<"
+			}
+			test = unaryTest{ops: []string{lowOp, highOp}, operands: []*Expression{low, high}}
+		default:
+			op := "="
+			for _, prefix := range []string{"<=", ">=", "!=", "<", ">", "="} {
+				if strings.HasPrefix(part, prefix) {
+					op, part = prefix, strings.TrimSpace(part[len(prefix):])
+					break
+				}
+			}
+			value, err := parseValue(part)
+			if err != nil {
+				return nil, err
+			}
+			test = unaryTest{ops: []string{op}, operands: []*Expression{value}}
+		}
+		tests.tests = append(tests.tests, test)
+	}
+	return tests, nil
+}
+
+// isRange tells whether a unary test is an interval such as [100..500] or ]0..100[
+func isRange(test string) bool {
+	return len(test) > 4 && strings.Contains(test, "..") &&
+		strings.IndexByte("[(]", test[0]) >= 0 && strings.IndexByte("])[", test[len(test)-1]) >= 0
+}
+
+// splitEntry splits an input entry at the commas outside of strings and ranges
+func splitEntry(entry string) []string {
+	var parts []string
+	depth, start, quoted := 0, 0, false
+	for i := 0; i < len(entry); i++ {
+		switch c := entry[i]; {
+		case c == '\\' && quoted:
+			i++
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '[' || c == '(':
+			depth++
+		case c == ']' || c == ')':
+			depth--
+		case c == ',' && depth <= 0:
+			parts = append(parts, entry[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, entry[start:])
+}
+
+// parseValue parses a literal or the name of a process variable
+func parseValue(text string) (*Expression, error) {
+	expr, err := ParseExpression(text)
+	if err != nil {
+		return nil, err
+	}
+	if expr.Op != "" {
+		return nil, fmt.Errorf("%s is not a value", text)
+	}
+	return expr, nil
+}
+
+// check makes sure the literals of the tests have the type of their input column
+func (u *unaryTests) check(typeRef DecisionType) error {
+	for _, test := range u.tests {
+		for i, operand := range test.operands {
+			if operand.Name != "" {
+				continue
+			}
+			if err := checkDecisionValue(typeRef, operand.Value); err != nil {
+				return err
+			}
+			if typeRef == BooleanType && test.ops[i] != "=" && test.ops[i] != "!=" {
+				return fmt.Errorf("booleans can not be compared with %s", test.ops[i])
+			}
+		}
+	}
+	return nil
+}
+
+func (u *unaryTests) match(input interface{}, variables map[string]interface{}) (bool, error) {
+	if len(u.tests) == 0 {
+		return true, nil
+	}
+	for _, test := range u.tests {
+		ok := true
+		for i, operand := range test.operands {
+			value, err := operand.value(variables)
+			if err != nil {
+				return false, err
+			}
+			holds, err := compareValues(test.ops[i], input, value)
+			if err != nil {
+				return false, err
+			}
+			ok = ok && holds
+		}
+		if ok {
+			return !u.negate, nil
+		}
+	}
+	return u.negate, nil
+}
+
+// DeployDecision stores the decision tables of a DMN document on the ledger. Only the
+// administrator may deploy decisions, and a deployed decision can not be changed: a new
+// version of the rules is deployed under a new decision ID.
+func (cc *SmartContract) DeployDecision(ctx contractapi.TransactionContextInterface, dmnXML string) error {
+	if _, err := cc.requireAdmin(ctx); err != nil {
+		return err
+	}
+	decisions, err := ParseDecisions([]byte(dmnXML))
+	if err != nil {
+		return err
+	}
+
+	stub := ctx.GetStub()
+	var decisionIDs []string
+	for _, decision := range decisions {
+		key, err := stub.CreateCompositeKey(decisionObjectType, []string{decision.DecisionID})
+		if err != nil {
+			return err
+		}
+		existingData, err := stub.GetState(key)
+		if err != nil {
+			return fmt.Errorf("获取状态数据时出错: %v", err)
+		}
+		if existingData != nil {
+			return fmt.Errorf("决策 %s 已存在", decision.DecisionID)
+		}
+		decisionJSON, err := json.Marshal(decision)
+		if err != nil {
+			return fmt.Errorf("序列化决策数据时出错: %v", err)
+		}
+		if err := stub.PutState(key, decisionJSON); err != nil {
+			return fmt.Errorf("保存决策数据时出错: %v", err)
+		}
+		decisionIDs = append(decisionIDs, decision.DecisionID)
+	}
+
+	return stub.SetEvent("deployDecisionEvent", []byte(fmt.Sprintf("Decision %s has been deployed", strings.Join(decisionIDs, ", "))))
+}
+
+// ReadDecision returns a deployed decision table
+func (cc *SmartContract) ReadDecision(ctx contractapi.TransactionContextInterface, decisionID string) (*DecisionTable, error) {
+	stub := ctx.GetStub()
+	key, err := stub.CreateCompositeKey(decisionObjectType, []string{decisionID})
+	if err != nil {
+		return nil, err
+	}
+	decisionJSON, err := stub.GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("获取状态数据时出错: %v", err)
+	}
+	if decisionJSON == nil {
+		errorMessage := fmt.Sprintf("Decision %s has not been deployed", decisionID)
+		fmt.Println(errorMessage)
+		return nil, errors.New(errorMessage)
+	}
+
+	var decision DecisionTable
+	if err := json.Unmarshal(decisionJSON, &decision); err != nil {
+		return nil, err
+	}
+	return &decision, nil
+}
+
+// EvaluateDecision evaluates a deployed decision against inputsJSON, a JSON object of
+// the variables its inputs read, without touching any instance
+func (cc *SmartContract) EvaluateDecision(ctx contractapi.TransactionContextInterface, decisionID string, inputsJSON string) (*DecisionResult, error) {
+	decision, err := cc.ReadDecision(ctx, decisionID)
+	if err != nil {
+		return nil, err
+	}
+	variables, err := decodePayload(inputsJSON)
+	if err != nil {
+		return nil, err
+	}
+	return decision.Evaluate(variables)
+}
+
+// applyDecision evaluates a deployed decision against variables and copies its outputs
+// into memory, where gateway conditions read them
+func (cc *SmartContract) applyDecision(ctx contractapi.TransactionContextInterface, decisionID string, variables StateMemory, memory StateMemory) error {
+	decision, err := cc.ReadDecision(ctx, decisionID)
+	if err != nil {
+		return err
+	}
+	result, err := decision.Evaluate(variables)
+	if err != nil {
+		return err
+	}
+	for name, value := range result.Outputs {
+		memory[name] = value
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package chaincode_test
+
+import (
+	"chaincode-go-bpmn/chaincode"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func parseHotelBookingDecisions(t *testing.T) map[string]*chaincode.DecisionTable {
+	data, err := os.ReadFile("bpmn/hotel_booking.dmn")
+	require.NoError(t, err)
+	decisions, err := chaincode.ParseDecisions(data)
+	require.NoError(t, err)
+
+	byID := make(map[string]*chaincode.DecisionTable)
+	for _, decision := range decisions {
+		byID[decision.DecisionID] = decision
+	}
+	return byID
+}
+
+func TestParseDecisions(t *testing.T) {
+	decisions := parseHotelBookingDecisions(t)
+	require.Len(t, decisions, 3)
+
+	refund := decisions["Decision_refund"]
+	require.Equal(t, chaincode.UniqueHitPolicy, refund.HitPolicy)
+	require.Equal(t, []chaincode.DecisionInput{
+		{InputID: "Input_cancel", Label: "Cancel", Expression: "cancel", TypeRef: chaincode.BooleanType},
+		{InputID: "Input_quotation", Label: "Quotation", Expression: "quotation", TypeRef: chaincode.NumberType},
+	}, refund.Inputs)
+	require.Equal(t, []chaincode.DecisionOutput{
+		{OutputID: "Output_refund", Name: "refund", TypeRef: chaincode.BooleanType},
+		{OutputID: "Output_fee", Name: "fee", TypeRef: chaincode.NumberType},
+	}, refund.Outputs)
+	require.Equal(t, chaincode.DecisionRule{RuleID: "Rule_free_cancellation", InputEntries: []string{"true", "<= 500"}, OutputEntries: []string{"true", "0"}}, refund.Rules[1])
+	require.Equal(t, []string{"cancel", "quotation"}, refund.Variables())
+
+	// integer columns are numbers
+	require.Equal(t, chaincode.NumberType, decisions["Decision_deposit"].Outputs[0].TypeRef)
+
+	table := func(hitPolicy, input, output string) string {
+		return `<definitions><decision id="d"><decisionTable hitPolicy="` + hitPolicy + `">
+			<input id="i"><inputExpression typeRef="number"><text>quotation</text></inputExpression></input>
+			<output id="o" name="deposit" typeRef="number"/>
+			<rule id="r"><inputEntry><text>` + input + `</text></inputEntry><outputEntry><text>` + output + `</text></outputEntry></rule>
+		</decisionTable></decision></definitions>`
+	}
+	for source, message := range map[string]string{
+		`<definitions/>`:               "DMN document does not contain a decision table",
+		table("PRIORITY", "-", "1"):    "decision d: hit policy PRIORITY is not supported",
+		table("UNIQUE", "&lt; x", "1"): "",
+		table("UNIQUE", `"late"`, "1"): `decision d: rule r: input i: "late" is not a number`,
+		table("UNIQUE", "[1..", "1"):   `decision d: rule r: input i: unexpected '['`,
+		table("UNIQUE", "-", "true"):   "decision d: rule r: output deposit: true is not a number",
+		table("UNIQUE", "-", "a > 1"):  "decision d: rule r: output deposit: a > 1 is not a value",
+		`<definitions><decision id="d"><decisionTable>
+			<input id="i"><inputExpression typeRef="date"><text>day</text></inputExpression></input>
+			<output id="o" name="deposit" typeRef="number"/>
+		</decisionTable></decision></definitions>`: `decision d: input i: unknown type "date"`,
+		`<definitions><decision id="d"><decisionTable>
+			<input id="i"><inputExpression typeRef="number"><text>quotation</text></inputExpression></input>
+			<output id="o" name="deposit" typeRef="number"/>
+			<rule id="r"><inputEntry><text>-</text></inputEntry></rule>
+		</decisionTable></decision></definitions>`: "decision d: rule r needs 1 input and 1 output entries",
+	} {
+		_, err := chaincode.ParseDecisions([]byte(source))
+		if message == "" {
+			require.NoError(t, err, source)
+			continue
+		}
+		require.EqualError(t, err, message, source)
+	}
+}
+
+func TestEvaluateDecisionTable(t *testing.T) {
+	decisions := parseHotelBookingDecisions(t)
+
+	// UNIQUE: exactly one rule has to match
+	refund := decisions["Decision_refund"]
+	result, err := refund.Evaluate(map[string]interface{}{"cancel": true, "quotation": 800.0})
+	require.NoError(t, err)
+	require.Equal(t, &chaincode.DecisionResult{
+		DecisionID: "Decision_refund",
+		Rules:      []string{"Rule_cancellation_fee"},
+		Outputs:    map[string]interface{}{"refund": true, "fee": json.Number("50")},
+	}, result)
+	result, err = refund.Evaluate(map[string]interface{}{"cancel": false, "quotation": 800.0})
+	require.NoError(t, err)
+	require.Equal(t, []string{"Rule_kept"}, result.Rules)
+	_, err = refund.Evaluate(map[string]interface{}{"cancel": true})
+	require.EqualError(t, err, "decision Decision_refund: input Input_quotation: variable quotation is not set")
+	_, err = refund.Evaluate(map[string]interface{}{"cancel": "yes", "quotation": 800.0})
+	require.EqualError(t, err, `decision Decision_refund: input Input_cancel: "yes" is not a boolean`)
+
+	// FIRST: the first matching rule in table order wins
+	deposit := decisions["Decision_deposit"]
+	for quotation, rule := range map[float64]string{1500: "Rule_high_deposit", 1000: "Rule_deposit", 200: "Rule_deposit", 150: "Rule_no_deposit"} {
+		result, err := deposit.Evaluate(map[string]interface{}{"quotation": quotation})
+		require.NoError(t, err)
+		require.Equal(t, []string{rule}, result.Rules, quotation)
+	}
+
+	// COLLECT: every matching rule, possibly none
+	perks := decisions["Decision_perks"]
+	result, err = perks.Evaluate(map[string]interface{}{"quotation": 600.0, "bedrooms": 2.0})
+	require.NoError(t, err)
+	require.Equal(t, []string{"Rule_breakfast", "Rule_late_checkout", "Rule_family_room"}, result.Rules)
+	require.Equal(t, map[string]interface{}{"perk": []interface{}{"breakfast", "late checkout", "family room"}}, result.Outputs)
+	result, err = perks.Evaluate(map[string]interface{}{"quotation": 150.0, "bedrooms": 1.0})
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{"parking"}, result.Outputs["perk"])
+	result, err = perks.Evaluate(map[string]interface{}{"quotation": 100.0, "bedrooms": 1.0})
+	require.NoError(t, err)
+	require.Empty(t, result.Rules)
+	require.Equal(t, []interface{}{}, result.Outputs["perk"])
+
+	// overlapping rules violate UNIQUE, a table without a match fails
+	overlapping, err := chaincode.ParseDecisions([]byte(`<definitions><decision id="d"><decisionTable>
+		<input id="i"><inputExpression typeRef="number"><text>quotation</text></inputExpression></input>
+		<output id="o" name="deposit" typeRef="number"/>
+		<rule id="r1"><inputEntry><text>&gt;= 100</text></inputEntry><outputEntry><text>10</text></outputEntry></rule>
+		<rule id="r2"><inputEntry><text>[100..200]</text></inputEntry><outputEntry><text>20</text></outputEntry></rule>
+	</decisionTable></decision></definitions>`))
+	require.NoError(t, err)
+	_, err = overlapping[0].Evaluate(map[string]interface{}{"quotation": 150.0})
+	require.EqualError(t, err, "decision d: rules r1 and r2 both match")
+	_, err = overlapping[0].Evaluate(map[string]interface{}{"quotation": 50.0})
+	require.EqualError(t, err, "decision d: no rule matches")
+}
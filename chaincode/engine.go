@@ -0,0 +1,740 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const choreographyObjectType = "choreography"
+
+// DeployChoreography parses a BPMN choreography and stores it as a definition
+// that instances can be created from. Only the administrator may deploy definitions,
+// and a deployed definition can not be overwritten.
+func (cc *SmartContract) DeployChoreography(ctx contractapi.TransactionContextInterface, bpmnXML string) error {
+	if _, err := cc.requireAdmin(ctx); err != nil {
+		return err
+	}
+	chor, err := ParseChoreography([]byte(bpmnXML))
+	if err != nil {
+		return err
+	}
+	if err := cc.deployChoreography(ctx, chor); err != nil {
+		return err
+	}
+
+	return ctx.GetStub().SetEvent("deployChoreographyEvent", []byte(fmt.Sprintf("Choreography %s has been deployed", chor.ChoreographyID)))
+}
+
+func (cc *SmartContract) deployChoreography(ctx contractapi.TransactionContextInterface, chor *Choreography) error {
+	stub := ctx.GetStub()
+	key, err := stub.CreateCompositeKey(choreographyObjectType, []string{chor.ChoreographyID})
+	if err != nil {
+		return err
+	}
+
+	existingData, err := stub.GetState(key)
+	if err != nil {
+		return fmt.Errorf("获取状态数据时出错: %v", err)
+	}
+	if existingData != nil {
+		return fmt.Errorf("编排 %s 已存在", chor.ChoreographyID)
+	}
+
+	// 引用的决策须先部署
+	refs := chor.DecisionRefs()
+	referencing := make([]string, 0, len(refs))
+	for id := range refs {
+		referencing = append(referencing, id)
+	}
+	sort.Strings(referencing)
+	for _, id := range referencing {
+		if _, err := cc.ReadDecision(ctx, refs[id]); err != nil {
+			errorMessage := fmt.Sprintf("Decision %s referenced by %s has not been deployed", refs[id], id)
+			fmt.Println(errorMessage)
+			return errors.New(errorMessage)
+		}
+	}
+
+	chorJSON, err := json.Marshal(chor)
+	if err != nil {
+		return fmt.Errorf("序列化编排数据时出错: %v", err)
+	}
+	if err := stub.PutState(key, chorJSON); err != nil {
+		return fmt.Errorf("保存编排数据时出错: %v", err)
+	}
+	return nil
+}
+
+// ReadChoreography returns a deployed choreography definition
+func (cc *SmartContract) ReadChoreography(ctx contractapi.TransactionContextInterface, definitionID string) (*Choreography, error) {
+	stub := ctx.GetStub()
+	key, err := stub.CreateCompositeKey(choreographyObjectType, []string{definitionID})
+	if err != nil {
+		return nil, err
+	}
+	chorJSON, err := stub.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	if chorJSON == nil {
+		errorMessage := fmt.Sprintf("Choreography %s has not been deployed", definitionID)
+		fmt.Println(errorMessage)
+		return nil, errors.New(errorMessage)
+	}
+
+	var chor Choreography
+	if err := json.Unmarshal(chorJSON, &chor); err != nil {
+		return nil, err
+	}
+	return &chor, nil
+}
+
+// =================================================================================================
+// Execution engine: every element is driven from the choreography graph.
+
+// StartChoreography fires the start event of the instance
+func (cc *SmartContract) StartChoreography(ctx contractapi.TransactionContextInterface, instanceID string) error {
+	_, chor, err := cc.runningInstance(ctx, instanceID)
+	if err != nil {
+		return err
+	}
+
+	var eventID string
+	for _, id := range sortedElementIDs(chor) {
+		if chor.Elements[id].Type == StartEventElement {
+			eventID = id
+			break
+		}
+	}
+
+	actionEvent, err := cc.ReadEvent(ctx, instanceID, eventID)
+	if err != nil {
+		return err
+	}
+	if actionEvent.EventState != ENABLE {
+		errorMessage := fmt.Sprintf("Event state %s is not allowed", actionEvent.EventID)
+		fmt.Println(errorMessage)
+		return errors.New(errorMessage)
+	}
+
+	if err := cc.changeEventState(ctx, instanceID, eventID, DONE); err != nil {
+		return err
+	}
+	ctx.GetStub().SetEvent(eventID, []byte("Contract has been started successfully"))
+
+	return cc.leaveElement(ctx, instanceID, chor, eventID)
+}
+
+func sortedElementIDs(chor *Choreography) []string {
+	elementIDs := make([]string, 0, len(chor.Elements))
+	for id := range chor.Elements {
+		elementIDs = append(elementIDs, id)
+	}
+	sort.Strings(elementIDs)
+	return elementIDs
+}
+
+func sortedMessageIDs(chor *Choreography) []string {
+	messageIDs := make([]string, 0, len(chor.Messages))
+	for id := range chor.Messages {
+		messageIDs = append(messageIDs, id)
+	}
+	sort.Strings(messageIDs)
+	return messageIDs
+}
+
+func sortedParticipantIDs(chor *Choreography) []string {
+	participantIDs := make([]string, 0, len(chor.Participants))
+	for id := range chor.Participants {
+		participantIDs = append(participantIDs, id)
+	}
+	sort.Strings(participantIDs)
+	return participantIDs
+}
+
+// SendMessage is called by the sender of a choreography message once it has been
+// handed over to FireFly. Its fields become process variables when the message is confirmed.
+// payloadJSON carries the message fields declared in its format, payloadHash the SHA-256 of the
+// payload (see CanonicalPayloadHash). Only a send without payloadJSON may give the FireFly data
+// hash instead.
+//
+// A sensitive payload is passed in the transient map under PayloadTransientKey instead, with
+// payloadJSON left empty. It is stored in the collection of sender and receiver (PairCollection),
+// payloadHash may then be empty and defaults to its canonical hash. Only the hash reaches the
+// world state, so a payload with fields read by gateway conditions or decisions cannot be private.
+func (cc *SmartContract) SendMessage(ctx contractapi.TransactionContextInterface, instanceID string, messageID string, fireflyTranID string, payloadJSON string, payloadHash string) error {
+	stub := ctx.GetStub()
+	_, chor, err := cc.runningInstance(ctx, instanceID)
+	if err != nil {
+		return err
+	}
+	def, ok := chor.Messages[messageID]
+	if !ok {
+		return fmt.Errorf("Message %s is not part of choreography %s", messageID, chor.ChoreographyID)
+	}
+	// 晚绑定的发送方由第一条消息绑定，之后读取的消息已带有其MSP
+	if err := cc.bindOnSend(ctx, chor, instanceID, def.SendParticipant); err != nil {
+		return err
+	}
+	msg, err := cc.ReadMsg(ctx, instanceID, messageID)
+	if err != nil {
+		return err
+	}
+
+	if err := cc.authorizeParticipant(ctx, instanceID, def.SendParticipant); err != nil {
+		return err
+	}
+
+	// 事件网关的分支在第一次发送时确定，其余分支立即失效
+	if err := cc.chooseAlternative(ctx, instanceID, chor, messageID); err != nil {
+		return err
+	}
+
+	if msg.MsgState != ENABLE {
+		errorMessage := fmt.Sprintf("Msg state %s is not allowed", msg.MessageID)
+		fmt.Println(errorMessage)
+		return errors.New(errorMessage)
+	}
+
+	privatePayload, private, err := transientPayload(ctx)
+	if err != nil {
+		return err
+	}
+	if private {
+		if payloadJSON != "" {
+			errorMessage := "Payload must be passed either as argument or in the transient map"
+			fmt.Println(errorMessage)
+			return errors.New(errorMessage)
+		}
+		payloadJSON = string(privatePayload)
+	}
+
+	format, err := CompileFormat(msg.Format)
+	if err != nil {
+		return err
+	}
+	payload, err := format.ParsePayload(payloadJSON)
+	if err != nil {
+		return err
+	}
+
+	var hash string
+	if private {
+		// 私有数据的哈希由链码计算，发送方给出的哈希必须一致
+		if hash, err = CanonicalPayloadHash(payloadJSON); err != nil {
+			return err
+		}
+		if payloadHash != "" {
+			given, err := NormalizePayloadHash(payloadHash)
+			if err != nil {
+				return err
+			}
+			if given != hash {
+				errorMessage := fmt.Sprintf("Payload hash %s does not match the private payload", given)
+				fmt.Println(errorMessage)
+				return errors.New(errorMessage)
+			}
+		}
+	} else {
+		if hash, err = NormalizePayloadHash(payloadHash); err != nil {
+			return err
+		}
+		// 公开的载荷必须与哈希一致，只有不带载荷的发送才可以给出 FireFly 的数据哈希
+		if payloadJSON != "" {
+			canonical, err := CanonicalPayloadHash(payloadJSON)
+			if err != nil {
+				return err
+			}
+			if canonical != hash {
+				errorMessage := fmt.Sprintf("Payload hash %s does not match the payload", hash)
+				fmt.Println(errorMessage)
+				return errors.New(errorMessage)
+			}
+		}
+	}
+
+	if private {
+		// 私有载荷只有哈希写入世界状态，网关条件和决策读取的字段无法保密
+		processFields, err := cc.processVariables(ctx, chor)
+		if err != nil {
+			return err
+		}
+		for _, name := range sortedFieldNames(payload) {
+			if processFields[name] {
+				errorMessage := fmt.Sprintf("Field %s of message %s is read by a gateway condition or decision and cannot be sent privately", name, messageID)
+				fmt.Println(errorMessage)
+				return errors.New(errorMessage)
+			}
+		}
+	}
+
+	// 网关条件读取的字段在确认时才写入流程变量，被拒绝或撤回的发送不会留下痕迹
+	memory, err := cc.ReadMemory(ctx, instanceID)
+	if err != nil {
+		return err
+	}
+	variables := StateMemory{}
+	for name, value := range memory {
+		variables[name] = value
+	}
+	pending := StateMemory{}
+	if !private {
+		for name, value := range payload {
+			variables[name] = value
+			pending[name] = value
+		}
+	}
+	if def.DecisionRef != "" {
+		if err := cc.applyDecision(ctx, def.DecisionRef, variables, pending); err != nil {
+			return err
+		}
+	}
+
+	msg.MsgState = WAITFORCONFIRM
+	msg.FireflyTranID = fireflyTranID
+	msg.PayloadHash = hash
+	msg.PayloadCollection = ""
+	msg.Variables = nil
+	if len(pending) > 0 {
+		msg.Variables = pending
+	}
+	if private {
+		if msg.ReceiveMspID == "" {
+			return denied("Participant %s is not bound yet, its private payload cannot be stored", def.ReceiveParticipant)
+		}
+		msg.PayloadCollection = PairCollection(msg.SendMspID, msg.ReceiveMspID)
+		if err := cc.putPrivatePayload(ctx, instanceID, msg, privatePayload); err != nil {
+			return err
+		}
+	}
+	msgJSON, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	key, err := elementKey(ctx, instanceID, messageID)
+	if err != nil {
+		return err
+	}
+	if err := stub.PutState(key, msgJSON); err != nil {
+		return err
+	}
+	if err := cc.updateWorklist(ctx, instanceID, msg, ENABLE); err != nil {
+		return err
+	}
+
+	return stub.SetEvent(messageID, []byte(fmt.Sprintf("%s need to be confirm", messageID)))
+}
+
+// ConfirmMessage is called by the receiver once the message has arrived through FireFly.
+// payloadHash is the hash of the payload as received and has to match the one recorded on send.
+func (cc *SmartContract) ConfirmMessage(ctx contractapi.TransactionContextInterface, instanceID string, messageID string, payloadHash string) error {
+	_, chor, err := cc.runningInstance(ctx, instanceID)
+	if err != nil {
+		return err
+	}
+	def, ok := chor.Messages[messageID]
+	if !ok {
+		return fmt.Errorf("Message %s is not part of choreography %s", messageID, chor.ChoreographyID)
+	}
+	msg, err := cc.ReadMsg(ctx, instanceID, messageID)
+	if err != nil {
+		return err
+	}
+
+	if msg.MsgState != WAITFORCONFIRM {
+		errorMessage := fmt.Sprintf("Msg state %s is not allowed", msg.MessageID)
+		fmt.Println(errorMessage)
+		return errors.New(errorMessage)
+	}
+
+	if err := cc.authorizeParticipant(ctx, instanceID, def.ReceiveParticipant); err != nil {
+		return err
+	}
+
+	// 双方对消息内容的一致确认
+	hash, err := NormalizePayloadHash(payloadHash)
+	if err != nil {
+		return err
+	}
+	if hash != msg.PayloadHash {
+		errorMessage := fmt.Sprintf("Payload hash %s does not match %s sent with message %s", hash, msg.PayloadHash, messageID)
+		fmt.Println(errorMessage)
+		return errors.New(errorMessage)
+	}
+
+	if len(msg.Variables) > 0 {
+		memory, err := cc.ReadMemory(ctx, instanceID)
+		if err != nil {
+			return err
+		}
+		for name, value := range msg.Variables {
+			memory[name] = value
+		}
+		if err := cc.putMemory(ctx, instanceID, memory); err != nil {
+			return err
+		}
+	}
+	if err := cc.changeMsgState(ctx, instanceID, messageID, DONE); err != nil {
+		return err
+	}
+	ctx.GetStub().SetEvent(messageID, []byte(fmt.Sprintf("%s has been done", messageID)))
+
+	task := chor.Elements[def.TaskID]
+
+	// 任务的返回消息
+	for i, id := range task.Messages {
+		if id != messageID {
+			continue
+		}
+		if i+1 < len(task.Messages) {
+			return cc.changeMsgState(ctx, instanceID, task.Messages[i+1], ENABLE)
+		}
+	}
+
+	return cc.leaveElement(ctx, instanceID, chor, task.ElementID)
+}
+
+// chooseAlternative settles the race of a preceding event-based gateway when the first
+// message of one of its choreography tasks is sent: the gateway records the flow of that
+// task as chosen and the other tasks are disabled in the same transaction. Sending on another
+// branch afterwards fails. The choice stands when the message is rejected or retracted,
+// the sender then resends on the same branch.
+func (cc *SmartContract) chooseAlternative(ctx contractapi.TransactionContextInterface, instanceID string, chor *Choreography, messageID string) error {
+	task := chor.Elements[chor.Messages[messageID].TaskID]
+	if task.Messages[0] != messageID {
+		return nil
+	}
+
+	for _, inID := range task.Incoming {
+		el := chor.Elements[chor.Flows[inID].SourceRef]
+		if el.Type != EventBasedGatewayElement {
+			continue
+		}
+		gtw, err := cc.ReadGtw(ctx, instanceID, el.ElementID)
+		if err != nil {
+			return err
+		}
+		if gtw.Chosen == inID {
+			continue
+		}
+		if gtw.Chosen != "" {
+			errorMessage := fmt.Sprintf("Alternative already chosen: gateway %s took %s, %s can not be sent", el.ElementID, gtw.Chosen, messageID)
+			fmt.Println(errorMessage)
+			return errors.New(errorMessage)
+		}
+		if gtw.GatewayState != ENABLE {
+			continue
+		}
+
+		for _, outID := range el.Outgoing {
+			if outID == inID {
+				continue
+			}
+			for _, otherID := range chor.Elements[chor.Flows[outID].TargetRef].Messages {
+				if err := cc.changeMsgState(ctx, instanceID, otherID, DISABLE); err != nil {
+					return err
+				}
+			}
+		}
+		gtw.GatewayState = DONE
+		gtw.Chosen = inID
+		if err := putElement(ctx, instanceID, el.ElementID, gtw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// leaveElement passes the token to the targets of all outgoing flows
+func (cc *SmartContract) leaveElement(ctx contractapi.TransactionContextInterface, instanceID string, chor *Choreography, elementID string) error {
+	for _, flowID := range chor.Elements[elementID].Outgoing {
+		if err := cc.enableElement(ctx, instanceID, chor, flowID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// enableElement hands the token over to the target of a sequence flow
+func (cc *SmartContract) enableElement(ctx contractapi.TransactionContextInterface, instanceID string, chor *Choreography, flowID string) error {
+	elementID := chor.Flows[flowID].TargetRef
+	el, ok := chor.Elements[elementID]
+	if !ok {
+		return fmt.Errorf("Element %s does not exist", elementID)
+	}
+
+	switch el.Type {
+	case ChoreographyTaskElement:
+		return cc.changeMsgState(ctx, instanceID, el.Messages[0], ENABLE)
+	case ExclusiveGatewayElement, EventBasedGatewayElement:
+		if err := cc.changeGtwState(ctx, instanceID, elementID, ENABLE); err != nil {
+			return err
+		}
+		return cc.executeGateway(ctx, instanceID, chor, elementID)
+	case ParallelGatewayElement, InclusiveGatewayElement:
+		return cc.joinGateway(ctx, instanceID, chor, elementID, flowID)
+	case EndEventElement:
+		if err := cc.changeEventState(ctx, instanceID, elementID, ENABLE); err != nil {
+			return err
+		}
+		return cc.executeEndEvent(ctx, instanceID, elementID)
+	}
+
+	return fmt.Errorf("Element %s can not be enabled", elementID)
+}
+
+// joinGateway records the token arriving at a parallel or inclusive gateway on one of its
+// incoming flows. The gateway waits in ENABLE until the tokens it expects have arrived, then
+// fires: a parallel join expects one on every incoming flow, an inclusive join one per branch
+// its split activated. A gateway with one incoming flow fires at once.
+func (cc *SmartContract) joinGateway(ctx contractapi.TransactionContextInterface, instanceID string, chor *Choreography, gatewayID string, flowID string) error {
+	gtw, err := cc.ReadGtw(ctx, instanceID, gatewayID)
+	if err != nil {
+		return err
+	}
+	if contains(gtw.Arrived, flowID) {
+		errorMessage := fmt.Sprintf("Gateway %s has already received the token on %s", gatewayID, flowID)
+		fmt.Println(errorMessage)
+		return errors.New(errorMessage)
+	}
+
+	el := chor.Elements[gatewayID]
+	expected := len(el.Incoming)
+	if el.Type == InclusiveGatewayElement && expected > 1 {
+		expected = gtw.Expected
+	}
+
+	gtw.Arrived = append(gtw.Arrived, flowID)
+	sort.Strings(gtw.Arrived)
+	gtw.GatewayState = ENABLE
+	if len(gtw.Arrived) < expected {
+		return putElement(ctx, instanceID, gatewayID, gtw)
+	}
+
+	// 所有分支都已到达，为下一次经过清空记录
+	gtw.Arrived = nil
+	gtw.Expected = 0
+	if err := putElement(ctx, instanceID, gatewayID, gtw); err != nil {
+		return err
+	}
+	return cc.executeGateway(ctx, instanceID, chor, gatewayID)
+}
+
+func (cc *SmartContract) executeGateway(ctx contractapi.TransactionContextInterface, instanceID string, chor *Choreography, gatewayID string) error {
+	gtw, err := cc.ReadGtw(ctx, instanceID, gatewayID)
+	if err != nil {
+		return err
+	}
+	if gtw.GatewayState != ENABLE {
+		errorMessage := fmt.Sprintf("Gateway state %s is not allowed", gtw.GatewayID)
+		fmt.Println(errorMessage)
+		return errors.New(errorMessage)
+	}
+
+	el := chor.Elements[gatewayID]
+	if el.Type == EventBasedGatewayElement {
+		// 网关保持ENABLE，直到第一个分支的消息被发送
+		gtw.Chosen = ""
+		if err := putElement(ctx, instanceID, gatewayID, gtw); err != nil {
+			return err
+		}
+		return cc.leaveElement(ctx, instanceID, chor, gatewayID)
+	}
+
+	if err := cc.changeGtwState(ctx, instanceID, gatewayID, DONE); err != nil {
+		return err
+	}
+	ctx.GetStub().SetEvent(gatewayID, []byte(fmt.Sprintf("%s has been done", gatewayID)))
+
+	var memory StateMemory
+	if el.Type == ExclusiveGatewayElement || el.Type == InclusiveGatewayElement {
+		if memory, err = cc.ReadMemory(ctx, instanceID); err != nil {
+			return err
+		}
+		if el.DecisionRef != "" {
+			// 决策的输出写入流程变量，供分支条件读取
+			if err := cc.applyDecision(ctx, el.DecisionRef, memory, memory); err != nil {
+				return err
+			}
+			if err := cc.putMemory(ctx, instanceID, memory); err != nil {
+				return err
+			}
+		}
+	}
+
+	switch el.Type {
+	case ExclusiveGatewayElement:
+		flow, err := selectExclusiveFlow(chor, el, memory)
+		if err != nil {
+			return err
+		}
+		return cc.enableElement(ctx, instanceID, chor, flow.FlowID)
+	case ParallelGatewayElement:
+		return cc.leaveElement(ctx, instanceID, chor, gatewayID)
+	case InclusiveGatewayElement:
+		flows, err := selectInclusiveFlows(chor, el, memory)
+		if err != nil {
+			return err
+		}
+		if el.Join != "" {
+			// 汇合网关只等待被激活的分支
+			join, err := cc.ReadGtw(ctx, instanceID, el.Join)
+			if err != nil {
+				return err
+			}
+			join.Expected = len(flows)
+			if err := putElement(ctx, instanceID, el.Join, join); err != nil {
+				return err
+			}
+		}
+		for _, flow := range flows {
+			if err := cc.enableElement(ctx, instanceID, chor, flow.FlowID); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return fmt.Errorf("Element %s is not a gateway", gatewayID)
+}
+
+// selectExclusiveFlow picks the first outgoing flow whose condition holds on the
+// persisted process variables, falling back to the default flow
+func selectExclusiveFlow(chor *Choreography, gtw *FlowElement, memory StateMemory) (*SequenceFlow, error) {
+	if len(gtw.Outgoing) == 1 {
+		return chor.Flows[gtw.Outgoing[0]], nil
+	}
+
+	for _, flowID := range gtw.Outgoing {
+		if flowID == gtw.Default {
+			continue
+		}
+		flow := chor.Flows[flowID]
+		ok, err := evaluateCondition(flow.Condition, memory)
+		if err != nil {
+			return nil, fmt.Errorf("condition of flow %s: %v", flowID, err)
+		}
+		if ok {
+			return flow, nil
+		}
+	}
+
+	if gtw.Default != "" {
+		return chor.Flows[gtw.Default], nil
+	}
+	return nil, fmt.Errorf("no outgoing flow of gateway %s matches", gtw.ElementID)
+}
+
+// selectInclusiveFlows picks every outgoing flow whose condition holds, the only flow of a
+// gateway needing none. The default flow is only taken if no other flow is.
+func selectInclusiveFlows(chor *Choreography, gtw *FlowElement, memory StateMemory) ([]*SequenceFlow, error) {
+	var flows []*SequenceFlow
+	for _, flowID := range gtw.Outgoing {
+		if flowID == gtw.Default {
+			continue
+		}
+		flow := chor.Flows[flowID]
+		if strings.TrimSpace(flow.Condition) == "" {
+			flows = append(flows, flow)
+			continue
+		}
+		ok, err := evaluateCondition(flow.Condition, memory)
+		if err != nil {
+			return nil, fmt.Errorf("condition of flow %s: %v", flowID, err)
+		}
+		if ok {
+			flows = append(flows, flow)
+		}
+	}
+
+	if len(flows) == 0 {
+		if gtw.Default == "" {
+			return nil, fmt.Errorf("no outgoing flow of gateway %s matches", gtw.ElementID)
+		}
+		flows = append(flows, chor.Flows[gtw.Default])
+	}
+	return flows, nil
+}
+
+func (cc *SmartContract) executeEndEvent(ctx contractapi.TransactionContextInterface, instanceID string, eventID string) error {
+	event, err := cc.ReadEvent(ctx, instanceID, eventID)
+	if err != nil {
+		return err
+	}
+	if event.EventState != ENABLE {
+		errorMessage := fmt.Sprintf("Event state %s is not allowed", event.EventID)
+		fmt.Println(errorMessage)
+		return errors.New(errorMessage)
+	}
+
+	if err := cc.changeEventState(ctx, instanceID, eventID, DONE); err != nil {
+		return err
+	}
+	return ctx.GetStub().SetEvent(eventID, []byte(fmt.Sprintf("%s has been done", eventID)))
+}
+
+// evaluateCondition evaluates the condition expression of a sequence flow against the
+// persisted process variables
+func evaluateCondition(condition string, memory StateMemory) (bool, error) {
+	expr, err := ParseExpression(condition)
+	if err != nil {
+		return false, err
+	}
+	return expr.Evaluate(memory)
+}
+
+// processVariables lists the process variables read by the gateway conditions of a
+// choreography and by the decisions its gateways and messages reference
+func (cc *SmartContract) processVariables(ctx contractapi.TransactionContextInterface, chor *Choreography) (map[string]bool, error) {
+	variables := conditionVariables(chor)
+	refs := chor.DecisionRefs()
+	ids := make([]string, 0, len(refs))
+	for id := range refs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		decision, err := cc.ReadDecision(ctx, refs[id])
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range decision.Variables() {
+			variables[name] = true
+		}
+	}
+	return variables, nil
+}
+
+// sortedFieldNames lists the fields of a payload in a stable order
+func sortedFieldNames(payload map[string]interface{}) []string {
+	names := make([]string, 0, len(payload))
+	for name := range payload {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// conditionVariables lists the process variables read by the gateway conditions of a choreography
+func conditionVariables(chor *Choreography) map[string]bool {
+	variables := make(map[string]bool)
+	for _, flow := range chor.Flows {
+		if flow.Condition == "" {
+			continue
+		}
+		// 部署时已校验过条件表达式
+		expr, err := ParseExpression(flow.Condition)
+		if err != nil {
+			continue
+		}
+		for _, name := range expr.Variables() {
+			variables[name] = true
+		}
+	}
+	return variables
+}
@@ -0,0 +1,1326 @@
+package chaincode
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"chaincode-go-bpmn/chaincode/mocks"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	clientMsp = "ClientMSP"
+	hotelMsp  = "HotelMSP"
+	adminID   = "x509::CN=admin,OU=admin::CN=ca.hotel"
+
+	hotelBooking = "Choreography_hotel_booking"
+	bindings     = `{"Participant_1080bkg":"ClientMSP","Participant_0sktaei":"HotelMSP"}`
+	checkRoom    = `{"date":"2024-05-01","bedrooms":2}`
+)
+
+// newWorldState backs the fake stub with an in-memory key/value store
+func newWorldState() (*mocks.TransactionContext, *mocks.ClientIdentity, map[string][]byte) {
+	state := make(map[string][]byte)
+	chaincodeStub := &mocks.ChaincodeStub{}
+	chaincodeStub.GetStateStub = func(key string) ([]byte, error) {
+		return state[key], nil
+	}
+	chaincodeStub.PutStateStub = func(key string, value []byte) error {
+		state[key] = value
+		return nil
+	}
+	chaincodeStub.DelStateStub = func(key string) error {
+		delete(state, key)
+		return nil
+	}
+	chaincodeStub.CreateCompositeKeyStub = shim.CreateCompositeKey
+	chaincodeStub.GetTxIDStub = func() string {
+		return fmt.Sprintf("tx%d", chaincodeStub.GetTxIDCallCount())
+	}
+	chaincodeStub.GetStateByPartialCompositeKeyStub = func(objectType string, attributes []string) (shim.StateQueryIteratorInterface, error) {
+		prefix, err := shim.CreateCompositeKey(objectType, attributes)
+		if err != nil {
+			return nil, err
+		}
+		var keys []string
+		for key := range state {
+			if strings.HasPrefix(key, prefix) {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+
+		iterator := &mocks.StateQueryIterator{}
+		iterator.HasNextStub = func() bool {
+			return iterator.NextCallCount() < len(keys)
+		}
+		iterator.NextStub = func() (*queryresult.KV, error) {
+			key := keys[iterator.NextCallCount()-1]
+			return &queryresult.KV{Key: key, Value: state[key]}, nil
+		}
+		return iterator, nil
+	}
+
+	// private data lives next to the world state, one map per collection
+	privateData := make(map[string]map[string][]byte)
+	chaincodeStub.PutPrivateDataStub = func(collection string, key string, value []byte) error {
+		if privateData[collection] == nil {
+			privateData[collection] = make(map[string][]byte)
+		}
+		privateData[collection][key] = value
+		return nil
+	}
+	chaincodeStub.GetPrivateDataStub = func(collection string, key string) ([]byte, error) {
+		return privateData[collection][key], nil
+	}
+	chaincodeStub.DelPrivateDataStub = func(collection string, key string) error {
+		delete(privateData[collection], key)
+		return nil
+	}
+
+	clientIdentity := &mocks.ClientIdentity{}
+	transactionContext := &mocks.TransactionContext{}
+	transactionContext.GetStubReturns(chaincodeStub)
+	transactionContext.GetClientIdentityReturns(clientIdentity)
+	return transactionContext, clientIdentity, state
+}
+
+// deployHotelBooking deploys the hotel booking definition and creates one instance of it
+func deployHotelBooking(t *testing.T) (*SmartContract, *mocks.TransactionContext, *mocks.ClientIdentity, string) {
+	ctx, identity, _ := newWorldState()
+	identity.GetIDReturns(adminID, nil)
+	identity.GetMSPIDReturns(hotelMsp, nil)
+	cc := &SmartContract{}
+	require.NoError(t, cc.InitLedger(ctx))
+	instanceID, err := cc.CreateInstance(ctx, hotelBooking, bindings)
+	require.NoError(t, err)
+	return cc, ctx, identity, instanceID
+}
+
+// hashOf returns the canonical payload hash a client would send along with the payload
+func hashOf(t *testing.T, payloadJSON string) string {
+	hash, err := CanonicalPayloadHash(payloadJSON)
+	require.NoError(t, err)
+	return hash
+}
+
+func requireMsgState(t *testing.T, cc *SmartContract, ctx contractapi.TransactionContextInterface, instanceID string, messageID string, state ElementState) {
+	msg, err := cc.ReadMsg(ctx, instanceID, messageID)
+	require.NoError(t, err)
+	require.Equal(t, state, msg.MsgState, messageID)
+}
+
+// deliver sends a message as the sender MSP and confirms it as the receiver MSP
+func deliver(t *testing.T, cc *SmartContract, ctx *mocks.TransactionContext, identity *mocks.ClientIdentity, instanceID string, sender string, receiver string, messageID string, payload string) {
+	identity.GetMSPIDReturns(sender, nil)
+	require.NoError(t, cc.SendMessage(ctx, instanceID, messageID, "tx_"+messageID, payload, hashOf(t, payload)))
+	identity.GetMSPIDReturns(receiver, nil)
+	require.NoError(t, cc.ConfirmMessage(ctx, instanceID, messageID, hashOf(t, payload)))
+}
+
+func TestCreateMessage(t *testing.T) {
+	chaincodeStub := &mocks.ChaincodeStub{}
+	transactionContext := &mocks.TransactionContext{}
+	transactionContext.GetStubReturns(chaincodeStub)
+
+	cc := &SmartContract{}
+	_, err := cc.createMessage(transactionContext, "instance1", "", "", "", "", 0, "")
+	require.NoError(t, err)
+
+	chaincodeStub.GetStateReturns([]byte{}, nil)
+	_, err = cc.createMessage(transactionContext, "instance1", "message1", "", "", "", 0, "")
+	require.EqualError(t, err, "消息 message1 已存在")
+
+	chaincodeStub.GetStateReturns(nil, fmt.Errorf("unable to retrieve asset"))
+	_, err = cc.createMessage(transactionContext, "instance1", "asset1", "", "", "", 0, "")
+	require.EqualError(t, err, "获取状态数据时出错: unable to retrieve asset")
+}
+
+func TestHotelBookingFlow(t *testing.T) {
+	cc, ctx, identity, instanceID := deployHotelBooking(t)
+	sent := make(map[string]string)
+	send := func(msp, messageID, payload string) error {
+		identity.GetMSPIDReturns(msp, nil)
+		sent[messageID] = hashOf(t, payload)
+		return cc.SendMessage(ctx, instanceID, messageID, "tx_"+messageID, payload, sent[messageID])
+	}
+	confirm := func(msp, messageID string) error {
+		identity.GetMSPIDReturns(msp, nil)
+		return cc.ConfirmMessage(ctx, instanceID, messageID, sent[messageID])
+	}
+
+	require.EqualError(t, cc.StartChoreography(ctx, "unknown"), "Instance unknown does not exist")
+	require.NoError(t, cc.StartChoreography(ctx, instanceID))
+	requireMsgState(t, cc, ctx, instanceID, "Message_045i10y", ENABLE)
+
+	require.EqualError(t, send(hotelMsp, "Message_045i10y", ""), "Msp denied: participant Participant_1080bkg is bound to ClientMSP, not HotelMSP")
+	require.EqualError(t, send(clientMsp, "Message_unknown", ""), "Message Message_unknown is not part of choreography Choreography_hotel_booking")
+	require.EqualError(t, confirm(hotelMsp, "Message_045i10y"), "Msg state Message_045i10y is not allowed")
+
+	require.NoError(t, send(clientMsp, "Message_045i10y", checkRoom))
+	requireMsgState(t, cc, ctx, instanceID, "Message_045i10y", WAITFORCONFIRM)
+	require.EqualError(t, confirm(clientMsp, "Message_045i10y"), "Msp denied: participant Participant_0sktaei is bound to HotelMSP, not ClientMSP")
+	require.NoError(t, confirm(hotelMsp, "Message_045i10y"))
+	requireMsgState(t, cc, ctx, instanceID, "Message_0r9lypd", ENABLE)
+
+	// no room available: the gateway loops back to Check_room
+	require.NoError(t, send(hotelMsp, "Message_0r9lypd", `{"confirm":false}`))
+	require.NoError(t, confirm(clientMsp, "Message_0r9lypd"))
+	requireMsgState(t, cc, ctx, instanceID, "Message_045i10y", ENABLE)
+	requireMsgState(t, cc, ctx, instanceID, "Message_1em0ee4", DISABLE)
+
+	memory, err := cc.ReadMemory(ctx, instanceID)
+	require.NoError(t, err)
+	require.Equal(t, StateMemory{"date": "2024-05-01", "bedrooms": json.Number("2"), "confirm": false}, memory)
+
+	require.NoError(t, send(clientMsp, "Message_045i10y", `{"date":"2024-05-02","bedrooms":2}`))
+	require.NoError(t, confirm(hotelMsp, "Message_045i10y"))
+	require.NoError(t, send(hotelMsp, "Message_0r9lypd", `{"confirm":true}`))
+	require.NoError(t, confirm(clientMsp, "Message_0r9lypd"))
+	requireMsgState(t, cc, ctx, instanceID, "Message_1em0ee4", ENABLE)
+
+	require.NoError(t, send(hotelMsp, "Message_1em0ee4", `{"quotation":300}`))
+	require.NoError(t, confirm(clientMsp, "Message_1em0ee4"))
+	require.NoError(t, send(clientMsp, "Message_1nlagx2", `{"confirmation":true}`))
+	require.NoError(t, confirm(hotelMsp, "Message_1nlagx2"))
+
+	// event-based gateway: both alternatives are offered
+	requireMsgState(t, cc, ctx, instanceID, "Message_0o8eyir", ENABLE)
+	requireMsgState(t, cc, ctx, instanceID, "Message_1xm9dxy", ENABLE)
+
+	gtw, err := cc.ReadGtw(ctx, instanceID, "EventBasedGateway_1fxpmyn")
+	require.NoError(t, err)
+	require.Equal(t, ElementState(ENABLE), gtw.GatewayState)
+
+	// sending the payment decides the race, before it is confirmed
+	require.NoError(t, send(clientMsp, "Message_0o8eyir", `{"to":"0x5B38Da6a701c568545dCfcB03FcB875f56beddC4"}`))
+	requireMsgState(t, cc, ctx, instanceID, "Message_1xm9dxy", DISABLE)
+	requireMsgState(t, cc, ctx, instanceID, "Message_1ljlm4g", DISABLE)
+	gtw, err = cc.ReadGtw(ctx, instanceID, "EventBasedGateway_1fxpmyn")
+	require.NoError(t, err)
+	require.Equal(t, ElementState(DONE), gtw.GatewayState)
+	require.Equal(t, "SequenceFlow_0vbqdqk", gtw.Chosen)
+	require.EqualError(t, send(clientMsp, "Message_1xm9dxy", `{"motivation":"too late"}`), "Alternative already chosen: gateway EventBasedGateway_1fxpmyn took SequenceFlow_0vbqdqk, Message_1xm9dxy can not be sent")
+
+	require.NoError(t, confirm(hotelMsp, "Message_0o8eyir"))
+	requireMsgState(t, cc, ctx, instanceID, "Message_1ljlm4g", ENABLE)
+
+	require.NoError(t, send(hotelMsp, "Message_1ljlm4g", `{"bookingId":"B-42"}`))
+	require.NoError(t, confirm(clientMsp, "Message_1ljlm4g"))
+	require.NoError(t, send(clientMsp, "Message_0m9p3da", `{"cancel":false}`))
+	require.NoError(t, confirm(hotelMsp, "Message_0m9p3da"))
+
+	event, err := cc.ReadEvent(ctx, instanceID, "EndEvent_08edp7f")
+	require.NoError(t, err)
+	require.Equal(t, ElementState(DONE), event.EventState)
+	requireMsgState(t, cc, ctx, instanceID, "Message_1joj7ca", DISABLE)
+}
+
+func TestProcessVariablesPersisted(t *testing.T) {
+	cc, ctx, identity, instanceID := deployHotelBooking(t)
+	require.NoError(t, cc.StartChoreography(ctx, instanceID))
+	identity.GetMSPIDReturns(clientMsp, nil)
+	require.NoError(t, cc.SendMessage(ctx, instanceID, "Message_045i10y", "tx1", checkRoom, hashOf(t, checkRoom)))
+	identity.GetMSPIDReturns(hotelMsp, nil)
+	require.NoError(t, cc.ConfirmMessage(ctx, instanceID, "Message_045i10y", hashOf(t, checkRoom)))
+	require.NoError(t, cc.SendMessage(ctx, instanceID, "Message_0r9lypd", "tx2", `{"confirm":true}`, hashOf(t, `{"confirm":true}`)))
+
+	// the gateway is decided by another contract object, as on a restarted or different peer
+	other := &SmartContract{}
+	identity.GetMSPIDReturns(clientMsp, nil)
+	require.NoError(t, other.ConfirmMessage(ctx, instanceID, "Message_0r9lypd", hashOf(t, `{"confirm":true}`)))
+	requireMsgState(t, cc, ctx, instanceID, "Message_1em0ee4", ENABLE)
+
+	// variables are not shared between instances
+	second, err := cc.CreateInstance(ctx, hotelBooking, bindings)
+	require.NoError(t, err)
+	memory, err := cc.ReadMemory(ctx, second)
+	require.NoError(t, err)
+	require.Empty(t, memory)
+}
+
+func TestConcurrentInstances(t *testing.T) {
+	cc, ctx, identity, first := deployHotelBooking(t)
+	second, err := cc.CreateInstance(ctx, hotelBooking, bindings)
+	require.NoError(t, err)
+	require.NotEqual(t, first, second)
+
+	require.NoError(t, cc.StartChoreography(ctx, first))
+	identity.GetMSPIDReturns(clientMsp, nil)
+	require.NoError(t, cc.SendMessage(ctx, first, "Message_045i10y", "tx1", checkRoom, hashOf(t, checkRoom)))
+
+	// the second booking is untouched until it is started itself
+	requireMsgState(t, cc, ctx, first, "Message_045i10y", WAITFORCONFIRM)
+	requireMsgState(t, cc, ctx, second, "Message_045i10y", DISABLE)
+	require.EqualError(t, cc.SendMessage(ctx, second, "Message_045i10y", "tx2", "", ""), "Msg state Message_045i10y is not allowed")
+
+	require.NoError(t, cc.StartChoreography(ctx, second))
+	require.NoError(t, cc.SendMessage(ctx, second, "Message_045i10y", "tx2", checkRoom, hashOf(t, checkRoom)))
+	require.EqualError(t, cc.StartChoreography(ctx, second), "Event state StartEvent_1jtgn3j is not allowed")
+
+	instance, err := cc.ReadInstance(ctx, second)
+	require.NoError(t, err)
+	require.Equal(t, hotelBooking, instance.DefinitionID)
+	hotel, err := cc.ReadParticipant(ctx, second, "Participant_0sktaei")
+	require.NoError(t, err)
+	require.Equal(t, hotelMsp, hotel.MspID)
+}
+
+func TestCreateInstanceErrors(t *testing.T) {
+	cc, ctx, _, _ := deployHotelBooking(t)
+
+	_, err := cc.CreateInstance(ctx, "Choreography_unknown", bindings)
+	require.EqualError(t, err, "Choreography Choreography_unknown has not been deployed")
+
+	_, err = cc.CreateInstance(ctx, hotelBooking, `{"Participant_1080bkg":"ClientMSP"}`)
+	require.EqualError(t, err, "Participant Participant_0sktaei is not bound to an MSP")
+
+	_, err = cc.CreateInstance(ctx, hotelBooking, `{"Participant_1080bkg":"ClientMSP","Participant_0sktaei":"HotelMSP","Participant_x":"XMSP"}`)
+	require.EqualError(t, err, "Participant Participant_x is not part of choreography Choreography_hotel_booking")
+
+	_, err = cc.CreateInstance(ctx, hotelBooking, `{"Participant_1080bkg":"ClientMSP","Client":"ClientMSP","Hotel":"HotelMSP"}`)
+	require.ErrorContains(t, err, "Participant Participant_1080bkg is bound twice")
+
+	_, err = cc.CreateInstance(ctx, hotelBooking, `{"Client":{"role":"client"},"Hotel":"HotelMSP"}`)
+	require.EqualError(t, err, "Participant Participant_1080bkg is not bound to an MSP")
+
+	_, err = cc.CreateInstance(ctx, hotelBooking, "ClientMSP")
+	require.ErrorContains(t, err, "participant bindings are not a JSON object")
+}
+
+func TestParticipantRegistry(t *testing.T) {
+	cc, ctx, identity, _ := deployHotelBooking(t)
+
+	// participants are bound by name, the organizations are not named after the model
+	instanceID, err := cc.CreateInstance(ctx, hotelBooking, `{"Client":"Org1MSP","Hotel":{"mspID":"Org2MSP","role":"hotel-chain"}}`)
+	require.NoError(t, err)
+	participants, err := cc.GetParticipants(ctx, instanceID)
+	require.NoError(t, err)
+	require.Equal(t, []*ParticipantBinding{
+		{DocType: ParticipantDocType, InstanceID: instanceID, ParticipantID: "Participant_0sktaei", Name: "Hotel", Role: "hotel-chain", MspID: "Org2MSP"},
+		{DocType: ParticipantDocType, InstanceID: instanceID, ParticipantID: "Participant_1080bkg", Name: "Client", Role: "client", MspID: "Org1MSP"},
+	}, participants)
+	_, err = cc.ReadParticipant(ctx, instanceID, "Participant_x")
+	require.EqualError(t, err, "Participant Participant_x is not bound to an MSP")
+
+	require.NoError(t, cc.StartChoreography(ctx, instanceID))
+	msg, err := cc.ReadMsg(ctx, instanceID, "Message_045i10y")
+	require.NoError(t, err)
+	require.Equal(t, "Org1MSP", msg.SendMspID)
+	require.Equal(t, "Org2MSP", msg.ReceiveMspID)
+
+	identity.GetMSPIDReturns(clientMsp, nil)
+	require.EqualError(t, cc.SendMessage(ctx, instanceID, "Message_045i10y", "tx1", checkRoom, hashOf(t, checkRoom)), "Msp denied: participant Participant_1080bkg is bound to Org1MSP, not ClientMSP")
+	identity.GetMSPIDReturns("Org1MSP", nil)
+	require.NoError(t, cc.SendMessage(ctx, instanceID, "Message_045i10y", "tx1", checkRoom, hashOf(t, checkRoom)))
+
+	// authorization resolves through the registry, not the copy on the message
+	hotel, err := cc.ReadParticipant(ctx, instanceID, "Participant_0sktaei")
+	require.NoError(t, err)
+	hotel.MspID = "Org3MSP"
+	require.NoError(t, cc.putParticipant(ctx, hotel))
+	identity.GetMSPIDReturns("Org2MSP", nil)
+	require.EqualError(t, cc.ConfirmMessage(ctx, instanceID, "Message_045i10y", hashOf(t, checkRoom)), "Msp denied: participant Participant_0sktaei is bound to Org3MSP, not Org2MSP")
+	identity.GetMSPIDReturns("Org3MSP", nil)
+	require.NoError(t, cc.ConfirmMessage(ctx, instanceID, "Message_045i10y", hashOf(t, checkRoom)))
+}
+
+func TestLateBinding(t *testing.T) {
+	cc, ctx, identity, _ := deployHotelBooking(t)
+
+	for participantBindings, expected := range map[string]string{
+		`{"Client":"ClientMSP","Hotel":{"bindBy":"Hotel"}}`:                   `Participant Participant_0sktaei cannot bind itself, use "self" to bind it on its first message`,
+		`{"Client":"ClientMSP","Hotel":{"bindBy":"Agency"}}`:                  "Participant Agency is not part of choreography Choreography_hotel_booking",
+		`{"Client":"ClientMSP","Hotel":{"mspID":"HotelMSP","bindBy":"self"}}`: "Participant Participant_0sktaei is bound to HotelMSP and cannot be bound late",
+		`{"Client":"ClientMSP","Hotel":{"bindBy":"self"}}`:                    "Participant Participant_0sktaei may receive message Message_045i10y before it sends one and cannot bind itself",
+	} {
+		_, err := cc.CreateInstance(ctx, hotelBooking, participantBindings)
+		require.EqualError(t, err, expected)
+	}
+
+	// the client picks the hotel
+	instanceID, err := cc.CreateInstance(ctx, hotelBooking, `{"Client":"ClientMSP","Hotel":{"bindBy":"Client","candidates":["HotelMSP","Org3MSP"]}}`)
+	require.NoError(t, err)
+	require.NoError(t, cc.StartChoreography(ctx, instanceID))
+	identity.GetMSPIDReturns(clientMsp, nil)
+	require.NoError(t, cc.SendMessage(ctx, instanceID, "Message_045i10y", "tx1", checkRoom, hashOf(t, checkRoom)))
+	msg, err := cc.ReadMsg(ctx, instanceID, "Message_045i10y")
+	require.NoError(t, err)
+	require.Empty(t, msg.ReceiveMspID)
+
+	identity.GetMSPIDReturns(hotelMsp, nil)
+	require.EqualError(t, cc.ConfirmMessage(ctx, instanceID, "Message_045i10y", hashOf(t, checkRoom)), "Participant Participant_0sktaei is not bound yet")
+	require.EqualError(t, cc.BindParticipant(ctx, instanceID, "Hotel", "HotelMSP"), "Msp denied: participant Participant_1080bkg is bound to ClientMSP, not HotelMSP")
+	items, err := cc.GetMyWorklist(ctx, instanceID)
+	require.NoError(t, err)
+	require.Empty(t, items)
+
+	// nobody is bound while the instance is suspended
+	identity.GetMSPIDReturns(clientMsp, nil)
+	require.NoError(t, cc.SuspendInstance(ctx, instanceID, ""))
+	require.EqualError(t, cc.BindParticipant(ctx, instanceID, "Hotel", "HotelMSP"), fmt.Sprintf("Instance %s is suspended", instanceID))
+	require.NoError(t, cc.ResumeInstance(ctx, instanceID, ""))
+
+	require.EqualError(t, cc.BindParticipant(ctx, instanceID, "Hotel", "Org4MSP"), "Msp denied: participant Participant_0sktaei may be bound to HotelMSP, Org3MSP, not Org4MSP")
+	require.NoError(t, cc.BindParticipant(ctx, instanceID, "Hotel", `{"mspID":"HotelMSP","role":"hotel"}`))
+	require.EqualError(t, cc.BindParticipant(ctx, instanceID, "Hotel", "Org3MSP"), "Participant Participant_0sktaei is already bound to HotelMSP")
+
+	// the binding fills in the messages and the worklist of the hotel
+	msg, err = cc.ReadMsg(ctx, instanceID, "Message_045i10y")
+	require.NoError(t, err)
+	require.Equal(t, hotelMsp, msg.ReceiveMspID)
+	identity.GetMSPIDReturns(hotelMsp, nil)
+	items, err = cc.GetMyWorklist(ctx, instanceID)
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+	require.Equal(t, "ConfirmMessage", items[0].Transaction)
+	require.NoError(t, cc.ConfirmMessage(ctx, instanceID, "Message_045i10y", hashOf(t, checkRoom)))
+
+	// the client is whoever sends the first message
+	instanceID, err = cc.CreateInstance(ctx, hotelBooking, `{"Client":{"bindBy":"self","candidates":["ClientMSP","Org3MSP"]},"Hotel":"HotelMSP"}`)
+	require.NoError(t, err)
+	require.NoError(t, cc.StartChoreography(ctx, instanceID))
+	identity.GetMSPIDReturns(hotelMsp, nil)
+	require.EqualError(t, cc.SendMessage(ctx, instanceID, "Message_045i10y", "tx1", checkRoom, hashOf(t, checkRoom)), "Msp denied: participant Participant_1080bkg may be bound to ClientMSP, Org3MSP, not HotelMSP")
+	items, err = cc.GetMyWorklist(ctx, instanceID)
+	require.NoError(t, err)
+	require.Empty(t, items)
+	// every candidate sees the first message until one of them sends it
+	for _, mspID := range []string{clientMsp, "Org3MSP"} {
+		identity.GetMSPIDReturns(mspID, nil)
+		items, err = cc.GetMyWorklist(ctx, instanceID)
+		require.NoError(t, err)
+		require.Len(t, items, 1, mspID)
+		require.Equal(t, "SendMessage", items[0].Transaction)
+	}
+	identity.GetMSPIDReturns(clientMsp, nil)
+	require.EqualError(t, cc.BindParticipant(ctx, instanceID, "Client", "ClientMSP"), "Participant Participant_1080bkg is bound by the first message it sends")
+	require.NoError(t, cc.SendMessage(ctx, instanceID, "Message_045i10y", "tx1", checkRoom, hashOf(t, checkRoom)))
+	client, err := cc.ReadParticipant(ctx, instanceID, "Participant_1080bkg")
+	require.NoError(t, err)
+	require.Equal(t, clientMsp, client.MspID)
+	for _, mspID := range []string{clientMsp, "Org3MSP"} {
+		identity.GetMSPIDReturns(mspID, nil)
+		items, err = cc.GetMyWorklist(ctx, instanceID)
+		require.NoError(t, err)
+		require.Empty(t, items, mspID)
+	}
+	identity.GetMSPIDReturns(clientMsp, nil)
+	require.EqualError(t, cc.BindParticipant(ctx, instanceID, "Client", "Org3MSP"), "Participant Participant_1080bkg is already bound to ClientMSP")
+
+	// the client binds the hotel, but keeps the requirements the instance was created with
+	instanceID, err = cc.CreateInstance(ctx, hotelBooking, `{"Client":"ClientMSP","Hotel":{"bindBy":"Client","attributes":{"role":"booking-agent"},"subjects":["CN=agent1,OU=client","CN=agent2,OU=client"]}}`)
+	require.NoError(t, err)
+	require.NoError(t, cc.StartChoreography(ctx, instanceID))
+	require.EqualError(t, cc.BindParticipant(ctx, instanceID, "Hotel", `{"mspID":"HotelMSP","attributes":{"role":"auditor"}}`),
+		"Attribute denied: participant Participant_0sktaei requires attribute role=booking-agent, not auditor")
+	require.EqualError(t, cc.BindParticipant(ctx, instanceID, "Hotel", `{"mspID":"HotelMSP","subjects":["CN=agent3,OU=client"]}`),
+		"Identity denied: subject CN=agent3,OU=client may not act for participant Participant_0sktaei")
+	require.NoError(t, cc.BindParticipant(ctx, instanceID, "Hotel", `{"mspID":"HotelMSP","attributes":{"desk":"front"},"subjects":["CN=agent1,OU=client"]}`))
+	hotel, err := cc.ReadParticipant(ctx, instanceID, "Participant_0sktaei")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"role": "booking-agent", "desk": "front"}, hotel.Attributes)
+	require.Equal(t, []string{"CN=agent1,OU=client"}, hotel.Subjects)
+	require.Empty(t, hotel.ClientIDs)
+
+	// a plain MSP ID leaves the requirements as they are
+	instanceID, err = cc.CreateInstance(ctx, hotelBooking, `{"Client":"ClientMSP","Hotel":{"bindBy":"Client","attributes":{"role":"booking-agent"}}}`)
+	require.NoError(t, err)
+	require.NoError(t, cc.StartChoreography(ctx, instanceID))
+	require.NoError(t, cc.BindParticipant(ctx, instanceID, "Hotel", "HotelMSP"))
+	hotel, err = cc.ReadParticipant(ctx, instanceID, "Participant_0sktaei")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"role": "booking-agent"}, hotel.Attributes)
+	require.NoError(t, cc.SendMessage(ctx, instanceID, "Message_045i10y", "tx1", checkRoom, hashOf(t, checkRoom)))
+	identity.GetMSPIDReturns(hotelMsp, nil)
+	identity.GetAttributeValueReturns("", false, nil)
+	require.EqualError(t, cc.ConfirmMessage(ctx, instanceID, "Message_045i10y", hashOf(t, checkRoom)),
+		"Attribute denied: participant Participant_0sktaei requires attribute role=booking-agent, the certificate has none")
+}
+
+func TestSendMessageInvalidPayload(t *testing.T) {
+	cc, ctx, identity, instanceID := deployHotelBooking(t)
+	require.NoError(t, cc.StartChoreography(ctx, instanceID))
+
+	identity.GetMSPIDReturns(clientMsp, nil)
+	err := cc.SendMessage(ctx, instanceID, "Message_045i10y", "tx1", "[1, 2]", "")
+	require.ErrorContains(t, err, "payload is not a JSON object")
+	requireMsgState(t, cc, ctx, instanceID, "Message_045i10y", ENABLE)
+
+	err = cc.SendMessage(ctx, instanceID, "Message_045i10y", "tx1", `{"date":"2024-05-01","rooms":2}`, "")
+	require.EqualError(t, err, `field rooms is not declared in format "date:string, bedrooms:uint"`)
+	requireMsgState(t, cc, ctx, instanceID, "Message_045i10y", ENABLE)
+
+	err = cc.SendMessage(ctx, instanceID, "Message_045i10y", "tx1", `{"date":"2024-05-01","bedrooms":-2}`, "")
+	require.EqualError(t, err, "field bedrooms: -2 is not a valid uint")
+	err = cc.SendMessage(ctx, instanceID, "Message_045i10y", "tx1", `{"date":"2024-05-01"}`, "")
+	require.EqualError(t, err, "field bedrooms is required")
+}
+
+func TestSendMessageSchemaPayload(t *testing.T) {
+	cc, ctx, identity, _ := deployHotelBooking(t)
+
+	// Check_room carries a nested booking request described by a JSON Schema
+	schema := `{
+		"type": "object",
+		"properties": {
+			"date": {"type": "string"},
+			"guests": {"type": "array", "minItems": 1, "items": {"$ref": "#/definitions/guest"}}
+		},
+		"required": ["date", "guests"],
+		"additionalProperties": false,
+		"definitions": {
+			"guest": {"type": "object", "properties": {"name": {"type": "string"}, "age": {"type": "integer"}}, "required": ["name"]}
+		}
+	}`
+	bpmnXML := strings.Replace(string(hotelBookingBPMN), `id="Choreography_hotel_booking"`, `id="Choreography_group_booking"`, 1)
+	bpmnXML = strings.Replace(bpmnXML,
+		`<bpmn2:message id="Message_045i10y" name="Check_room(string date, uint bedrooms)" />`,
+		`<bpmn2:message id="Message_045i10y" name="Check_room"><bpmn2:documentation textFormat="application/schema+json">`+schema+`</bpmn2:documentation></bpmn2:message>`, 1)
+	require.NoError(t, cc.DeployChoreography(ctx, bpmnXML))
+	instanceID, err := cc.CreateInstance(ctx, "Choreography_group_booking", bindings)
+	require.NoError(t, err)
+	require.NoError(t, cc.StartChoreography(ctx, instanceID))
+
+	msg, err := cc.ReadMsg(ctx, instanceID, "Message_045i10y")
+	require.NoError(t, err)
+	require.True(t, IsSchemaFormat(msg.Format))
+	require.NotContains(t, msg.Format, "\n")
+	formats, err := cc.GetAllFormats(ctx)
+	require.NoError(t, err)
+	require.Contains(t, formats, msg.Format)
+
+	identity.GetMSPIDReturns(clientMsp, nil)
+	err = cc.SendMessage(ctx, instanceID, "Message_045i10y", "tx1", `{"date":"2024-05-01","guests":[{"age":30}]}`, "")
+	require.EqualError(t, err, "payload does not match the schema: guests.0: name is required")
+	err = cc.SendMessage(ctx, instanceID, "Message_045i10y", "tx1", `{"date":"2024-05-01","guests":[],"bedrooms":2}`, "")
+	require.ErrorContains(t, err, "guests: Array must have at least 1 items")
+	require.ErrorContains(t, err, "Additional property bedrooms is not allowed")
+	requireMsgState(t, cc, ctx, instanceID, "Message_045i10y", ENABLE)
+
+	payload := `{"date":"2024-05-01","guests":[{"name":"Ada","age":36}]}`
+	require.NoError(t, cc.SendMessage(ctx, instanceID, "Message_045i10y", "tx1", payload, hashOf(t, payload)))
+	requireMsgState(t, cc, ctx, instanceID, "Message_045i10y", WAITFORCONFIRM)
+	identity.GetMSPIDReturns(hotelMsp, nil)
+	require.NoError(t, cc.ConfirmMessage(ctx, instanceID, "Message_045i10y", hashOf(t, payload)))
+	memory, err := cc.ReadMemory(ctx, instanceID)
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{map[string]interface{}{"name": "Ada", "age": json.Number("36")}}, memory["guests"])
+}
+
+func TestLargeIntegerVariables(t *testing.T) {
+	cc, ctx, identity, _ := deployHotelBooking(t)
+
+	// 9007199254740993 is 2^53+1, the nearest float64 is 9007199254740992
+	bpmnXML := strings.Replace(string(hotelBookingBPMN), `id="Choreography_hotel_booking"`, `id="Choreography_large_booking"`, 1)
+	bpmnXML = strings.Replace(bpmnXML, ">confirm = true<", ">confirm = true and bedrooms = 9007199254740993<", 1)
+	require.NoError(t, cc.DeployChoreography(ctx, bpmnXML))
+	instanceID, err := cc.CreateInstance(ctx, "Choreography_large_booking", bindings)
+	require.NoError(t, err)
+	require.NoError(t, cc.StartChoreography(ctx, instanceID))
+
+	payload := `{"date":"2024-05-01","bedrooms":9007199254740993}`
+	identity.GetMSPIDReturns(clientMsp, nil)
+	require.NoError(t, cc.SendMessage(ctx, instanceID, "Message_045i10y", "tx1", payload, hashOf(t, payload)))
+	msg, err := cc.ReadMsg(ctx, instanceID, "Message_045i10y")
+	require.NoError(t, err)
+	require.Equal(t, json.Number("9007199254740993"), msg.Variables["bedrooms"])
+	identity.GetMSPIDReturns(hotelMsp, nil)
+	require.NoError(t, cc.ConfirmMessage(ctx, instanceID, "Message_045i10y", hashOf(t, payload)))
+	memory, err := cc.ReadMemory(ctx, instanceID)
+	require.NoError(t, err)
+	require.Equal(t, json.Number("9007199254740993"), memory["bedrooms"])
+
+	deliver(t, cc, ctx, identity, instanceID, hotelMsp, clientMsp, "Message_0r9lypd", `{"confirm":true}`)
+	requireMsgState(t, cc, ctx, instanceID, "Message_1em0ee4", ENABLE)
+	requireMsgState(t, cc, ctx, instanceID, "Message_045i10y", DONE)
+}
+
+func TestPayloadHash(t *testing.T) {
+	hash, err := CanonicalPayloadHash(` { "note": "<late>", "date": "2024-05-01",  "bedrooms": 2.50 } `)
+	require.NoError(t, err)
+	sum := sha256.Sum256([]byte(`{"bedrooms":2.50,"date":"2024-05-01","note":"<late>"}`))
+	require.Equal(t, hex.EncodeToString(sum[:]), hash)
+
+	cc, ctx, identity, instanceID := deployHotelBooking(t)
+	require.NoError(t, cc.StartChoreography(ctx, instanceID))
+	identity.GetMSPIDReturns(clientMsp, nil)
+	err = cc.SendMessage(ctx, instanceID, "Message_045i10y", "tx1", checkRoom, "abc")
+	require.EqualError(t, err, `Payload hash "abc" is not a hex encoded SHA-256`)
+	requireMsgState(t, cc, ctx, instanceID, "Message_045i10y", ENABLE)
+
+	// a hash that does not match the payload is rejected
+	otherHash := hashOf(t, `{"value":"booking.pdf"}`)
+	err = cc.SendMessage(ctx, instanceID, "Message_045i10y", "tx1", checkRoom, otherHash)
+	require.EqualError(t, err, fmt.Sprintf("Payload hash %s does not match the payload", otherHash))
+	requireMsgState(t, cc, ctx, instanceID, "Message_045i10y", ENABLE)
+
+	// the hash of the payload is recorded normalized
+	require.NoError(t, cc.SendMessage(ctx, instanceID, "Message_045i10y", "tx1", checkRoom, "0x"+strings.ToUpper(hashOf(t, checkRoom))))
+	msg, err := cc.ReadMsg(ctx, instanceID, "Message_045i10y")
+	require.NoError(t, err)
+	require.Equal(t, hashOf(t, checkRoom), msg.PayloadHash)
+
+	identity.GetMSPIDReturns(hotelMsp, nil)
+	err = cc.ConfirmMessage(ctx, instanceID, "Message_045i10y", otherHash)
+	require.EqualError(t, err, fmt.Sprintf("Payload hash %s does not match %s sent with message Message_045i10y", otherHash, msg.PayloadHash))
+	requireMsgState(t, cc, ctx, instanceID, "Message_045i10y", WAITFORCONFIRM)
+	require.EqualError(t, cc.ConfirmMessage(ctx, instanceID, "Message_045i10y", ""), `Payload hash "" is not a hex encoded SHA-256`)
+
+	require.NoError(t, cc.ConfirmMessage(ctx, instanceID, "Message_045i10y", msg.PayloadHash))
+	requireMsgState(t, cc, ctx, instanceID, "Message_045i10y", DONE)
+}
+
+func TestParticipantRequirements(t *testing.T) {
+	cc, ctx, identity, _ := deployHotelBooking(t)
+	instanceID, err := cc.CreateInstance(ctx, hotelBooking, `{
+		"Client": {"mspID": "ClientMSP", "clientIDs": ["x509::CN=alice::CN=ca.client"]},
+		"Hotel": {"mspID": "HotelMSP", "attributes": {"role": "booking-agent"}, "subjects": ["CN=agent1,OU=client"]}
+	}`)
+	require.NoError(t, err)
+	require.NoError(t, cc.StartChoreography(ctx, instanceID))
+
+	identity.GetMSPIDReturns(clientMsp, nil)
+	identity.GetIDReturns("x509::CN=bob::CN=ca.client", nil)
+	require.EqualError(t, cc.SendMessage(ctx, instanceID, "Message_045i10y", "tx1", checkRoom, hashOf(t, checkRoom)),
+		"Identity denied: client x509::CN=bob::CN=ca.client may not act for participant Participant_1080bkg")
+	identity.GetIDReturns("x509::CN=alice::CN=ca.client", nil)
+	require.NoError(t, cc.SendMessage(ctx, instanceID, "Message_045i10y", "tx1", checkRoom, hashOf(t, checkRoom)))
+
+	// a read-only auditor of the hotel may not confirm on behalf of the booking desk
+	identity.GetMSPIDReturns(hotelMsp, nil)
+	identity.GetAttributeValueReturns("", false, nil)
+	require.EqualError(t, cc.ConfirmMessage(ctx, instanceID, "Message_045i10y", hashOf(t, checkRoom)),
+		"Attribute denied: participant Participant_0sktaei requires attribute role=booking-agent, the certificate has none")
+	identity.GetAttributeValueReturns("auditor", true, nil)
+	require.EqualError(t, cc.ConfirmMessage(ctx, instanceID, "Message_045i10y", hashOf(t, checkRoom)),
+		"Attribute denied: participant Participant_0sktaei requires attribute role=booking-agent, not auditor")
+
+	identity.GetAttributeValueReturns("booking-agent", true, nil)
+	identity.GetX509CertificateReturns(&x509.Certificate{Subject: pkix.Name{CommonName: "agent2", OrganizationalUnit: []string{"client"}}}, nil)
+	require.EqualError(t, cc.ConfirmMessage(ctx, instanceID, "Message_045i10y", hashOf(t, checkRoom)),
+		`Certificate denied: subject "CN=agent2,OU=client" may not act for participant Participant_0sktaei`)
+	identity.GetX509CertificateReturns(&x509.Certificate{Subject: pkix.Name{CommonName: "agent1", OrganizationalUnit: []string{"client"}}}, nil)
+	require.NoError(t, cc.ConfirmMessage(ctx, instanceID, "Message_045i10y", hashOf(t, checkRoom)))
+	require.Equal(t, "role", identity.GetAttributeValueArgsForCall(0))
+
+	hotel, err := cc.ReadParticipant(ctx, instanceID, "Participant_0sktaei")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"role": "booking-agent"}, hotel.Attributes)
+	require.Equal(t, []string{"CN=agent1,OU=client"}, hotel.Subjects)
+}
+
+func TestRejectMessage(t *testing.T) {
+	cc, ctx, identity, _ := deployHotelBooking(t)
+
+	// Check_room may be rejected once, the second rejection fails the instance
+	bpmnXML := strings.Replace(string(hotelBookingBPMN), `id="Choreography_hotel_booking"`, `id="Choreography_strict_booking"`, 1)
+	bpmnXML = strings.Replace(bpmnXML,
+		`<bpmn2:message id="Message_045i10y" name="Check_room(string date, uint bedrooms)" />`,
+		`<bpmn2:message id="Message_045i10y" name="Check_room(string date, uint bedrooms)"><bpmn2:documentation textFormat="application/vnd.choreography-policy+json">{"maxRejections":1}</bpmn2:documentation></bpmn2:message>`, 1)
+	require.NoError(t, cc.DeployChoreography(ctx, bpmnXML))
+	instanceID, err := cc.CreateInstance(ctx, "Choreography_strict_booking", bindings)
+	require.NoError(t, err)
+	require.NoError(t, cc.StartChoreography(ctx, instanceID))
+
+	identity.GetMSPIDReturns(hotelMsp, nil)
+	require.EqualError(t, cc.RejectMessage(ctx, instanceID, "Message_045i10y", "DATE_UNAVAILABLE", ""), "Msg state Message_045i10y is not allowed")
+	identity.GetMSPIDReturns(clientMsp, nil)
+	require.NoError(t, cc.SendMessage(ctx, instanceID, "Message_045i10y", "tx1", checkRoom, hashOf(t, checkRoom)))
+	require.EqualError(t, cc.RejectMessage(ctx, instanceID, "Message_045i10y", "DATE_UNAVAILABLE", ""), "Msp denied: participant Participant_0sktaei is bound to HotelMSP, not ClientMSP")
+	identity.GetMSPIDReturns(hotelMsp, nil)
+	require.EqualError(t, cc.RejectMessage(ctx, instanceID, "Message_045i10y", "", ""), "Rejection of message Message_045i10y needs a reason code")
+
+	require.NoError(t, cc.RejectMessage(ctx, instanceID, "Message_045i10y", "DATE_UNAVAILABLE", "fully booked on May 1st"))
+	msg, err := cc.ReadMsg(ctx, instanceID, "Message_045i10y")
+	require.NoError(t, err)
+	require.Equal(t, ElementState(ENABLE), msg.MsgState)
+	require.Empty(t, msg.FireflyTranID)
+	require.Empty(t, msg.PayloadHash)
+	require.Len(t, msg.History, 1)
+	require.Equal(t, MessageAttempt{
+		Outcome:       AttemptRejected,
+		FireflyTranID: "tx1",
+		PayloadHash:   hashOf(t, checkRoom),
+		ReasonCode:    "DATE_UNAVAILABLE",
+		Reason:        "fully booked on May 1st",
+		TxID:          msg.History[0].TxID,
+	}, msg.History[0])
+	require.Empty(t, msg.Variables)
+	// the rejected send never reached the process variables
+	memory, err := cc.ReadMemory(ctx, instanceID)
+	require.NoError(t, err)
+	require.NotContains(t, memory, "date")
+	require.NotContains(t, memory, "bedrooms")
+	identity.GetMSPIDReturns(clientMsp, nil)
+	items, err := cc.GetMyWorklist(ctx, instanceID)
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+	require.Equal(t, "SendMessage", items[0].Transaction)
+
+	// the resent message is rejected again
+	payload := `{"date":"2024-05-02","bedrooms":2}`
+	require.NoError(t, cc.SendMessage(ctx, instanceID, "Message_045i10y", "tx2", payload, hashOf(t, payload)))
+	identity.GetMSPIDReturns(hotelMsp, nil)
+	require.NoError(t, cc.RejectMessage(ctx, instanceID, "Message_045i10y", "DATE_UNAVAILABLE", ""))
+	instance, err := cc.ReadInstance(ctx, instanceID)
+	require.NoError(t, err)
+	require.Equal(t, InstanceFailed, instance.Status)
+	requireMsgState(t, cc, ctx, instanceID, "Message_045i10y", DISABLE)
+	identity.GetMSPIDReturns(clientMsp, nil)
+	items, err = cc.GetMyWorklist(ctx, instanceID)
+	require.NoError(t, err)
+	require.Empty(t, items)
+	require.EqualError(t, cc.SendMessage(ctx, instanceID, "Message_045i10y", "tx3", payload, hashOf(t, payload)), fmt.Sprintf("Instance %s is failed", instanceID))
+
+	// a reset starts over
+	identity.GetIDReturns(adminID, nil)
+	identity.GetMSPIDReturns(hotelMsp, nil)
+	require.NoError(t, cc.ResetInstance(ctx, instanceID))
+	require.NoError(t, cc.StartChoreography(ctx, instanceID))
+	msg, err = cc.ReadMsg(ctx, instanceID, "Message_045i10y")
+	require.NoError(t, err)
+	require.Equal(t, ElementState(ENABLE), msg.MsgState)
+	require.Empty(t, msg.History)
+}
+
+func TestRetractMessage(t *testing.T) {
+	cc, ctx, identity, instanceID := deployHotelBooking(t)
+	require.NoError(t, cc.StartChoreography(ctx, instanceID))
+	chaincodeStub := ctx.GetStub().(*mocks.ChaincodeStub)
+	chaincodeStub.GetTransientReturns(map[string][]byte{PayloadTransientKey: []byte(checkRoom)}, nil)
+
+	identity.GetMSPIDReturns(clientMsp, nil)
+	require.EqualError(t, cc.RetractMessage(ctx, instanceID, "Message_045i10y", ""), "Msg state Message_045i10y is not allowed")
+	require.NoError(t, cc.SendMessage(ctx, instanceID, "Message_045i10y", "wrong-tx", "", ""))
+	identity.GetMSPIDReturns(hotelMsp, nil)
+	require.EqualError(t, cc.RetractMessage(ctx, instanceID, "Message_045i10y", ""), "Msp denied: participant Participant_1080bkg is bound to ClientMSP, not HotelMSP")
+
+	identity.GetMSPIDReturns(clientMsp, nil)
+	require.NoError(t, cc.RetractMessage(ctx, instanceID, "Message_045i10y", "wrong FireFly transaction"))
+	msg, err := cc.ReadMsg(ctx, instanceID, "Message_045i10y")
+	require.NoError(t, err)
+	require.Equal(t, ElementState(ENABLE), msg.MsgState)
+	require.Empty(t, msg.PayloadCollection)
+	require.Equal(t, []MessageAttempt{{
+		Outcome:       AttemptRetracted,
+		FireflyTranID: "wrong-tx",
+		PayloadHash:   hashOf(t, checkRoom),
+		Reason:        "wrong FireFly transaction",
+		TxID:          msg.History[0].TxID,
+	}}, msg.History)
+	_, err = cc.ReadMessagePayload(ctx, instanceID, "Message_045i10y")
+	require.EqualError(t, err, "Message Message_045i10y has no private payload")
+	require.Equal(t, 1, chaincodeStub.DelPrivateDataCallCount())
+
+	// the receiver no longer has anything to confirm, the sender sends again
+	identity.GetMSPIDReturns(hotelMsp, nil)
+	items, err := cc.GetMyWorklist(ctx, instanceID)
+	require.NoError(t, err)
+	require.Empty(t, items)
+	require.EqualError(t, cc.ConfirmMessage(ctx, instanceID, "Message_045i10y", hashOf(t, checkRoom)), "Msg state Message_045i10y is not allowed")
+	identity.GetMSPIDReturns(clientMsp, nil)
+	require.NoError(t, cc.SendMessage(ctx, instanceID, "Message_045i10y", "tx1", "", ""))
+
+	// a rejected private payload leaves the collection as well
+	identity.GetMSPIDReturns(hotelMsp, nil)
+	require.NoError(t, cc.RejectMessage(ctx, instanceID, "Message_045i10y", "DATE_UNAVAILABLE", ""))
+	require.Equal(t, 2, chaincodeStub.DelPrivateDataCallCount())
+	collection, key := chaincodeStub.DelPrivateDataArgsForCall(1)
+	require.Equal(t, "pair-ClientMSP-HotelMSP", collection)
+	stored, err := chaincodeStub.GetPrivateData(collection, key)
+	require.NoError(t, err)
+	require.Nil(t, stored)
+	_, err = cc.ReadMessagePayload(ctx, instanceID, "Message_045i10y")
+	require.EqualError(t, err, "Message Message_045i10y has no private payload")
+
+	identity.GetMSPIDReturns(clientMsp, nil)
+	require.NoError(t, cc.SendMessage(ctx, instanceID, "Message_045i10y", "tx2", "", ""))
+	identity.GetMSPIDReturns(hotelMsp, nil)
+	require.NoError(t, cc.ConfirmMessage(ctx, instanceID, "Message_045i10y", hashOf(t, checkRoom)))
+	msg, err = cc.ReadMsg(ctx, instanceID, "Message_045i10y")
+	require.NoError(t, err)
+	require.Equal(t, "tx2", msg.FireflyTranID)
+	require.Len(t, msg.History, 2)
+}
+
+func TestInstanceLifecycle(t *testing.T) {
+	cc, ctx, identity, instanceID := deployHotelBooking(t)
+	require.NoError(t, cc.StartChoreography(ctx, instanceID))
+	as := func(msp string) {
+		identity.GetMSPIDReturns(msp, nil)
+		identity.GetIDReturns("x509::CN=user::CN=ca."+msp, nil)
+	}
+
+	// any participant suspends and resumes
+	as("OtherMSP")
+	require.EqualError(t, cc.SuspendInstance(ctx, instanceID, ""), fmt.Sprintf("Lifecycle denied: only a participant may suspend instance %s", instanceID))
+	as(clientMsp)
+	require.NoError(t, cc.SuspendInstance(ctx, instanceID, "waiting for the travel agency"))
+	require.EqualError(t, cc.SuspendInstance(ctx, instanceID, ""), fmt.Sprintf("Instance %s is suspended", instanceID))
+	require.EqualError(t, cc.SendMessage(ctx, instanceID, "Message_045i10y", "tx1", checkRoom, hashOf(t, checkRoom)), fmt.Sprintf("Instance %s is suspended", instanceID))
+	items, err := cc.GetMyWorklist(ctx, instanceID)
+	require.NoError(t, err)
+	require.Empty(t, items)
+	as(hotelMsp)
+	require.NoError(t, cc.ResumeInstance(ctx, instanceID, ""))
+	require.EqualError(t, cc.ResumeInstance(ctx, instanceID, ""), fmt.Sprintf("Instance %s is running", instanceID))
+	as(clientMsp)
+	items, err = cc.GetMyWorklist(ctx, instanceID)
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+	require.Equal(t, "SendMessage", items[0].Transaction)
+	require.NoError(t, cc.SendMessage(ctx, instanceID, "Message_045i10y", "tx1", checkRoom, hashOf(t, checkRoom)))
+
+	// all participants have to agree to cancel
+	require.NoError(t, cc.CancelInstance(ctx, instanceID, "booked elsewhere"))
+	instance, err := cc.ReadInstance(ctx, instanceID)
+	require.NoError(t, err)
+	require.Equal(t, InstanceRunning, instance.Status)
+	require.Equal(t, []string{"Participant_1080bkg"}, instance.Pending.ApprovedBy)
+
+	// a pending cancellation is neither replaced nor dropped by another change
+	for _, msp := range []string{clientMsp, hotelMsp} {
+		as(msp)
+		require.EqualError(t, cc.SuspendInstance(ctx, instanceID, ""), fmt.Sprintf("Instance %s is waiting for approvals to cancel, it cannot suspend before", instanceID))
+	}
+	instance, err = cc.ReadInstance(ctx, instanceID)
+	require.NoError(t, err)
+	require.Equal(t, InstanceRunning, instance.Status)
+	require.Equal(t, CancelAction, instance.Pending.Action)
+	require.Equal(t, []string{"Participant_1080bkg"}, instance.Pending.ApprovedBy)
+
+	// the approvals of the same change add up
+	as(clientMsp)
+	require.NoError(t, cc.CancelInstance(ctx, instanceID, ""))
+	instance, err = cc.ReadInstance(ctx, instanceID)
+	require.NoError(t, err)
+	require.Equal(t, []string{"Participant_1080bkg"}, instance.Pending.ApprovedBy)
+	require.Equal(t, "booked elsewhere", instance.Pending.Reason)
+	as(hotelMsp)
+	require.NoError(t, cc.CancelInstance(ctx, instanceID, ""))
+
+	instance, err = cc.ReadInstance(ctx, instanceID)
+	require.NoError(t, err)
+	require.Equal(t, InstanceCancelled, instance.Status)
+	require.Nil(t, instance.Pending)
+	require.Len(t, instance.Lifecycle, 3)
+	require.Equal(t, LifecycleChange{
+		Action:     CancelAction,
+		Reason:     "booked elsewhere",
+		ApprovedBy: []string{"Participant_0sktaei", "Participant_1080bkg"},
+		ClientIDs:  []string{"x509::CN=user::CN=ca.ClientMSP", "x509::CN=user::CN=ca.HotelMSP"},
+		TxID:       instance.Lifecycle[2].TxID,
+	}, instance.Lifecycle[2])
+	requireMsgState(t, cc, ctx, instanceID, "Message_045i10y", CANCELLED)
+	items, err = cc.GetMyWorklist(ctx, instanceID)
+	require.NoError(t, err)
+	require.Empty(t, items)
+	require.EqualError(t, cc.ConfirmMessage(ctx, instanceID, "Message_045i10y", hashOf(t, checkRoom)), fmt.Sprintf("Instance %s is cancelled", instanceID))
+	require.EqualError(t, cc.ResumeInstance(ctx, instanceID, ""), fmt.Sprintf("Instance %s is cancelled", instanceID))
+
+	// the choreography may leave cancellation to the organization running the ledger
+	bpmnXML := strings.Replace(string(hotelBookingBPMN),
+		`<bpmn2:choreography id="Choreography_hotel_booking" name="Hotel booking">`,
+		`<bpmn2:choreography id="Choreography_governed_booking" name="Hotel booking"><bpmn2:documentation textFormat="application/vnd.choreography-policy+json">{"cancel":"adminOrg"}</bpmn2:documentation>`, 1)
+	// only the administrator deploys definitions, as it does decisions
+	require.EqualError(t, cc.DeployChoreography(ctx, bpmnXML), "Only the administrator may perform this operation")
+	identity.GetIDReturns(adminID, nil)
+	require.NoError(t, cc.DeployChoreography(ctx, bpmnXML))
+	require.EqualError(t, cc.DeployChoreography(ctx, bpmnXML), "编排 Choreography_governed_booking 已存在")
+	instanceID, err = cc.CreateInstance(ctx, "Choreography_governed_booking", bindings)
+	require.NoError(t, err)
+	as(clientMsp)
+	require.EqualError(t, cc.CancelInstance(ctx, instanceID, ""), fmt.Sprintf("Lifecycle denied: only HotelMSP may cancel instance %s", instanceID))
+	as(hotelMsp)
+	require.NoError(t, cc.CancelInstance(ctx, instanceID, "fraud"))
+	instance, err = cc.ReadInstance(ctx, instanceID)
+	require.NoError(t, err)
+	require.Equal(t, InstanceCancelled, instance.Status)
+	require.Equal(t, []string{hotelMsp}, instance.Lifecycle[0].ApprovedBy)
+	event, err := cc.ReadEvent(ctx, instanceID, "StartEvent_1jtgn3j")
+	require.NoError(t, err)
+	require.Equal(t, ElementState(CANCELLED), event.EventState)
+}
+
+func TestParallelGateway(t *testing.T) {
+	cc, ctx, identity, _ := deployHotelBooking(t)
+	bpmnXML, err := os.ReadFile("bpmn/check_in.bpmn")
+	require.NoError(t, err)
+	require.NoError(t, cc.DeployChoreography(ctx, string(bpmnXML)))
+	instanceID, err := cc.CreateInstance(ctx, "Choreography_check_in", `{"Client":"ClientMSP","Hotel":"HotelMSP"}`)
+	require.NoError(t, err)
+	require.NoError(t, cc.StartChoreography(ctx, instanceID))
+
+	requireGateway := func(gatewayID string, state ElementState, arrived ...string) {
+		gtw, err := cc.ReadGtw(ctx, instanceID, gatewayID)
+		require.NoError(t, err)
+		require.Equal(t, state, gtw.GatewayState, gatewayID)
+		require.Equal(t, arrived, gtw.Arrived, gatewayID)
+	}
+
+	// the fork enables the invoice and, through the nested fork, both documents
+	deliver(t, cc, ctx, identity, instanceID, clientMsp, hotelMsp, "Message_booking", `{"date":"2024-05-01"}`)
+	requireGateway("ParallelGateway_fork", DONE)
+	requireGateway("ParallelGateway_documents_fork", DONE)
+	requireMsgState(t, cc, ctx, instanceID, "Message_invoice", ENABLE)
+	requireMsgState(t, cc, ctx, instanceID, "Message_passport", ENABLE)
+	requireMsgState(t, cc, ctx, instanceID, "Message_address", ENABLE)
+
+	// each join waits for all of its incoming branches
+	deliver(t, cc, ctx, identity, instanceID, clientMsp, hotelMsp, "Message_passport", `{"passportNumber":"X1234567"}`)
+	requireGateway("ParallelGateway_documents_join", ENABLE, "SequenceFlow_passport_sent")
+	deliver(t, cc, ctx, identity, instanceID, hotelMsp, clientMsp, "Message_invoice", `{"amount":300}`)
+	requireMsgState(t, cc, ctx, instanceID, "Message_payment", ENABLE)
+	deliver(t, cc, ctx, identity, instanceID, clientMsp, hotelMsp, "Message_address", `{"address":"1 Main St"}`)
+	requireGateway("ParallelGateway_documents_join", DONE)
+	requireGateway("ParallelGateway_join", ENABLE, "SequenceFlow_documents_sent")
+	requireMsgState(t, cc, ctx, instanceID, "Message_key", DISABLE)
+
+	deliver(t, cc, ctx, identity, instanceID, clientMsp, hotelMsp, "Message_payment", `{"to":"0x5B38Da6a701c568545dCfcB03FcB875f56beddC4"}`)
+	requireGateway("ParallelGateway_join", DONE)
+	requireMsgState(t, cc, ctx, instanceID, "Message_key", ENABLE)
+	deliver(t, cc, ctx, identity, instanceID, hotelMsp, clientMsp, "Message_key", `{"room":"101"}`)
+	event, err := cc.ReadEvent(ctx, instanceID, "EndEvent_check_in")
+	require.NoError(t, err)
+	require.Equal(t, ElementState(DONE), event.EventState)
+}
+
+func TestInclusiveGateway(t *testing.T) {
+	cc, ctx, identity, _ := deployHotelBooking(t)
+	bpmnXML, err := os.ReadFile("bpmn/extra_services.bpmn")
+	require.NoError(t, err)
+	require.NoError(t, cc.DeployChoreography(ctx, string(bpmnXML)))
+
+	start := func() string {
+		instanceID, err := cc.CreateInstance(ctx, "Choreography_extra_services", `{"Client":"ClientMSP","Hotel":"HotelMSP"}`)
+		require.NoError(t, err)
+		require.NoError(t, cc.StartChoreography(ctx, instanceID))
+		return instanceID
+	}
+
+	// breakfast and parking are activated, the join waits for those two only
+	instanceID := start()
+	deliver(t, cc, ctx, identity, instanceID, clientMsp, hotelMsp, "Message_booking", `{"date":"2024-05-01","lateCheckout":false,"breakfast":true,"parking":true}`)
+	requireMsgState(t, cc, ctx, instanceID, "Message_late_checkout", DISABLE)
+	requireMsgState(t, cc, ctx, instanceID, "Message_breakfast", ENABLE)
+	requireMsgState(t, cc, ctx, instanceID, "Message_parking", ENABLE)
+	join, err := cc.ReadGtw(ctx, instanceID, "InclusiveGateway_services_join")
+	require.NoError(t, err)
+	require.Equal(t, 2, join.Expected)
+
+	deliver(t, cc, ctx, identity, instanceID, hotelMsp, clientMsp, "Message_parking", `{"spot":"B2"}`)
+	join, err = cc.ReadGtw(ctx, instanceID, "InclusiveGateway_services_join")
+	require.NoError(t, err)
+	require.Equal(t, ElementState(ENABLE), join.GatewayState)
+	require.Equal(t, []string{"SequenceFlow_parking_confirmed"}, join.Arrived)
+	requireMsgState(t, cc, ctx, instanceID, "Message_invoice", DISABLE)
+
+	deliver(t, cc, ctx, identity, instanceID, hotelMsp, clientMsp, "Message_breakfast", `{"price":15}`)
+	join, err = cc.ReadGtw(ctx, instanceID, "InclusiveGateway_services_join")
+	require.NoError(t, err)
+	require.Equal(t, ElementState(DONE), join.GatewayState)
+	require.Empty(t, join.Arrived)
+	require.Equal(t, 0, join.Expected)
+	requireMsgState(t, cc, ctx, instanceID, "Message_invoice", ENABLE)
+
+	// without extras the default flow leads straight to the join
+	instanceID = start()
+	deliver(t, cc, ctx, identity, instanceID, clientMsp, hotelMsp, "Message_booking", `{"date":"2024-05-01","lateCheckout":false,"breakfast":false,"parking":false}`)
+	requireMsgState(t, cc, ctx, instanceID, "Message_late_checkout", DISABLE)
+	requireMsgState(t, cc, ctx, instanceID, "Message_breakfast", DISABLE)
+	requireMsgState(t, cc, ctx, instanceID, "Message_parking", DISABLE)
+	requireMsgState(t, cc, ctx, instanceID, "Message_invoice", ENABLE)
+}
+
+func TestEventBasedGateway(t *testing.T) {
+	cc, ctx, identity, instanceID := deployHotelBooking(t)
+	require.NoError(t, cc.StartChoreography(ctx, instanceID))
+	deliver(t, cc, ctx, identity, instanceID, clientMsp, hotelMsp, "Message_045i10y", checkRoom)
+	deliver(t, cc, ctx, identity, instanceID, hotelMsp, clientMsp, "Message_0r9lypd", `{"confirm":true}`)
+	deliver(t, cc, ctx, identity, instanceID, hotelMsp, clientMsp, "Message_1em0ee4", `{"quotation":300}`)
+	deliver(t, cc, ctx, identity, instanceID, clientMsp, hotelMsp, "Message_1nlagx2", `{"confirmation":true}`)
+
+	// the cancellation wins, the payment can no longer be sent
+	cancel := `{"motivation":"plans changed"}`
+	identity.GetMSPIDReturns(clientMsp, nil)
+	require.NoError(t, cc.SendMessage(ctx, instanceID, "Message_1xm9dxy", "tx1", cancel, hashOf(t, cancel)))
+	requireMsgState(t, cc, ctx, instanceID, "Message_0o8eyir", DISABLE)
+	require.EqualError(t, cc.SendMessage(ctx, instanceID, "Message_0o8eyir", "tx2", `{"to":"0x5B38Da6a701c568545dCfcB03FcB875f56beddC4"}`, ""),
+		"Alternative already chosen: gateway EventBasedGateway_1fxpmyn took SequenceFlow_1l0sbvx, Message_0o8eyir can not be sent")
+
+	// a rejected cancellation is resent on the branch already chosen
+	identity.GetMSPIDReturns(hotelMsp, nil)
+	require.NoError(t, cc.RejectMessage(ctx, instanceID, "Message_1xm9dxy", "MISSING_REASON", ""))
+	requireMsgState(t, cc, ctx, instanceID, "Message_0o8eyir", DISABLE)
+	identity.GetMSPIDReturns(clientMsp, nil)
+	require.NoError(t, cc.SendMessage(ctx, instanceID, "Message_1xm9dxy", "tx3", cancel, hashOf(t, cancel)))
+	identity.GetMSPIDReturns(hotelMsp, nil)
+	require.NoError(t, cc.ConfirmMessage(ctx, instanceID, "Message_1xm9dxy", hashOf(t, cancel)))
+
+	gtw, err := cc.ReadGtw(ctx, instanceID, "EventBasedGateway_1fxpmyn")
+	require.NoError(t, err)
+	require.Equal(t, "SequenceFlow_1l0sbvx", gtw.Chosen)
+	event, err := cc.ReadEvent(ctx, instanceID, "EndEvent_0366pfz")
+	require.NoError(t, err)
+	require.Equal(t, ElementState(DONE), event.EventState)
+}
+
+func TestDecisions(t *testing.T) {
+	cc, ctx, identity, _ := deployHotelBooking(t)
+	dmnXML, err := os.ReadFile("bpmn/hotel_booking.dmn")
+	require.NoError(t, err)
+
+	// the refund is decided by governed rules rather than by the cancel flag alone
+	bpmnXML := strings.Replace(string(hotelBookingBPMN), `id="Choreography_hotel_booking"`, `id="Choreography_governed_refund"`, 1)
+	bpmnXML = strings.Replace(bpmnXML,
+		`<bpmn2:exclusiveGateway id="ExclusiveGateway_0nzwv7v" default="SequenceFlow_0d5x9xp">`,
+		`<bpmn2:exclusiveGateway id="ExclusiveGateway_0nzwv7v" default="SequenceFlow_0d5x9xp"><bpmn2:documentation textFormat="application/vnd.choreography-policy+json">{"decisionRef":"Decision_refund"}</bpmn2:documentation>`, 1)
+	bpmnXML = strings.Replace(bpmnXML, `>cancel = true<`, `>refund = true<`, 1)
+	bpmnXML = strings.Replace(bpmnXML,
+		`<bpmn2:message id="Message_1em0ee4" name="Price_quotation(uint quotation)" />`,
+		`<bpmn2:message id="Message_1em0ee4" name="Price_quotation(uint quotation)"><bpmn2:documentation textFormat="application/vnd.choreography-policy+json">{"decisionRef":"Decision_deposit"}</bpmn2:documentation></bpmn2:message>`, 1)
+	require.EqualError(t, cc.DeployChoreography(ctx, bpmnXML), "Decision Decision_refund referenced by ExclusiveGateway_0nzwv7v has not been deployed")
+
+	identity.GetMSPIDReturns(clientMsp, nil)
+	require.EqualError(t, cc.DeployDecision(ctx, string(dmnXML)), "Only the administrator may perform this operation")
+	identity.GetMSPIDReturns(hotelMsp, nil)
+	require.NoError(t, cc.DeployDecision(ctx, string(dmnXML)))
+	require.EqualError(t, cc.DeployDecision(ctx, string(dmnXML)), "决策 Decision_refund 已存在")
+	require.NoError(t, cc.DeployChoreography(ctx, bpmnXML))
+
+	// the decision is also available as a standalone query
+	result, err := cc.EvaluateDecision(ctx, "Decision_perks", `{"quotation":600,"bedrooms":1}`)
+	require.NoError(t, err)
+	require.Equal(t, []string{"Rule_breakfast", "Rule_late_checkout"}, result.Rules)
+	_, err = cc.EvaluateDecision(ctx, "Decision_unknown", `{}`)
+	require.EqualError(t, err, "Decision Decision_unknown has not been deployed")
+
+	instanceID, err := cc.CreateInstance(ctx, "Choreography_governed_refund", bindings)
+	require.NoError(t, err)
+	require.NoError(t, cc.StartChoreography(ctx, instanceID))
+	deliver(t, cc, ctx, identity, instanceID, clientMsp, hotelMsp, "Message_045i10y", checkRoom)
+	deliver(t, cc, ctx, identity, instanceID, hotelMsp, clientMsp, "Message_0r9lypd", `{"confirm":true}`)
+
+	// the quotation feeds the decisions, it cannot be kept in the collection
+	chaincodeStub := ctx.GetStub().(*mocks.ChaincodeStub)
+	chaincodeStub.GetTransientReturns(map[string][]byte{PayloadTransientKey: []byte(`{"quotation":800}`)}, nil)
+	identity.GetMSPIDReturns(hotelMsp, nil)
+	require.EqualError(t, cc.SendMessage(ctx, instanceID, "Message_1em0ee4", "tx_private", "", ""),
+		"Field quotation of message Message_1em0ee4 is read by a gateway condition or decision and cannot be sent privately")
+	chaincodeStub.GetTransientReturns(nil, nil)
+
+	// the message decision turns the quotation into a deposit
+	deliver(t, cc, ctx, identity, instanceID, hotelMsp, clientMsp, "Message_1em0ee4", `{"quotation":800}`)
+	memory, err := cc.ReadMemory(ctx, instanceID)
+	require.NoError(t, err)
+	require.Equal(t, json.Number("100"), memory["deposit"])
+
+	deliver(t, cc, ctx, identity, instanceID, clientMsp, hotelMsp, "Message_1nlagx2", `{"confirmation":true}`)
+	deliver(t, cc, ctx, identity, instanceID, clientMsp, hotelMsp, "Message_0o8eyir", `{"to":"0x5B38Da6a701c568545dCfcB03FcB875f56beddC4"}`)
+	deliver(t, cc, ctx, identity, instanceID, hotelMsp, clientMsp, "Message_1ljlm4g", `{"bookingId":"B-42"}`)
+	deliver(t, cc, ctx, identity, instanceID, clientMsp, hotelMsp, "Message_0m9p3da", `{"cancel":true}`)
+
+	// the gateway applies the refund decision before its conditions
+	requireMsgState(t, cc, ctx, instanceID, "Message_1joj7ca", ENABLE)
+	memory, err = cc.ReadMemory(ctx, instanceID)
+	require.NoError(t, err)
+	require.Equal(t, true, memory["refund"])
+	require.Equal(t, json.Number("50"), memory["fee"])
+}
+
+func TestPrivatePayload(t *testing.T) {
+	cc, ctx, identity, instanceID := deployHotelBooking(t)
+	require.NoError(t, cc.StartChoreography(ctx, instanceID))
+	chaincodeStub := ctx.GetStub().(*mocks.ChaincodeStub)
+	chaincodeStub.GetTransientReturns(map[string][]byte{PayloadTransientKey: []byte(checkRoom)}, nil)
+
+	identity.GetMSPIDReturns(clientMsp, nil)
+	err := cc.SendMessage(ctx, instanceID, "Message_045i10y", "tx1", checkRoom, "")
+	require.EqualError(t, err, "Payload must be passed either as argument or in the transient map")
+	err = cc.SendMessage(ctx, instanceID, "Message_045i10y", "tx1", "", hashOf(t, `{"date":"2024-05-02","bedrooms":2}`))
+	require.EqualError(t, err, fmt.Sprintf("Payload hash %s does not match the private payload", hashOf(t, `{"date":"2024-05-02","bedrooms":2}`)))
+	require.NoError(t, cc.SendMessage(ctx, instanceID, "Message_045i10y", "tx1", "", ""))
+
+	// the world state only keeps the hash, the booking request stays between client and hotel
+	msg, err := cc.ReadMsg(ctx, instanceID, "Message_045i10y")
+	require.NoError(t, err)
+	require.Equal(t, "pair-ClientMSP-HotelMSP", msg.PayloadCollection)
+	require.Equal(t, hashOf(t, checkRoom), msg.PayloadHash)
+	memory, err := cc.ReadMemory(ctx, instanceID)
+	require.NoError(t, err)
+	require.Empty(t, memory)
+
+	payload, err := cc.ReadMessagePayload(ctx, instanceID, "Message_045i10y")
+	require.NoError(t, err)
+	require.Equal(t, checkRoom, payload)
+	identity.GetMSPIDReturns("OtherMSP", nil)
+	_, err = cc.ReadMessagePayload(ctx, instanceID, "Message_045i10y")
+	require.EqualError(t, err, "Msp denied: participant Participant_0sktaei is bound to HotelMSP, not OtherMSP")
+
+	identity.GetMSPIDReturns(hotelMsp, nil)
+	require.NoError(t, cc.ConfirmMessage(ctx, instanceID, "Message_045i10y", hashOf(t, checkRoom)))
+	payload, err = cc.ReadMessagePayload(ctx, instanceID, "Message_045i10y")
+	require.NoError(t, err)
+	require.Equal(t, checkRoom, payload)
+	_, err = cc.ReadMessagePayload(ctx, instanceID, "Message_0r9lypd")
+	require.EqualError(t, err, "Message Message_0r9lypd has no private payload")
+
+	// fields read by a gateway condition would reach the world state, they cannot be private
+	chaincodeStub.GetTransientReturns(map[string][]byte{PayloadTransientKey: []byte(`{"confirm":true}`)}, nil)
+	require.EqualError(t, cc.SendMessage(ctx, instanceID, "Message_0r9lypd", "tx2", "", ""),
+		"Field confirm of message Message_0r9lypd is read by a gateway condition or decision and cannot be sent privately")
+	requireMsgState(t, cc, ctx, instanceID, "Message_0r9lypd", ENABLE)
+	chaincodeStub.GetTransientReturns(nil, nil)
+	deliver(t, cc, ctx, identity, instanceID, hotelMsp, clientMsp, "Message_0r9lypd", `{"confirm":true}`)
+	requireMsgState(t, cc, ctx, instanceID, "Message_1em0ee4", ENABLE)
+	memory, err = cc.ReadMemory(ctx, instanceID)
+	require.NoError(t, err)
+	require.Equal(t, StateMemory{"confirm": true}, memory)
+
+	require.Equal(t, "pair-HotelMSP-Org1MSP", PairCollection("Org1.MSP", "HotelMSP"))
+}
+
+func TestInitLedgerIsRecordedOnLedger(t *testing.T) {
+	cc, ctx, _, _ := deployHotelBooking(t)
+
+	metadata, err := cc.ReadLedgerMetadata(ctx)
+	require.NoError(t, err)
+	require.True(t, metadata.Initialized)
+	require.Equal(t, contractVersion, metadata.Version)
+	require.Equal(t, adminID, metadata.InitializedBy)
+	require.Equal(t, hotelMsp, metadata.MspID)
+
+	// a restarted container starts with a fresh contract object but the same ledger
+	restarted := &SmartContract{}
+	require.EqualError(t, restarted.InitLedger(ctx), "Chaincode has already been initialized")
+
+	empty, _, _ := newWorldState()
+	_, err = cc.ReadLedgerMetadata(empty)
+	require.EqualError(t, err, "Chaincode has not been initialized")
+}
+
+func TestResetInstance(t *testing.T) {
+	cc, ctx, identity, instanceID := deployHotelBooking(t)
+	require.NoError(t, cc.StartChoreography(ctx, instanceID))
+	identity.GetMSPIDReturns(clientMsp, nil)
+	require.NoError(t, cc.SendMessage(ctx, instanceID, "Message_045i10y", "tx_fly", checkRoom, hashOf(t, checkRoom)))
+
+	identity.GetIDReturns("x509::CN=client", nil)
+	require.EqualError(t, cc.ResetInstance(ctx, instanceID), "Only the administrator may perform this operation")
+
+	identity.GetIDReturns(adminID, nil)
+	identity.GetMSPIDReturns(hotelMsp, nil)
+	require.EqualError(t, cc.ResetInstance(ctx, "unknown"), "Instance unknown does not exist")
+	require.NoError(t, cc.ResetInstance(ctx, instanceID))
+
+	requireMsgState(t, cc, ctx, instanceID, "Message_045i10y", DISABLE)
+	event, err := cc.ReadEvent(ctx, instanceID, "StartEvent_1jtgn3j")
+	require.NoError(t, err)
+	require.Equal(t, ElementState(ENABLE), event.EventState)
+	memory, err := cc.ReadMemory(ctx, instanceID)
+	require.NoError(t, err)
+	require.Empty(t, memory)
+	instance, err := cc.ReadInstance(ctx, instanceID)
+	require.NoError(t, err)
+	require.Equal(t, 1, instance.Run)
+
+	require.NoError(t, cc.ResetInstance(ctx, instanceID))
+	archives, err := cc.GetInstanceArchives(ctx, instanceID)
+	require.NoError(t, err)
+	require.Len(t, archives, 2)
+	require.Equal(t, 0, archives[0].Instance.Run)
+	require.Equal(t, 1, archives[1].Instance.Run)
+	require.Equal(t, adminID, archives[0].ArchivedBy)
+	// the unconfirmed send has not reached the process variables yet
+	require.Empty(t, archives[0].Memory)
+	require.JSONEq(t, fmt.Sprintf(`{"docType":"message","instanceID":%q,"messageID":"Message_045i10y","sendMspID":"ClientMSP","receiveMspID":"HotelMSP","fireflyTranID":"tx_fly","payloadHash":%q,"msgState":2,"format":"date:string, bedrooms:uint","variables":{"date":"2024-05-01","bedrooms":2}}`, instanceID, hashOf(t, checkRoom)),
+		string(archives[0].Elements["Message_045i10y"]))
+
+	// a late binding and a private payload do not survive the run they were made in
+	instanceID, err = cc.CreateInstance(ctx, hotelBooking, `{"Client":"ClientMSP","Hotel":{"bindBy":"Client","candidates":["HotelMSP","Org3MSP"]}}`)
+	require.NoError(t, err)
+	require.NoError(t, cc.StartChoreography(ctx, instanceID))
+	identity.GetMSPIDReturns(clientMsp, nil)
+	require.NoError(t, cc.BindParticipant(ctx, instanceID, "Hotel", "Org3MSP"))
+	chaincodeStub := ctx.GetStub().(*mocks.ChaincodeStub)
+	chaincodeStub.GetTransientReturns(map[string][]byte{PayloadTransientKey: []byte(checkRoom)}, nil)
+	require.NoError(t, cc.SendMessage(ctx, instanceID, "Message_045i10y", "tx1", "", ""))
+	chaincodeStub.GetTransientReturns(nil, nil)
+	key, err := elementKey(ctx, instanceID, "Message_045i10y")
+	require.NoError(t, err)
+	stored, err := chaincodeStub.GetPrivateData("pair-ClientMSP-Org3MSP", key)
+	require.NoError(t, err)
+	require.Equal(t, checkRoom, string(stored))
+
+	identity.GetMSPIDReturns(hotelMsp, nil)
+	require.NoError(t, cc.ResetInstance(ctx, instanceID))
+	stored, err = chaincodeStub.GetPrivateData("pair-ClientMSP-Org3MSP", key)
+	require.NoError(t, err)
+	require.Nil(t, stored)
+	hotel, err := cc.ReadParticipant(ctx, instanceID, "Participant_0sktaei")
+	require.NoError(t, err)
+	require.Empty(t, hotel.MspID)
+	require.Equal(t, "Participant_1080bkg", hotel.BindBy)
+	msg, err := cc.ReadMsg(ctx, instanceID, "Message_045i10y")
+	require.NoError(t, err)
+	require.Empty(t, msg.ReceiveMspID)
+	require.Empty(t, msg.PayloadCollection)
+	archives, err = cc.GetInstanceArchives(ctx, instanceID)
+	require.NoError(t, err)
+	require.Len(t, archives, 1)
+	require.Equal(t, "Org3MSP", archives[0].Participants[0].MspID)
+	require.Equal(t, "Participant_0sktaei", archives[0].Participants[0].ParticipantID)
+
+	// the client binds a hotel again in the new run
+	require.NoError(t, cc.StartChoreography(ctx, instanceID))
+	identity.GetMSPIDReturns(clientMsp, nil)
+	require.NoError(t, cc.BindParticipant(ctx, instanceID, "Hotel", "HotelMSP"))
+}
+
+func TestInstanceElementQueries(t *testing.T) {
+	cc, ctx, _, instanceID := deployHotelBooking(t)
+	second, err := cc.CreateInstance(ctx, hotelBooking, bindings)
+	require.NoError(t, err)
+
+	messages, err := cc.GetAllMessages(ctx, instanceID)
+	require.NoError(t, err)
+	require.Len(t, messages, 10)
+	gateways, err := cc.GetAllGateways(ctx, instanceID)
+	require.NoError(t, err)
+	require.Len(t, gateways, 4)
+	for _, gateway := range gateways {
+		require.Equal(t, instanceID, gateway.InstanceID)
+	}
+	events, err := cc.GetAllEvents(ctx, second)
+	require.NoError(t, err)
+	require.Len(t, events, 4)
+	for _, event := range events {
+		require.NotEmpty(t, event.EventID)
+		require.Equal(t, second, event.InstanceID)
+	}
+
+	// the formats come from the definitions, however many instances there are
+	formats, err := cc.GetAllFormats(ctx)
+	require.NoError(t, err)
+	require.Len(t, formats, 9) // both payments use "to:address"
+	chaincodeStub := ctx.GetStub().(*mocks.ChaincodeStub)
+	objectType, _ := chaincodeStub.GetStateByPartialCompositeKeyArgsForCall(chaincodeStub.GetStateByPartialCompositeKeyCallCount() - 1)
+	require.Equal(t, choreographyObjectType, objectType)
+
+	_, err = cc.ReadMsg(ctx, instanceID, "ExclusiveGateway_106je4z")
+	require.EqualError(t, err, "Message ExclusiveGateway_106je4z does not exist")
+	_, err = cc.ReadGtw(ctx, instanceID, "Message_045i10y")
+	require.EqualError(t, err, "Gateway Message_045i10y does not exist")
+}
+
+func TestWorklist(t *testing.T) {
+	cc, ctx, identity, first := deployHotelBooking(t)
+	second, err := cc.CreateInstance(ctx, hotelBooking, bindings)
+	require.NoError(t, err)
+	worklist := func(msp, instanceID string) []*WorkItem {
+		identity.GetMSPIDReturns(msp, nil)
+		items, err := cc.GetMyWorklist(ctx, instanceID)
+		require.NoError(t, err)
+		return items
+	}
+
+	require.Empty(t, worklist(clientMsp, ""))
+	require.NoError(t, cc.StartChoreography(ctx, first))
+	require.NoError(t, cc.StartChoreography(ctx, second))
+
+	items := worklist(clientMsp, "")
+	require.Len(t, items, 2)
+	require.Equal(t, &WorkItem{
+		InstanceID:  first,
+		MessageID:   "Message_045i10y",
+		MsgState:    ENABLE,
+		Transaction: "SendMessage",
+		Parameters:  []string{"instanceID", "messageID", "fireflyTranID", "payloadJSON", "payloadHash"},
+		Format:      "date:string, bedrooms:uint",
+	}, worklist(clientMsp, first)[0])
+	require.Empty(t, worklist(hotelMsp, ""))
+
+	identity.GetMSPIDReturns(clientMsp, nil)
+	require.NoError(t, cc.SendMessage(ctx, first, "Message_045i10y", "tx1", checkRoom, hashOf(t, checkRoom)))
+	require.Empty(t, worklist(clientMsp, first))
+	items = worklist(hotelMsp, "")
+	require.Len(t, items, 1)
+	require.Equal(t, "ConfirmMessage", items[0].Transaction)
+
+	require.NoError(t, cc.ConfirmMessage(ctx, first, "Message_045i10y", hashOf(t, checkRoom)))
+	items = worklist(hotelMsp, "")
+	require.Len(t, items, 1)
+	require.Equal(t, "Message_0r9lypd", items[0].MessageID)
+	require.Equal(t, "SendMessage", items[0].Transaction)
+
+	// a reset instance has nothing left to do
+	identity.GetIDReturns(adminID, nil)
+	identity.GetMSPIDReturns(hotelMsp, nil)
+	require.NoError(t, cc.ResetInstance(ctx, first))
+	require.Empty(t, worklist(hotelMsp, ""))
+	require.Len(t, worklist(clientMsp, ""), 1)
+}
+
+func TestEvaluateCondition(t *testing.T) {
+	memory := StateMemory{"confirm": true, "quotation": 300.0, "motivation": "late"}
+
+	ok, err := evaluateCondition("confirm = true", memory)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	ok, err = evaluateCondition("quotation != 300", memory)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	ok, err = evaluateCondition(`motivation == "late"`, memory)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	ok, err = evaluateCondition(`quotation <= 500 and not (motivation = "early")`, memory)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	_, err = evaluateCondition("cancel", memory)
+	require.EqualError(t, err, "variable cancel is not set")
+}
+
+func TestSelectExclusiveFlow(t *testing.T) {
+	chor, err := ParseChoreography([]byte(`<definitions><choreography id="c">
+		<startEvent id="s"/><exclusiveGateway id="x"/><endEvent id="cheap"/><endEvent id="expensive"/>
+		<sequenceFlow id="f" sourceRef="s" targetRef="x"/>
+		<sequenceFlow id="f_cheap" sourceRef="x" targetRef="cheap"><conditionExpression>quotation &lt;= 500 and confirm = true</conditionExpression></sequenceFlow>
+		<sequenceFlow id="f_expensive" sourceRef="x" targetRef="expensive"><conditionExpression>quotation &gt; 500</conditionExpression></sequenceFlow>
+	</choreography></definitions>`))
+	require.NoError(t, err)
+	gtw := chor.Elements["x"]
+
+	flow, err := selectExclusiveFlow(chor, gtw, StateMemory{"quotation": 300.0, "confirm": true})
+	require.NoError(t, err)
+	require.Equal(t, "f_cheap", flow.FlowID)
+	flow, err = selectExclusiveFlow(chor, gtw, StateMemory{"quotation": 800.0, "confirm": true})
+	require.NoError(t, err)
+	require.Equal(t, "f_expensive", flow.FlowID)
+
+	// without a default flow a decision that matches nothing fails
+	_, err = selectExclusiveFlow(chor, gtw, StateMemory{"quotation": 300.0, "confirm": false})
+	require.EqualError(t, err, "no outgoing flow of gateway x matches")
+	_, err = selectExclusiveFlow(chor, gtw, StateMemory{"quotation": 300.0})
+	require.EqualError(t, err, "condition of flow f_cheap: variable confirm is not set")
+
+	gtw.Default = "f_expensive"
+	flow, err = selectExclusiveFlow(chor, gtw, StateMemory{"quotation": 300.0, "confirm": false})
+	require.NoError(t, err)
+	require.Equal(t, "f_expensive", flow.FlowID)
+}
@@ -0,0 +1,401 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expression is the parsed condition of a sequence flow, a deterministic subset of FEEL:
+//
+//	expr       := and {"or" and}
+//	and        := not {"and" not}
+//	not        := "not" not | comparison
+//	comparison := operand [("=" | "==" | "!=" | "<" | "<=" | ">" | ">=") operand]
+//	operand    := name | number | string | "true" | "false" | "(" expr ")"
+//
+// e.g. `quotation <= 500 and confirm = true` or `not (motivation = "late")`. Names refer
+// to the process variables of the instance. Numbers are compared exactly, comparing values
+// of different types is an error rather than false.
+type Expression struct {
+	Op       string        // "or", "and", "not", a comparison such as "<=", or "" for an operand
+	Operands []*Expression // operands of Op
+	Name     string        // variable of an operand
+	Value    interface{}   // literal of an operand: json.Number, string or bool
+}
+
+const (
+	OrOp  = "or"
+	AndOp = "and"
+	NotOp = "not"
+)
+
+var comparisonOps = map[string]bool{"=": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true}
+
+// IsComparison tells whether op compares two operands
+func IsComparison(op string) bool {
+	return comparisonOps[op]
+}
+
+// ParseExpression parses a condition expression
+func ParseExpression(source string) (*Expression, error) {
+	tokens, err := tokenize(source)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, errors.New("missing condition")
+	}
+	p := &expressionParser{tokens: tokens}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %s", p.tokens[p.pos])
+	}
+	return expr, nil
+}
+
+// Evaluate evaluates the expression against the process variables, it has to be a boolean
+func (e *Expression) Evaluate(memory map[string]interface{}) (bool, error) {
+	v, err := e.value(memory)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("%s is not a boolean", describe(v))
+	}
+	return b, nil
+}
+
+// Variables lists the process variables the expression reads, sorted
+func (e *Expression) Variables() []string {
+	seen := make(map[string]bool)
+	var walk func(*Expression)
+	walk = func(e *Expression) {
+		if e.Name != "" {
+			seen[e.Name] = true
+		}
+		for _, operand := range e.Operands {
+			walk(operand)
+		}
+	}
+	walk(e)
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (e *Expression) value(memory map[string]interface{}) (interface{}, error) {
+	switch e.Op {
+	case "":
+		if e.Name == "" {
+			return e.Value, nil
+		}
+		v, ok := memory[e.Name]
+		if !ok {
+			return nil, fmt.Errorf("variable %s is not set", e.Name)
+		}
+		return v, nil
+	case NotOp:
+		b, err := e.Operands[0].Evaluate(memory)
+		return !b, err
+	case AndOp, OrOp:
+		// 两侧都求值，未设置的变量总会报错
+		left, err := e.Operands[0].Evaluate(memory)
+		if err != nil {
+			return nil, err
+		}
+		right, err := e.Operands[1].Evaluate(memory)
+		if err != nil {
+			return nil, err
+		}
+		if e.Op == AndOp {
+			return left && right, nil
+		}
+		return left || right, nil
+	}
+
+	left, err := e.Operands[0].value(memory)
+	if err != nil {
+		return nil, err
+	}
+	right, err := e.Operands[1].value(memory)
+	if err != nil {
+		return nil, err
+	}
+	return compareValues(e.Op, left, right)
+}
+
+func compareValues(op string, left interface{}, right interface{}) (bool, error) {
+	var c int
+	switch l := left.(type) {
+	case bool:
+		r, ok := right.(bool)
+		if !ok {
+			return false, fmt.Errorf("can not compare %s with %s", describe(left), describe(right))
+		}
+		switch op {
+		case "=":
+			return l == r, nil
+		case "!=":
+			return l != r, nil
+		}
+		return false, fmt.Errorf("booleans can not be compared with %s", op)
+	case string:
+		r, ok := right.(string)
+		if !ok {
+			// decimal fields may hold numeric strings such as "12.50"
+			return compareNumbers(op, left, right)
+		}
+		c = strings.Compare(l, r)
+	default:
+		return compareNumbers(op, left, right)
+	}
+	return compareResult(op, c), nil
+}
+
+func compareNumbers(op string, left interface{}, right interface{}) (bool, error) {
+	l, lok := toRat(left)
+	r, rok := toRat(right)
+	if !lok || !rok {
+		return false, fmt.Errorf("can not compare %s with %s", describe(left), describe(right))
+	}
+	return compareResult(op, l.Cmp(r)), nil
+}
+
+func compareResult(op string, c int) bool {
+	switch op {
+	case "=":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	}
+	return c >= 0
+}
+
+// toRat converts a number exactly, whether it was decoded as json.Number or float64
+func toRat(v interface{}) (*big.Rat, bool) {
+	switch n := v.(type) {
+	case json.Number:
+		return new(big.Rat).SetString(n.String())
+	case string:
+		if !decimalPattern.MatchString(n) {
+			return nil, false
+		}
+		return new(big.Rat).SetString(n)
+	case float64:
+		return new(big.Rat).SetFloat64(n), true
+	case int:
+		return new(big.Rat).SetInt64(int64(n)), true
+	case int64:
+		return new(big.Rat).SetInt64(n), true
+	case uint:
+		return new(big.Rat).SetUint64(uint64(n)), true
+	case uint64:
+		return new(big.Rat).SetUint64(n), true
+	}
+	return nil, false
+}
+
+type token struct {
+	kind string // "name", "number", "string" or the operator itself
+	text string
+}
+
+func (t token) String() string {
+	if t.kind == "string" {
+		return strconv.Quote(t.text)
+	}
+	return t.text
+}
+
+func tokenize(source string) ([]token, error) {
+	var tokens []token
+	runes := []rune(source)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '_' || unicode.IsLetter(r):
+			j := i + 1
+			for j < len(runes) && (runes[j] == '_' || unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j])) {
+				j++
+			}
+			tokens = append(tokens, token{"name", string(runes[i:j])})
+			i = j
+		case unicode.IsDigit(r) || r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1]):
+			j := i + 1
+			for j < len(runes) && (unicode.IsDigit(runes[j]) || runes[j] == '.') {
+				j++
+			}
+			text := string(runes[i:j])
+			if !decimalPattern.MatchString(text) {
+				return nil, fmt.Errorf("invalid number %s", text)
+			}
+			tokens = append(tokens, token{"number", text})
+			i = j
+		case r == '"':
+			j := i + 1
+			for j < len(runes) && runes[j] != '"' {
+				if runes[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(runes) {
+				return nil, errors.New("unterminated string")
+			}
+			s, err := strconv.Unquote(string(runes[i : j+1]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid string %s", string(runes[i:j+1]))
+			}
+			tokens = append(tokens, token{"string", s})
+			i = j + 1
+		default:
+			op := string(r)
+			if i+1 < len(runes) {
+				if two := string(runes[i : i+2]); two == "==" || comparisonOps[two] {
+					op = two
+				}
+			}
+			if op != "(" && op != ")" && op != "==" && !comparisonOps[op] {
+				return nil, fmt.Errorf("unexpected %q", r)
+			}
+			tokens = append(tokens, token{op, op})
+			i += len(op)
+		}
+	}
+	return tokens, nil
+}
+
+type expressionParser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *expressionParser) peek() token {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return token{}
+}
+
+func (p *expressionParser) keyword(word string) bool {
+	if t := p.peek(); t.kind == "name" && t.text == word {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *expressionParser) parseOr() (*Expression, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword(OrOp) {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &Expression{Op: OrOp, Operands: []*Expression{left, right}}
+	}
+	return left, nil
+}
+
+func (p *expressionParser) parseAnd() (*Expression, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword(AndOp) {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &Expression{Op: AndOp, Operands: []*Expression{left, right}}
+	}
+	return left, nil
+}
+
+func (p *expressionParser) parseNot() (*Expression, error) {
+	if p.keyword(NotOp) {
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &Expression{Op: NotOp, Operands: []*Expression{operand}}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *expressionParser) parseComparison() (*Expression, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	op := p.peek().kind
+	if op == "==" {
+		op = "="
+	}
+	if !comparisonOps[op] {
+		return left, nil
+	}
+	p.pos++
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	return &Expression{Op: op, Operands: []*Expression{left, right}}, nil
+}
+
+func (p *expressionParser) parseOperand() (*Expression, error) {
+	t := p.peek()
+	p.pos++
+	switch t.kind {
+	case "":
+		return nil, errors.New("unexpected end of condition")
+	case "(":
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek().kind != ")" {
+			return nil, errors.New("missing )")
+		}
+		p.pos++
+		return expr, nil
+	case "number":
+		return &Expression{Value: json.Number(t.text)}, nil
+	case "string":
+		return &Expression{Value: t.text}, nil
+	case "name":
+		switch t.text {
+		case "true", "false":
+			return &Expression{Value: t.text == "true"}, nil
+		case OrOp, AndOp, NotOp:
+			return nil, fmt.Errorf("unexpected %s", t)
+		}
+		return &Expression{Name: t.text}, nil
+	}
+	return nil, fmt.Errorf("unexpected %s", t)
+}
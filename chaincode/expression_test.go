@@ -0,0 +1,84 @@
+package chaincode_test
+
+import (
+	"chaincode-go-bpmn/chaincode"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseExpression(t *testing.T) {
+	expr, err := chaincode.ParseExpression(`quotation <= 500 and not (confirm == false or motivation != "late")`)
+	require.NoError(t, err)
+	require.Equal(t, &chaincode.Expression{Op: chaincode.AndOp, Operands: []*chaincode.Expression{
+		{Op: "<=", Operands: []*chaincode.Expression{{Name: "quotation"}, {Value: json.Number("500")}}},
+		{Op: chaincode.NotOp, Operands: []*chaincode.Expression{
+			{Op: chaincode.OrOp, Operands: []*chaincode.Expression{
+				{Op: "=", Operands: []*chaincode.Expression{{Name: "confirm"}, {Value: false}}},
+				{Op: "!=", Operands: []*chaincode.Expression{{Name: "motivation"}, {Value: "late"}}},
+			}},
+		}},
+	}}, expr)
+	require.Equal(t, []string{"confirm", "motivation", "quotation"}, expr.Variables())
+
+	for source, message := range map[string]string{
+		"":                   "missing condition",
+		"quotation <":        "unexpected end of condition",
+		"(confirm":           "missing )",
+		"confirm = true)":    "unexpected )",
+		"a = 1 = 2":          "unexpected =",
+		"confirm & cancel":   `unexpected '&'`,
+		`motivation = "late`: "unterminated string",
+		"price = 1.2.3":      "invalid number 1.2.3",
+		"confirm and or":     "unexpected or",
+	} {
+		_, err := chaincode.ParseExpression(source)
+		require.EqualError(t, err, message, source)
+	}
+}
+
+func TestEvaluateExpression(t *testing.T) {
+	memory := map[string]interface{}{
+		"quotation":  json.Number("300"),
+		"budget":     300.0,
+		"price":      "12.50",
+		"confirm":    true,
+		"motivation": "late",
+		"big":        json.Number("18446744073709551615"),
+	}
+
+	for source, expected := range map[string]bool{
+		"confirm":                                  true,
+		"quotation <= 500 and confirm = true":      true,
+		"quotation = budget":                       true,
+		"quotation > 300 or motivation = \"late\"": true,
+		"not confirm":                              false,
+		"price >= 12.5":                            true,
+		"price < -1":                               false,
+		`motivation < "soon"`:                      true,
+		"big > 18446744073709551614":               true,
+		"(quotation != 300) = false":               true,
+	} {
+		expr, err := chaincode.ParseExpression(source)
+		require.NoError(t, err, source)
+		ok, err := expr.Evaluate(memory)
+		require.NoError(t, err, source)
+		require.Equal(t, expected, ok, source)
+	}
+
+	for source, message := range map[string]string{
+		"cancel":                     "variable cancel is not set",
+		"confirm or cancel":          "variable cancel is not set",
+		"quotation":                  "300 is not a boolean",
+		`quotation = "high"`:         `can not compare 300 with "high"`,
+		"confirm = 1":                "can not compare true with 1",
+		"confirm < true":             "booleans can not be compared with <",
+		"motivation > 1 and confirm": `can not compare "late" with 1`,
+	} {
+		expr, err := chaincode.ParseExpression(source)
+		require.NoError(t, err, source)
+		_, err = expr.Evaluate(memory)
+		require.EqualError(t, err, message, source)
+	}
+}
@@ -0,0 +1,227 @@
+package chaincode
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FieldType is a type usable in a message format
+type FieldType string
+
+const (
+	StringField  FieldType = "string"
+	IntField     FieldType = "int"
+	UintField    FieldType = "uint"
+	BoolField    FieldType = "bool"
+	AddressField FieldType = "address"
+	DecimalField FieldType = "decimal"
+)
+
+// FormatField is one field of a message format
+type FormatField struct {
+	Name     string
+	Type     FieldType
+	Optional bool
+	Array    bool
+}
+
+// MessageFormat is the parsed form of Message.Format.
+//
+// The grammar is a comma separated list of fields:
+//
+//	field := name ["?"] ":" type ["[]"]
+//	type  := "string" | "int" | "uint" | "bool" | "address" | "decimal"
+//
+// e.g. "date:string, bedrooms:uint, guests?:string[]". A "?" marks an optional field,
+// "[]" a JSON array of the type. Addresses are 0x-prefixed 20 byte hex strings,
+// decimals are JSON numbers or numeric strings such as "12.50".
+type MessageFormat []FormatField
+
+var (
+	fieldPattern   = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)(\?)?\s*:\s*([A-Za-z0-9]+)(\[\])?$`)
+	addressPattern = regexp.MustCompile(`^0x[0-9a-fA-F]{40}$`)
+	decimalPattern = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?$`)
+)
+
+// PayloadFormat checks the payload of a message before it is sent
+type PayloadFormat interface {
+	ParsePayload(payloadJSON string) (map[string]interface{}, error)
+	String() string
+}
+
+// CompileFormat parses Message.Format, which is either a field list or a JSON Schema
+func CompileFormat(format string) (PayloadFormat, error) {
+	if IsSchemaFormat(format) {
+		return ParseSchemaFormat(format)
+	}
+	return ParseFormat(format)
+}
+
+// ParseFormat parses a message format, "" being a message without fields
+func ParseFormat(format string) (MessageFormat, error) {
+	var fields MessageFormat
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(format, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		match := fieldPattern.FindStringSubmatch(part)
+		if match == nil {
+			return nil, fmt.Errorf("invalid field %q in format %q", part, format)
+		}
+
+		field := FormatField{
+			Name:     match[1],
+			Type:     FieldType(match[3]),
+			Optional: match[2] != "",
+			Array:    match[4] != "",
+		}
+		switch field.Type {
+		case StringField, IntField, UintField, BoolField, AddressField, DecimalField:
+		default:
+			return nil, fmt.Errorf("field %s has unknown type %s", field.Name, field.Type)
+		}
+		if seen[field.Name] {
+			return nil, fmt.Errorf("field %s is declared twice", field.Name)
+		}
+		seen[field.Name] = true
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// ParsePayload decodes a JSON payload and checks it field by field against the format.
+// Numbers are kept as json.Number, so integers do not lose precision.
+func (f MessageFormat) ParsePayload(payloadJSON string) (map[string]interface{}, error) {
+	payload, err := decodePayload(payloadJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	declared := make(map[string]bool)
+	for _, field := range f {
+		declared[field.Name] = true
+	}
+	names := make([]string, 0, len(payload))
+	for name := range payload {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !declared[name] {
+			return nil, fmt.Errorf("field %s is not declared in format %q", name, f.String())
+		}
+	}
+
+	for _, field := range f {
+		value, ok := payload[field.Name]
+		if !ok || value == nil {
+			if !field.Optional {
+				return nil, fmt.Errorf("field %s is required", field.Name)
+			}
+			delete(payload, field.Name)
+			continue
+		}
+
+		if !field.Array {
+			if err := checkValue(field.Name, field.Type, value); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		items, ok := value.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("field %s: expected an array of %s", field.Name, field.Type)
+		}
+		for i, item := range items {
+			if err := checkValue(fmt.Sprintf("%s[%d]", field.Name, i), field.Type, item); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return payload, nil
+}
+
+// decodePayload decodes a JSON object, "" being an empty payload
+func decodePayload(payloadJSON string) (map[string]interface{}, error) {
+	payload := make(map[string]interface{})
+	if strings.TrimSpace(payloadJSON) == "" {
+		return payload, nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader([]byte(payloadJSON)))
+	decoder.UseNumber()
+	if err := decoder.Decode(&payload); err != nil {
+		return nil, fmt.Errorf("payload is not a JSON object: %v", err)
+	}
+	if decoder.More() {
+		return nil, errors.New("payload is not a JSON object: unexpected data after the object")
+	}
+	return payload, nil
+}
+
+func checkValue(name string, fieldType FieldType, value interface{}) error {
+	ok := false
+	switch fieldType {
+	case StringField:
+		_, ok = value.(string)
+	case BoolField:
+		_, ok = value.(bool)
+	case IntField:
+		if n, isNumber := value.(json.Number); isNumber {
+			_, err := strconv.ParseInt(n.String(), 10, 64)
+			ok = err == nil
+		}
+	case UintField:
+		if n, isNumber := value.(json.Number); isNumber {
+			_, err := strconv.ParseUint(n.String(), 10, 64)
+			ok = err == nil
+		}
+	case AddressField:
+		s, isString := value.(string)
+		ok = isString && addressPattern.MatchString(s)
+	case DecimalField:
+		switch v := value.(type) {
+		case json.Number:
+			_, err := strconv.ParseFloat(v.String(), 64)
+			ok = err == nil
+		case string:
+			ok = decimalPattern.MatchString(v)
+		}
+	}
+	if !ok {
+		return fmt.Errorf("field %s: %s is not a valid %s", name, describe(value), fieldType)
+	}
+	return nil
+}
+
+func describe(value interface{}) string {
+	valueJSON, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(valueJSON)
+}
+
+// String renders the format back into its canonical text
+func (f MessageFormat) String() string {
+	parts := make([]string, len(f))
+	for i, field := range f {
+		part := field.Name
+		if field.Optional {
+			part += "?"
+		}
+		part += ":" + string(field.Type)
+		if field.Array {
+			part += "[]"
+		}
+		parts[i] = part
+	}
+	return strings.Join(parts, ", ")
+}
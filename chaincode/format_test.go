@@ -0,0 +1,95 @@
+package chaincode_test
+
+import (
+	"chaincode-go-bpmn/chaincode"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFormat(t *testing.T) {
+	format, err := chaincode.ParseFormat("date:string, bedrooms:uint, guests?:string[], price:decimal")
+	require.NoError(t, err)
+	require.Equal(t, chaincode.MessageFormat{
+		{Name: "date", Type: chaincode.StringField},
+		{Name: "bedrooms", Type: chaincode.UintField},
+		{Name: "guests", Type: chaincode.StringField, Optional: true, Array: true},
+		{Name: "price", Type: chaincode.DecimalField},
+	}, format)
+	require.Equal(t, "date:string, bedrooms:uint, guests?:string[], price:decimal", format.String())
+
+	format, err = chaincode.ParseFormat("")
+	require.NoError(t, err)
+	require.Empty(t, format)
+
+	_, err = chaincode.ParseFormat("date string")
+	require.EqualError(t, err, `invalid field "date string" in format "date string"`)
+	_, err = chaincode.ParseFormat("id:bytes32")
+	require.EqualError(t, err, "field id has unknown type bytes32")
+	_, err = chaincode.ParseFormat("id:string, id:uint")
+	require.EqualError(t, err, "field id is declared twice")
+}
+
+func TestParsePayload(t *testing.T) {
+	format, err := chaincode.ParseFormat("to:address, amount:decimal, rooms:int[], note?:string, paid?:bool")
+	require.NoError(t, err)
+
+	payload, err := format.ParsePayload(`{"to":"0x5B38Da6a701c568545dCfcB03FcB875f56beddC4","amount":"12.50","rooms":[101,-1],"note":null}`)
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"to":     "0x5B38Da6a701c568545dCfcB03FcB875f56beddC4",
+		"amount": "12.50",
+		"rooms":  []interface{}{json.Number("101"), json.Number("-1")},
+	}, payload)
+
+	for payloadJSON, expected := range map[string]string{
+		`{"to":"0x5B38","amount":1,"rooms":[]}`:                                                "field to: \"0x5B38\" is not a valid address",
+		`{"to":"0x5B38Da6a701c568545dCfcB03FcB875f56beddC4","amount":"1e3","rooms":[]}`:        "field amount: \"1e3\" is not a valid decimal",
+		`{"to":"0x5B38Da6a701c568545dCfcB03FcB875f56beddC4","amount":1,"rooms":[1.5]}`:         "field rooms[0]: 1.5 is not a valid int",
+		`{"to":"0x5B38Da6a701c568545dCfcB03FcB875f56beddC4","amount":1,"rooms":101}`:           "field rooms: expected an array of int",
+		`{"to":"0x5B38Da6a701c568545dCfcB03FcB875f56beddC4","amount":1,"rooms":[],"paid":"y"}`: "field paid: \"y\" is not a valid bool",
+		`{"to":"0x5B38Da6a701c568545dCfcB03FcB875f56beddC4","rooms":[]}`:                       "field amount is required",
+	} {
+		_, err := format.ParsePayload(payloadJSON)
+		require.EqualError(t, err, expected, payloadJSON)
+	}
+
+	_, err = format.ParsePayload(`"text"`)
+	require.ErrorContains(t, err, "payload is not a JSON object")
+}
+
+func TestSchemaFormat(t *testing.T) {
+	format, err := chaincode.CompileFormat(`{
+		"type": "object",
+		"properties": {"items": {"type": "array", "items": {"type": "object", "properties": {"sku": {"type": "string"}, "quantity": {"type": "integer", "minimum": 1}}}}},
+		"required": ["items"]
+	}`)
+	require.NoError(t, err)
+	require.IsType(t, &chaincode.SchemaFormat{}, format)
+	require.Equal(t, `{"type":"object","properties":{"items":{"type":"array","items":{"type":"object","properties":{"sku":{"type":"string"},"quantity":{"type":"integer","minimum":1}}}}},"required":["items"]}`, format.String())
+
+	payload, err := format.ParsePayload(`{"items":[{"sku":"A-1","quantity":2}]}`)
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"items": []interface{}{map[string]interface{}{"sku": "A-1", "quantity": json.Number("2")}},
+	}, payload)
+
+	_, err = format.ParsePayload(`{"items":[{"sku":"A-1","quantity":0}]}`)
+	require.EqualError(t, err, "payload does not match the schema: items.0.quantity: Must be greater than or equal to 1")
+	_, err = format.ParsePayload(`{}`)
+	require.EqualError(t, err, "payload does not match the schema: (root): items is required")
+	_, err = format.ParsePayload(`[]`)
+	require.ErrorContains(t, err, "payload is not a JSON object")
+
+	format, err = chaincode.CompileFormat("date:string")
+	require.NoError(t, err)
+	require.IsType(t, chaincode.MessageFormat{}, format)
+
+	_, err = chaincode.CompileFormat(`{"type": "object"`)
+	require.ErrorContains(t, err, "schema is not valid JSON")
+	_, err = chaincode.CompileFormat(`{"type": "record"}`)
+	require.ErrorContains(t, err, "invalid schema")
+	_, err = chaincode.CompileFormat(`{"properties": {"guest": {"$ref": "https://example.com/guest.json"}}}`)
+	require.EqualError(t, err, "schema reference https://example.com/guest.json is not allowed, only local references are supported")
+}
@@ -0,0 +1,44 @@
+package chaincode
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var sha256Pattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// CanonicalPayloadHash returns the hex encoded SHA-256 of the canonical JSON of a payload:
+// object keys sorted, no insignificant whitespace, numbers as written by the sender and
+// no HTML escaping. A send without payload fields may give the FireFly data hash instead.
+func CanonicalPayloadHash(payloadJSON string) (string, error) {
+	payload, err := decodePayload(payloadJSON)
+	if err != nil {
+		return "", err
+	}
+
+	var canonical bytes.Buffer
+	encoder := json.NewEncoder(&canonical)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(payload); err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(bytes.TrimSuffix(canonical.Bytes(), []byte("\n")))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// NormalizePayloadHash accepts a hex encoded SHA-256, optionally 0x prefixed, in either case
+func NormalizePayloadHash(payloadHash string) (string, error) {
+	hash := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(payloadHash), "0x"))
+	if !sha256Pattern.MatchString(hash) {
+		errorMessage := fmt.Sprintf("Payload hash %q is not a hex encoded SHA-256", payloadHash)
+		fmt.Println(errorMessage)
+		return "", errors.New(errorMessage)
+	}
+	return hash, nil
+}
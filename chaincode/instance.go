@@ -0,0 +1,275 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	instanceObjectType = "instance"
+	elementObjectType  = "instance~element"
+	memoryObjectType   = "instance~memory"
+)
+
+// InstanceStatus tells whether an instance still accepts messages
+type InstanceStatus string
+
+const (
+	InstanceRunning   InstanceStatus = "running"
+	InstanceFailed    InstanceStatus = "failed" // a message was rejected more often than its policy allows
+	InstanceSuspended InstanceStatus = "suspended"
+	InstanceCancelled InstanceStatus = "cancelled"
+)
+
+// Instance is one run of a deployed choreography
+type Instance struct {
+	InstanceID   string            `json:"instanceID"`
+	DefinitionID string            `json:"definitionID"`
+	Run          int               `json:"run"` // incremented by every ResetInstance
+	Status       InstanceStatus    `json:"status"`
+	Bindings     string            `json:"bindings,omitempty"`  // participantBindings of CreateInstance, restored by ResetInstance
+	Pending      *LifecycleChange  `json:"pending,omitempty"`   // lifecycle change still waiting for approvals
+	Lifecycle    []LifecycleChange `json:"lifecycle,omitempty"` // suspensions, resumptions and cancellation
+}
+
+// elementKey scopes the record of a choreography element to its instance
+func elementKey(ctx contractapi.TransactionContextInterface, instanceID string, elementID string) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(elementObjectType, []string{instanceID, elementID})
+}
+
+// putElement writes an element record without checking whether it exists
+func putElement(ctx contractapi.TransactionContextInterface, instanceID string, elementID string, record interface{}) error {
+	key, err := elementKey(ctx, instanceID, elementID)
+	if err != nil {
+		return err
+	}
+	recordJSON, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("序列化元素数据时出错: %v", err)
+	}
+	if err := ctx.GetStub().PutState(key, recordJSON); err != nil {
+		return fmt.Errorf("保存元素数据时出错: %v", err)
+	}
+	return nil
+}
+
+// CreateInstance starts a new run of a deployed choreography and returns its ID.
+// participantBindings is a JSON object binding every BPMN participant, by ID or name, to an MSP ID
+// and optionally a role, e.g. {"Client":"Org1MSP","Participant_0sktaei":{"mspID":"Org2MSP","role":"hotel"}}.
+// A binding object may also restrict the participant to certain users, see ParticipantBinding:
+// {"mspID":"Org2MSP","attributes":{"role":"booking-agent"},"subjects":["CN=agent1,OU=client"],"clientIDs":[...]}.
+// A participant only known at runtime is bound late instead: {"Hotel":{"bindBy":"Client","candidates":["Org2MSP","Org3MSP"]}}
+// lets the client bind it with BindParticipant, {"bindBy":"self"} binds it to the organization sending its first message,
+// which is refused for a participant that may receive a message before it sends one.
+// The bindings make up the participant registry of the instance.
+func (cc *SmartContract) CreateInstance(ctx contractapi.TransactionContextInterface, definitionID string, participantBindings string) (string, error) {
+	stub := ctx.GetStub()
+	chor, err := cc.ReadChoreography(ctx, definitionID)
+	if err != nil {
+		return "", err
+	}
+
+	// 交易ID在通道内唯一，各背书节点一致
+	instance := &Instance{
+		InstanceID:   stub.GetTxID(),
+		DefinitionID: definitionID,
+		Status:       InstanceRunning,
+		Bindings:     participantBindings,
+	}
+	bindings, err := parseBindings(chor, instance.InstanceID, participantBindings)
+	if err != nil {
+		return "", err
+	}
+	key, err := stub.CreateCompositeKey(instanceObjectType, []string{instance.InstanceID})
+	if err != nil {
+		return "", err
+	}
+	existingData, err := stub.GetState(key)
+	if err != nil {
+		return "", fmt.Errorf("获取状态数据时出错: %v", err)
+	}
+	if existingData != nil {
+		return "", fmt.Errorf("实例 %s 已存在", instance.InstanceID)
+	}
+
+	if err := cc.putInstance(ctx, instance); err != nil {
+		return "", err
+	}
+	for _, participantID := range sortedParticipantIDs(chor) {
+		if err := cc.putParticipant(ctx, bindings[participantID]); err != nil {
+			return "", err
+		}
+	}
+
+	if err := cc.seedInstance(ctx, instance, chor); err != nil {
+		return "", err
+	}
+
+	if err := stub.SetEvent("createInstanceEvent", []byte(instance.InstanceID)); err != nil {
+		return "", err
+	}
+	return instance.InstanceID, nil
+}
+
+// seedInstance writes the initial record of every element of the instance,
+// replacing whatever a previous run left behind
+func (cc *SmartContract) seedInstance(ctx contractapi.TransactionContextInterface, instance *Instance, chor *Choreography) error {
+	mspIDs := make(map[string]string)
+	for _, participantID := range sortedParticipantIDs(chor) {
+		binding, err := cc.ReadParticipant(ctx, instance.InstanceID, participantID)
+		if err != nil {
+			return err
+		}
+		mspIDs[participantID] = binding.MspID // 晚绑定的参与方在绑定前为空
+	}
+
+	// 按ID排序，保证各背书节点的写入顺序一致
+	for _, id := range sortedElementIDs(chor) {
+		el := chor.Elements[id]
+		var err error
+		switch el.Type {
+		case StartEventElement:
+			err = putElement(ctx, instance.InstanceID, id, &ActionEvent{DocType: EventDocType, InstanceID: instance.InstanceID, EventID: id, EventState: ENABLE})
+		case EndEventElement:
+			err = putElement(ctx, instance.InstanceID, id, &ActionEvent{DocType: EventDocType, InstanceID: instance.InstanceID, EventID: id, EventState: DISABLE})
+		case ExclusiveGatewayElement, EventBasedGatewayElement, ParallelGatewayElement, InclusiveGatewayElement:
+			err = putElement(ctx, instance.InstanceID, id, &Gateway{DocType: GatewayDocType, InstanceID: instance.InstanceID, GatewayID: id, GatewayState: DISABLE})
+		case ChoreographyTaskElement:
+			for _, messageID := range el.Messages {
+				def := chor.Messages[messageID]
+				err = putElement(ctx, instance.InstanceID, messageID, &Message{
+					DocType:      MessageDocType,
+					InstanceID:   instance.InstanceID,
+					MessageID:    messageID,
+					SendMspID:    mspIDs[def.SendParticipant],
+					ReceiveMspID: mspIDs[def.ReceiveParticipant],
+					MsgState:     DISABLE,
+					Format:       def.Format,
+				})
+				if err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (cc *SmartContract) putInstance(ctx contractapi.TransactionContextInterface, instance *Instance) error {
+	key, err := ctx.GetStub().CreateCompositeKey(instanceObjectType, []string{instance.InstanceID})
+	if err != nil {
+		return err
+	}
+	instanceJSON, err := json.Marshal(instance)
+	if err != nil {
+		return fmt.Errorf("序列化实例数据时出错: %v", err)
+	}
+	if err := ctx.GetStub().PutState(key, instanceJSON); err != nil {
+		return fmt.Errorf("保存实例数据时出错: %v", err)
+	}
+	return nil
+}
+
+// ReadInstance returns the instance record
+func (cc *SmartContract) ReadInstance(ctx contractapi.TransactionContextInterface, instanceID string) (*Instance, error) {
+	stub := ctx.GetStub()
+	key, err := stub.CreateCompositeKey(instanceObjectType, []string{instanceID})
+	if err != nil {
+		return nil, err
+	}
+	instanceJSON, err := stub.GetState(key)
+	if err != nil {
+		fmt.Println(err.Error())
+		return nil, err
+	}
+
+	if instanceJSON == nil {
+		errorMessage := fmt.Sprintf("Instance %s does not exist", instanceID)
+		fmt.Println(errorMessage)
+		return nil, errors.New(errorMessage)
+	}
+
+	var instance Instance
+	err = json.Unmarshal(instanceJSON, &instance)
+	if err != nil {
+		fmt.Println(err.Error())
+		return nil, err
+	}
+
+	return &instance, nil
+}
+
+// readInstance resolves the instance a transaction refers to together with its definition
+func (cc *SmartContract) readInstance(ctx contractapi.TransactionContextInterface, instanceID string) (*Instance, *Choreography, error) {
+	instance, err := cc.ReadInstance(ctx, instanceID)
+	if err != nil {
+		return nil, nil, err
+	}
+	chor, err := cc.ReadChoreography(ctx, instance.DefinitionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return instance, chor, nil
+}
+
+// runningInstance is readInstance for transactions that advance an instance
+func (cc *SmartContract) runningInstance(ctx contractapi.TransactionContextInterface, instanceID string) (*Instance, *Choreography, error) {
+	instance, chor, err := cc.readInstance(ctx, instanceID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if instance.Status != InstanceRunning {
+		errorMessage := fmt.Sprintf("Instance %s is %s", instanceID, instance.Status)
+		fmt.Println(errorMessage)
+		return nil, nil, errors.New(errorMessage)
+	}
+	return instance, chor, nil
+}
+
+// ReadMemory returns the process variables of an instance
+func (cc *SmartContract) ReadMemory(ctx contractapi.TransactionContextInterface, instanceID string) (StateMemory, error) {
+	stub := ctx.GetStub()
+	key, err := stub.CreateCompositeKey(memoryObjectType, []string{instanceID})
+	if err != nil {
+		return nil, err
+	}
+	memoryJSON, err := stub.GetState(key)
+	if err != nil {
+		fmt.Println(err.Error())
+		return nil, err
+	}
+
+	memory := make(StateMemory)
+	if memoryJSON == nil {
+		return memory, nil
+	}
+	if err := unmarshalNumbers(memoryJSON, &memory); err != nil {
+		fmt.Println(err.Error())
+		return nil, err
+	}
+	return memory, nil
+}
+
+// putMemory persists the process variables of an instance. Maps are marshalled
+// with sorted keys, so every endorsing peer writes the same bytes.
+func (cc *SmartContract) putMemory(ctx contractapi.TransactionContextInterface, instanceID string, memory StateMemory) error {
+	stub := ctx.GetStub()
+	key, err := stub.CreateCompositeKey(memoryObjectType, []string{instanceID})
+	if err != nil {
+		return err
+	}
+	memoryJSON, err := json.Marshal(memory)
+	if err != nil {
+		return fmt.Errorf("序列化流程变量时出错: %v", err)
+	}
+	if err := stub.PutState(key, memoryJSON); err != nil {
+		return fmt.Errorf("保存流程变量时出错: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,256 @@
+package chaincode
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// LifecycleAction is a change of the status of an instance
+type LifecycleAction string
+
+const (
+	SuspendAction LifecycleAction = "suspend"
+	ResumeAction  LifecycleAction = "resume"
+	CancelAction  LifecycleAction = "cancel"
+)
+
+// LifecycleRule tells who may carry out a LifecycleAction
+type LifecycleRule string
+
+const (
+	AnyParticipant  LifecycleRule = "anyParticipant"  // one of the bound participants
+	AllParticipants LifecycleRule = "allParticipants" // every bound participant, one transaction each
+	AdminOrg        LifecycleRule = "adminOrg"        // the organization that initialized the ledger
+)
+
+// LifecyclePolicy is declared in the documentation of the choreography, e.g.
+// <bpmn2:documentation textFormat="application/vnd.choreography-policy+json">{"cancel":"adminOrg"}</bpmn2:documentation>.
+// Any participant may suspend and resume an instance and all participants have to agree
+// to cancel it, unless the policy says otherwise.
+type LifecyclePolicy struct {
+	Suspend LifecycleRule `json:"suspend,omitempty"`
+	Resume  LifecycleRule `json:"resume,omitempty"`
+	Cancel  LifecycleRule `json:"cancel,omitempty"`
+}
+
+// LifecycleChange records who suspended, resumed or cancelled an instance and why
+type LifecycleChange struct {
+	Action     LifecycleAction `json:"action"`
+	Reason     string          `json:"reason,omitempty"`
+	ApprovedBy []string        `json:"approvedBy"` // participant IDs, or the MSP ID of the admin organization
+	ClientIDs  []string        `json:"clientIDs"`  // identities that asked for the change
+	TxID       string          `json:"txID,omitempty"`
+}
+
+func (p LifecyclePolicy) rule(action LifecycleAction) LifecycleRule {
+	rule, fallback := p.Suspend, AnyParticipant
+	switch action {
+	case ResumeAction:
+		rule, fallback = p.Resume, AnyParticipant
+	case CancelAction:
+		rule, fallback = p.Cancel, AllParticipants
+	}
+	if rule == "" {
+		return fallback
+	}
+	return rule
+}
+
+func (p LifecyclePolicy) validate() error {
+	for _, action := range []LifecycleAction{SuspendAction, ResumeAction, CancelAction} {
+		switch rule := p.rule(action); rule {
+		case AnyParticipant, AllParticipants, AdminOrg:
+		default:
+			return fmt.Errorf("unknown rule %s for %s", rule, action)
+		}
+	}
+	return nil
+}
+
+// SuspendInstance pauses a running instance. Until it is resumed, messages can be
+// neither sent, confirmed, rejected nor retracted.
+func (cc *SmartContract) SuspendInstance(ctx contractapi.TransactionContextInterface, instanceID string, reason string) error {
+	return cc.changeLifecycle(ctx, instanceID, SuspendAction, reason)
+}
+
+// ResumeInstance lets a suspended instance continue where it stopped
+func (cc *SmartContract) ResumeInstance(ctx contractapi.TransactionContextInterface, instanceID string, reason string) error {
+	return cc.changeLifecycle(ctx, instanceID, ResumeAction, reason)
+}
+
+// CancelInstance ends a running or suspended instance for good. Elements that are enabled
+// or waiting for confirmation become CANCELLED.
+func (cc *SmartContract) CancelInstance(ctx contractapi.TransactionContextInterface, instanceID string, reason string) error {
+	return cc.changeLifecycle(ctx, instanceID, CancelAction, reason)
+}
+
+// changeLifecycle checks the caller against the rule of the choreography for the action.
+// Under AllParticipants each participant approves in its own transaction; the change is
+// pending on the instance until the last one has approved, and no other change is accepted
+// in the meantime.
+func (cc *SmartContract) changeLifecycle(ctx contractapi.TransactionContextInterface, instanceID string, action LifecycleAction, reason string) error {
+	stub := ctx.GetStub()
+	instance, chor, err := cc.readInstance(ctx, instanceID)
+	if err != nil {
+		return err
+	}
+
+	allowed := instance.Status == InstanceRunning
+	switch action {
+	case ResumeAction:
+		allowed = instance.Status == InstanceSuspended
+	case CancelAction:
+		allowed = instance.Status == InstanceRunning || instance.Status == InstanceSuspended
+	}
+	if !allowed {
+		errorMessage := fmt.Sprintf("Instance %s is %s", instanceID, instance.Status)
+		fmt.Println(errorMessage)
+		return errors.New(errorMessage)
+	}
+
+	clientID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return err
+	}
+	clientMspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return err
+	}
+
+	rule := chor.Lifecycle.rule(action)
+	var approvedBy, required []string
+	if rule == AdminOrg {
+		metadata, err := cc.ReadLedgerMetadata(ctx)
+		if err != nil {
+			return err
+		}
+		if clientMspID != metadata.MspID {
+			return denied("Lifecycle denied: only %s may %s instance %s", metadata.MspID, action, instanceID)
+		}
+		approvedBy = []string{clientMspID}
+	} else {
+		for _, participantID := range sortedParticipantIDs(chor) {
+			binding, err := cc.ReadParticipant(ctx, instanceID, participantID)
+			if err != nil {
+				return err
+			}
+			if binding.MspID == "" {
+				continue
+			}
+			required = append(required, participantID)
+			if cc.authorizeParticipant(ctx, instanceID, participantID) == nil {
+				approvedBy = append(approvedBy, participantID)
+			}
+		}
+		if len(approvedBy) == 0 {
+			return denied("Lifecycle denied: only a participant may %s instance %s", action, instanceID)
+		}
+	}
+
+	// 等待同意的变更不会被其他操作覆盖，已收集的同意不会丢失
+	change := instance.Pending
+	if change != nil && change.Action != action {
+		errorMessage := fmt.Sprintf("Instance %s is waiting for approvals to %s, it cannot %s before", instanceID, change.Action, action)
+		fmt.Println(errorMessage)
+		return errors.New(errorMessage)
+	}
+	if change == nil {
+		change = &LifecycleChange{Action: action}
+	}
+	for _, participantID := range approvedBy {
+		if !contains(change.ApprovedBy, participantID) {
+			change.ApprovedBy = append(change.ApprovedBy, participantID)
+		}
+	}
+	sort.Strings(change.ApprovedBy)
+	if !contains(change.ClientIDs, clientID) {
+		change.ClientIDs = append(change.ClientIDs, clientID)
+	}
+	if reason != "" {
+		change.Reason = reason
+	}
+
+	if rule == AllParticipants {
+		for _, participantID := range required {
+			if !contains(change.ApprovedBy, participantID) {
+				// 等待其余参与方同意
+				instance.Pending = change
+				if err := cc.putInstance(ctx, instance); err != nil {
+					return err
+				}
+				return stub.SetEvent("lifecycleApprovalEvent", []byte(fmt.Sprintf("%s of instance %s is waiting for %s", action, instanceID, participantID)))
+			}
+		}
+	}
+
+	change.TxID = stub.GetTxID()
+	instance.Pending = nil
+	instance.Lifecycle = append(instance.Lifecycle, *change)
+	switch action {
+	case SuspendAction:
+		instance.Status = InstanceSuspended
+		// 暂停期间没有可以执行的待办事项
+		if err := cc.withdrawWorklist(ctx, instanceID, chor); err != nil {
+			return err
+		}
+	case ResumeAction:
+		instance.Status = InstanceRunning
+		if err := cc.restoreWorklist(ctx, instanceID, chor); err != nil {
+			return err
+		}
+	case CancelAction:
+		instance.Status = InstanceCancelled
+		if err := cc.cancelElements(ctx, instanceID, chor); err != nil {
+			return err
+		}
+	}
+	if err := cc.putInstance(ctx, instance); err != nil {
+		return err
+	}
+	return stub.SetEvent(string(action)+"InstanceEvent", []byte(instanceID))
+}
+
+// cancelElements moves every element that is still enabled or waiting into CANCELLED
+func (cc *SmartContract) cancelElements(ctx contractapi.TransactionContextInterface, instanceID string, chor *Choreography) error {
+	for _, id := range sortedElementIDs(chor) {
+		el := chor.Elements[id]
+		switch el.Type {
+		case ChoreographyTaskElement:
+			for _, messageID := range el.Messages {
+				msg, err := cc.ReadMsg(ctx, instanceID, messageID)
+				if err != nil {
+					return err
+				}
+				if msg.MsgState == ENABLE || msg.MsgState == WAITFORCONFIRM {
+					if err := cc.changeMsgState(ctx, instanceID, messageID, CANCELLED); err != nil {
+						return err
+					}
+				}
+			}
+		case StartEventElement, EndEventElement:
+			event, err := cc.ReadEvent(ctx, instanceID, id)
+			if err != nil {
+				return err
+			}
+			if event.EventState == ENABLE {
+				if err := cc.changeEventState(ctx, instanceID, id, CANCELLED); err != nil {
+					return err
+				}
+			}
+		default:
+			gtw, err := cc.ReadGtw(ctx, instanceID, id)
+			if err != nil {
+				return err
+			}
+			if gtw.GatewayState == ENABLE {
+				if err := cc.changeGtwState(ctx, instanceID, id, CANCELLED); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
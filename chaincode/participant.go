@@ -0,0 +1,502 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const participantObjectType = "instance~participant"
+
+// ParticipantBinding is the entry of the participant registry of an instance. It maps a
+// BPMN participant to the organization playing it, so the model does not have to name
+// its participants after MSP IDs.
+//
+// Besides the MSP, a binding may narrow down which users of the organization act for the
+// participant: all Attributes have to be present in the caller's certificate, and if
+// Subjects or ClientIDs are given the caller has to match one of them.
+//
+// A participant that is only known at runtime, such as the hotel the client picks, is left
+// without MSP and bound late: BindBy names the participant that binds it with BindParticipant,
+// or is SelfBinding for a participant bound to the organization sending its first message.
+// Candidates, if given, limit the MSPs it may be bound to. A binding never changes once made.
+type ParticipantBinding struct {
+	DocType       string            `json:"docType"`
+	InstanceID    string            `json:"instanceID"`
+	ParticipantID string            `json:"participantID"` // BPMN participant ID
+	Name          string            `json:"name"`          // BPMN participant name
+	Role          string            `json:"role"`          // e.g. "client", "hotel"
+	MspID         string            `json:"mspID"`
+	Attributes    map[string]string `json:"attributes,omitempty"` // X.509 attributes, e.g. role=booking-agent
+	Subjects      []string          `json:"subjects,omitempty"`   // certificate subject DNs
+	ClientIDs     []string          `json:"clientIDs,omitempty"`  // client identity IDs as returned by cid
+	BindBy        string            `json:"bindBy,omitempty"`     // participant ID or SelfBinding
+	Candidates    []string          `json:"candidates,omitempty"` // MSPs a late binding may choose
+}
+
+// SelfBinding is the BindBy of a participant bound by the first message it sends
+const SelfBinding = "self"
+
+// participantSpec is the value of one entry of the participantBindings of CreateInstance
+type participantSpec struct {
+	MspID      string            `json:"mspID"`
+	Role       string            `json:"role"`
+	Attributes map[string]string `json:"attributes"`
+	Subjects   []string          `json:"subjects"`
+	ClientIDs  []string          `json:"clientIDs"`
+	BindBy     string            `json:"bindBy"`
+	Candidates []string          `json:"candidates"`
+}
+
+func (spec *participantSpec) UnmarshalJSON(data []byte) error {
+	// "Org1MSP" is short for {"mspID":"Org1MSP"}
+	var mspID string
+	if err := json.Unmarshal(data, &mspID); err == nil {
+		spec.MspID = mspID
+		return nil
+	}
+	type plain participantSpec
+	return json.Unmarshal(data, (*plain)(spec))
+}
+
+// parseBindings resolves the participantBindings of CreateInstance against the participants
+// of a choreography. Participants may be referred to by BPMN ID or by name; the role
+// defaults to the lower-cased name. A participant without MSP has to declare who binds it.
+func parseBindings(chor *Choreography, instanceID string, participantBindings string) (map[string]*ParticipantBinding, error) {
+	specs := make(map[string]participantSpec)
+	if err := json.Unmarshal([]byte(participantBindings), &specs); err != nil {
+		return nil, fmt.Errorf("participant bindings are not a JSON object: %v", err)
+	}
+
+	bindings := make(map[string]*ParticipantBinding)
+	for ref, spec := range specs {
+		participantID, err := resolveParticipant(chor, ref)
+		if err != nil {
+			return nil, err
+		}
+		if _, exists := bindings[participantID]; exists {
+			return nil, fmt.Errorf("Participant %s is bound twice", participantID)
+		}
+
+		bindBy := spec.BindBy
+		switch {
+		case bindBy == "":
+		case spec.MspID != "":
+			return nil, fmt.Errorf("Participant %s is bound to %s and cannot be bound late", participantID, spec.MspID)
+		case bindBy != SelfBinding:
+			if bindBy, err = resolveParticipant(chor, bindBy); err != nil {
+				return nil, err
+			}
+			if bindBy == participantID {
+				return nil, fmt.Errorf("Participant %s cannot bind itself, use %q to bind it on its first message", participantID, SelfBinding)
+			}
+		}
+
+		p := chor.Participants[participantID]
+		role := spec.Role
+		if role == "" {
+			role = strings.ToLower(p.Name)
+		}
+		bindings[participantID] = &ParticipantBinding{
+			DocType:       ParticipantDocType,
+			InstanceID:    instanceID,
+			ParticipantID: participantID,
+			Name:          p.Name,
+			Role:          role,
+			MspID:         spec.MspID,
+			Attributes:    spec.Attributes,
+			Subjects:      spec.Subjects,
+			ClientIDs:     spec.ClientIDs,
+			BindBy:        bindBy,
+			Candidates:    spec.Candidates,
+		}
+	}
+
+	for _, participantID := range sortedParticipantIDs(chor) {
+		binding, ok := bindings[participantID]
+		if !ok || (binding.MspID == "" && binding.BindBy == "") {
+			return nil, fmt.Errorf("Participant %s is not bound to an MSP", participantID)
+		}
+		// 自绑定的参与方在发送第一条消息前收到的消息无人可以确认，实例会停滞
+		if binding.BindBy == SelfBinding {
+			if messageID := receivedBeforeSending(chor, participantID); messageID != "" {
+				return nil, fmt.Errorf("Participant %s may receive message %s before it sends one and cannot bind itself", participantID, messageID)
+			}
+		}
+	}
+	return bindings, nil
+}
+
+// receivedBeforeSending walks the choreography from its start events and returns a message
+// the participant receives on some path before sending any, or "" if it always sends first
+func receivedBeforeSending(chor *Choreography, participantID string) string {
+	visited := make(map[string]bool)
+	var walk func(elementID string) string
+	walk = func(elementID string) string {
+		if visited[elementID] {
+			return ""
+		}
+		visited[elementID] = true
+		el := chor.Elements[elementID]
+		for _, messageID := range el.Messages {
+			def := chor.Messages[messageID]
+			if def.SendParticipant == participantID {
+				return ""
+			}
+			if def.ReceiveParticipant == participantID {
+				return messageID
+			}
+		}
+		for _, flowID := range el.Outgoing {
+			if messageID := walk(chor.Flows[flowID].TargetRef); messageID != "" {
+				return messageID
+			}
+		}
+		return ""
+	}
+
+	for _, id := range sortedElementIDs(chor) {
+		if chor.Elements[id].Type != StartEventElement {
+			continue
+		}
+		if messageID := walk(id); messageID != "" {
+			return messageID
+		}
+	}
+	return ""
+}
+
+// resolveParticipant maps a participant ID or name to the participant ID
+func resolveParticipant(chor *Choreography, ref string) (string, error) {
+	if _, ok := chor.Participants[ref]; ok {
+		return ref, nil
+	}
+	for id, p := range chor.Participants {
+		if p.Name == ref {
+			return id, nil
+		}
+	}
+	return "", fmt.Errorf("Participant %s is not part of choreography %s", ref, chor.ChoreographyID)
+}
+
+func (cc *SmartContract) putParticipant(ctx contractapi.TransactionContextInterface, binding *ParticipantBinding) error {
+	key, err := ctx.GetStub().CreateCompositeKey(participantObjectType, []string{binding.InstanceID, binding.ParticipantID})
+	if err != nil {
+		return err
+	}
+	bindingJSON, err := json.Marshal(binding)
+	if err != nil {
+		return fmt.Errorf("序列化参与方数据时出错: %v", err)
+	}
+	if err := ctx.GetStub().PutState(key, bindingJSON); err != nil {
+		return fmt.Errorf("保存参与方数据时出错: %v", err)
+	}
+	return nil
+}
+
+// ReadParticipant returns the registry entry of a participant of an instance
+func (cc *SmartContract) ReadParticipant(ctx contractapi.TransactionContextInterface, instanceID string, participantID string) (*ParticipantBinding, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(participantObjectType, []string{instanceID, participantID})
+	if err != nil {
+		return nil, err
+	}
+	bindingJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("获取状态数据时出错: %v", err)
+	}
+	if bindingJSON == nil {
+		errorMessage := fmt.Sprintf("Participant %s is not bound to an MSP", participantID)
+		fmt.Println(errorMessage)
+		return nil, errors.New(errorMessage)
+	}
+
+	var binding ParticipantBinding
+	if err := json.Unmarshal(bindingJSON, &binding); err != nil {
+		return nil, err
+	}
+	return &binding, nil
+}
+
+// GetParticipants returns the participant registry of an instance
+func (cc *SmartContract) GetParticipants(ctx contractapi.TransactionContextInterface, instanceID string) ([]*ParticipantBinding, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(participantObjectType, []string{instanceID})
+	if err != nil {
+		return nil, fmt.Errorf("获取状态数据时出错: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	var bindings []*ParticipantBinding
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("迭代状态数据时出错: %v", err)
+		}
+
+		var binding ParticipantBinding
+		if err := json.Unmarshal(queryResponse.Value, &binding); err != nil {
+			return nil, fmt.Errorf("反序列化参与方数据时出错: %v", err)
+		}
+		bindings = append(bindings, &binding)
+	}
+	return bindings, nil
+}
+
+// authorizeParticipant checks that the caller plays the given participant of an instance.
+// The registry is read on every check, messages only keep a copy of the MSP IDs for queries.
+// A denial names the requirement of the binding that the caller does not meet.
+func (cc *SmartContract) authorizeParticipant(ctx contractapi.TransactionContextInterface, instanceID string, participantID string) error {
+	binding, err := cc.ReadParticipant(ctx, instanceID, participantID)
+	if err != nil {
+		return err
+	}
+	if binding.MspID == "" {
+		return denied("Participant %s is not bound yet", participantID)
+	}
+	identity := ctx.GetClientIdentity()
+
+	clientMspID, err := identity.GetMSPID()
+	if err != nil {
+		return err
+	}
+	if clientMspID != binding.MspID {
+		return denied("Msp denied: participant %s is bound to %s, not %s", participantID, binding.MspID, clientMspID)
+	}
+
+	// 按属性名排序检查，各节点给出相同的错误
+	names := make([]string, 0, len(binding.Attributes))
+	for name := range binding.Attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value, found, err := identity.GetAttributeValue(name)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return denied("Attribute denied: participant %s requires attribute %s=%s, the certificate has none", participantID, name, binding.Attributes[name])
+		}
+		if value != binding.Attributes[name] {
+			return denied("Attribute denied: participant %s requires attribute %s=%s, not %s", participantID, name, binding.Attributes[name], value)
+		}
+	}
+
+	if len(binding.Subjects) > 0 {
+		cert, err := identity.GetX509Certificate()
+		if err != nil {
+			return err
+		}
+		if cert == nil || !contains(binding.Subjects, cert.Subject.String()) {
+			subject := ""
+			if cert != nil {
+				subject = cert.Subject.String()
+			}
+			return denied("Certificate denied: subject %q may not act for participant %s", subject, participantID)
+		}
+	}
+
+	if len(binding.ClientIDs) > 0 {
+		clientID, err := identity.GetID()
+		if err != nil {
+			return err
+		}
+		if !contains(binding.ClientIDs, clientID) {
+			return denied("Identity denied: client %s may not act for participant %s", clientID, participantID)
+		}
+	}
+	return nil
+}
+
+// BindParticipant binds a late-bound participant of an instance, by ID or name, to the organization
+// playing it in this run. The caller has to play the participant named in its BindBy. participantBinding
+// is a plain MSP ID or a binding object as accepted by CreateInstance, e.g. {"mspID":"Org3MSP","role":"hotel"}.
+// The user requirements set at CreateInstance are kept, the binder may only add to them.
+func (cc *SmartContract) BindParticipant(ctx contractapi.TransactionContextInterface, instanceID string, participantRef string, participantBinding string) error {
+	_, chor, err := cc.runningInstance(ctx, instanceID)
+	if err != nil {
+		return err
+	}
+	participantID, err := resolveParticipant(chor, participantRef)
+	if err != nil {
+		return err
+	}
+	binding, err := cc.ReadParticipant(ctx, instanceID, participantID)
+	if err != nil {
+		return err
+	}
+	if binding.MspID != "" {
+		return denied("Participant %s is already bound to %s", participantID, binding.MspID)
+	}
+	if binding.BindBy == SelfBinding {
+		return denied("Participant %s is bound by the first message it sends", participantID)
+	}
+	if err := cc.authorizeParticipant(ctx, instanceID, binding.BindBy); err != nil {
+		return err
+	}
+
+	spec := participantSpec{MspID: participantBinding}
+	if strings.HasPrefix(strings.TrimSpace(participantBinding), "{") {
+		if err := json.Unmarshal([]byte(participantBinding), &spec); err != nil {
+			return fmt.Errorf("participant binding is not a JSON object: %v", err)
+		}
+	}
+	if spec.MspID == "" {
+		return fmt.Errorf("Participant %s is not bound to an MSP", participantID)
+	}
+	if len(binding.Candidates) > 0 && !contains(binding.Candidates, spec.MspID) {
+		return denied("Msp denied: participant %s may be bound to %s, not %s", participantID, strings.Join(binding.Candidates, ", "), spec.MspID)
+	}
+	binding.MspID = spec.MspID
+	if spec.Role != "" {
+		binding.Role = spec.Role
+	}
+	if err := mergeRequirements(binding, spec); err != nil {
+		return err
+	}
+	if err := cc.bindParticipant(ctx, chor, binding); err != nil {
+		return err
+	}
+
+	bindingJSON, err := json.Marshal(binding)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().SetEvent("bindParticipantEvent", bindingJSON)
+}
+
+// mergeRequirements adds the user requirements passed to BindParticipant to those set at
+// CreateInstance. The binder may narrow down who acts for the participant, never widen it.
+func mergeRequirements(binding *ParticipantBinding, spec participantSpec) error {
+	names := make([]string, 0, len(spec.Attributes))
+	for name := range spec.Attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := spec.Attributes[name]
+		if required, ok := binding.Attributes[name]; ok && required != value {
+			return denied("Attribute denied: participant %s requires attribute %s=%s, not %s", binding.ParticipantID, name, required, value)
+		}
+		if binding.Attributes == nil {
+			binding.Attributes = make(map[string]string)
+		}
+		binding.Attributes[name] = value
+	}
+
+	var err error
+	if binding.Subjects, err = narrowList(binding.ParticipantID, "subject", binding.Subjects, spec.Subjects); err != nil {
+		return err
+	}
+	binding.ClientIDs, err = narrowList(binding.ParticipantID, "client", binding.ClientIDs, spec.ClientIDs)
+	return err
+}
+
+// narrowList returns the subjects or client IDs allowed after a late binding: those given
+// by the binder, which have to be among the ones already allowed, if any
+func narrowList(participantID string, kind string, allowed []string, given []string) ([]string, error) {
+	if len(given) == 0 {
+		return allowed, nil
+	}
+	if len(allowed) == 0 {
+		return given, nil
+	}
+	for _, value := range given {
+		if !contains(allowed, value) {
+			return nil, denied("Identity denied: %s %s may not act for participant %s", kind, value, participantID)
+		}
+	}
+	return given, nil
+}
+
+// bindOnSend binds a participant declared with SelfBinding to the caller's MSP
+// when it sends its first message. Bound participants are left as they are.
+func (cc *SmartContract) bindOnSend(ctx contractapi.TransactionContextInterface, chor *Choreography, instanceID string, participantID string) error {
+	binding, err := cc.ReadParticipant(ctx, instanceID, participantID)
+	if err != nil {
+		return err
+	}
+	if binding.MspID != "" || binding.BindBy != SelfBinding {
+		return nil
+	}
+	clientMspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return err
+	}
+	if len(binding.Candidates) > 0 && !contains(binding.Candidates, clientMspID) {
+		return denied("Msp denied: participant %s may be bound to %s, not %s", participantID, strings.Join(binding.Candidates, ", "), clientMspID)
+	}
+	binding.MspID = clientMspID
+	return cc.bindParticipant(ctx, chor, binding)
+}
+
+// bindParticipant stores a new binding and fills in the MSP copies of the messages of the
+// participant, adding the work items that could not be assigned while it was unbound
+func (cc *SmartContract) bindParticipant(ctx contractapi.TransactionContextInterface, chor *Choreography, binding *ParticipantBinding) error {
+	if err := cc.putParticipant(ctx, binding); err != nil {
+		return err
+	}
+
+	for _, messageID := range sortedMessageIDs(chor) {
+		def := chor.Messages[messageID]
+		if def.SendParticipant != binding.ParticipantID && def.ReceiveParticipant != binding.ParticipantID {
+			continue
+		}
+		msg, err := cc.ReadMsg(ctx, binding.InstanceID, messageID)
+		if err != nil {
+			return err
+		}
+		if def.SendParticipant == binding.ParticipantID {
+			// 自绑定前待办事项给了所有候选组织，现在只留给绑定的组织
+			if binding.BindBy == SelfBinding && msg.MsgState == ENABLE {
+				if err := cc.deleteCandidateItems(ctx, binding, messageID); err != nil {
+					return err
+				}
+			}
+			msg.SendMspID = binding.MspID
+		}
+		if def.ReceiveParticipant == binding.ParticipantID {
+			msg.ReceiveMspID = binding.MspID
+		}
+		if err := putElement(ctx, binding.InstanceID, messageID, msg); err != nil {
+			return err
+		}
+		if err := cc.putWorkItem(ctx, binding.InstanceID, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteCandidateItems removes the work items offered to the candidates of a participant
+// that bound itself
+func (cc *SmartContract) deleteCandidateItems(ctx contractapi.TransactionContextInterface, binding *ParticipantBinding, messageID string) error {
+	for _, mspID := range binding.Candidates {
+		key, err := ctx.GetStub().CreateCompositeKey(worklistObjectType, []string{mspID, binding.InstanceID, messageID})
+		if err != nil {
+			return err
+		}
+		if err := ctx.GetStub().DelState(key); err != nil {
+			return fmt.Errorf("删除待办事项时出错: %v", err)
+		}
+	}
+	return nil
+}
+
+func denied(format string, args ...interface{}) error {
+	errorMessage := fmt.Sprintf(format, args...)
+	fmt.Println(errorMessage)
+	return errors.New(errorMessage)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
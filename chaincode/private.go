@@ -0,0 +1,104 @@
+package chaincode
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// PayloadTransientKey is the transient map entry SendMessage reads a private payload from
+const PayloadTransientKey = "payload"
+
+var collectionNameInvalid = regexp.MustCompile(`[^A-Za-z0-9-]`)
+
+// PairCollection names the private data collection shared by two organizations.
+// The name does not depend on who sends, so both directions of a conversation use it.
+func PairCollection(mspA string, mspB string) string {
+	pair := []string{
+		collectionNameInvalid.ReplaceAllString(mspA, ""),
+		collectionNameInvalid.ReplaceAllString(mspB, ""),
+	}
+	sort.Strings(pair)
+	return fmt.Sprintf("pair-%s-%s", pair[0], pair[1])
+}
+
+// transientPayload returns the payload passed in the transient map, if any
+func transientPayload(ctx contractapi.TransactionContextInterface) ([]byte, bool, error) {
+	transient, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return nil, false, fmt.Errorf("获取临时数据时出错: %v", err)
+	}
+	payload, ok := transient[PayloadTransientKey]
+	return payload, ok, nil
+}
+
+// putPrivatePayload keeps a payload in the collection of sender and receiver. Only the
+// hash of the write reaches the world state of other organizations.
+func (cc *SmartContract) putPrivatePayload(ctx contractapi.TransactionContextInterface, instanceID string, msg *Message, payload []byte) error {
+	key, err := elementKey(ctx, instanceID, msg.MessageID)
+	if err != nil {
+		return err
+	}
+	if err := ctx.GetStub().PutPrivateData(msg.PayloadCollection, key, payload); err != nil {
+		return fmt.Errorf("保存私有数据时出错: %v", err)
+	}
+	return nil
+}
+
+// deletePrivatePayload removes the payload of a withdrawn send from the collection
+func (cc *SmartContract) deletePrivatePayload(ctx contractapi.TransactionContextInterface, instanceID string, msg *Message) error {
+	key, err := elementKey(ctx, instanceID, msg.MessageID)
+	if err != nil {
+		return err
+	}
+	if err := ctx.GetStub().DelPrivateData(msg.PayloadCollection, key); err != nil {
+		return fmt.Errorf("删除私有数据时出错: %v", err)
+	}
+	return nil
+}
+
+// ReadMessagePayload returns the private payload of a message. Only its sender and receiver
+// may read it, and only on their own peers, which are members of the collection.
+func (cc *SmartContract) ReadMessagePayload(ctx contractapi.TransactionContextInterface, instanceID string, messageID string) (string, error) {
+	_, chor, err := cc.readInstance(ctx, instanceID)
+	if err != nil {
+		return "", err
+	}
+	def, ok := chor.Messages[messageID]
+	if !ok {
+		return "", fmt.Errorf("Message %s is not part of choreography %s", messageID, chor.ChoreographyID)
+	}
+	msg, err := cc.ReadMsg(ctx, instanceID, messageID)
+	if err != nil {
+		return "", err
+	}
+
+	if err := cc.authorizeParticipant(ctx, instanceID, def.SendParticipant); err != nil {
+		if err := cc.authorizeParticipant(ctx, instanceID, def.ReceiveParticipant); err != nil {
+			return "", err
+		}
+	}
+
+	if msg.PayloadCollection == "" {
+		errorMessage := fmt.Sprintf("Message %s has no private payload", messageID)
+		fmt.Println(errorMessage)
+		return "", errors.New(errorMessage)
+	}
+	key, err := elementKey(ctx, instanceID, messageID)
+	if err != nil {
+		return "", err
+	}
+	payload, err := ctx.GetStub().GetPrivateData(msg.PayloadCollection, key)
+	if err != nil {
+		return "", fmt.Errorf("获取私有数据时出错: %v", err)
+	}
+	if payload == nil {
+		errorMessage := fmt.Sprintf("Private payload of message %s is not available", messageID)
+		fmt.Println(errorMessage)
+		return "", errors.New(errorMessage)
+	}
+	return string(payload), nil
+}
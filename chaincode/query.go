@@ -0,0 +1,91 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// MessageFilter selects messages in QueryMessages. Empty fields match everything.
+type MessageFilter struct {
+	InstanceID   string        `json:"instanceID,omitempty"`
+	MsgState     *ElementState `json:"msgState,omitempty"`
+	SendMspID    string        `json:"sendMspID,omitempty"`
+	ReceiveMspID string        `json:"receiveMspID,omitempty"`
+	Format       string        `json:"format,omitempty"`
+}
+
+// MessageQueryResult is one page of QueryMessages
+type MessageQueryResult struct {
+	Messages            []*Message `json:"messages"`
+	Bookmark            string     `json:"bookmark"`
+	FetchedRecordsCount int32      `json:"fetchedRecordsCount"`
+}
+
+// QueryMessages returns one page of the messages matching filterJSON, e.g.
+// {"instanceID":"...","msgState":1,"receiveMspID":"Org2MSP"}.
+// Pass the returned bookmark to fetch the next page. Requires CouchDB as state database.
+func (cc *SmartContract) QueryMessages(ctx contractapi.TransactionContextInterface, filterJSON string, pageSize int32, bookmark string) (*MessageQueryResult, error) {
+	if pageSize <= 0 {
+		errorMessage := fmt.Sprintf("Page size %d must be positive", pageSize)
+		fmt.Println(errorMessage)
+		return nil, errors.New(errorMessage)
+	}
+
+	var filter MessageFilter
+	if filterJSON != "" {
+		if err := json.Unmarshal([]byte(filterJSON), &filter); err != nil {
+			return nil, fmt.Errorf("filter is not a JSON object: %v", err)
+		}
+	}
+
+	// json.Marshal 对 map 的键排序，查询语句在各节点一致
+	selector := map[string]interface{}{"docType": MessageDocType}
+	if filter.InstanceID != "" {
+		selector["instanceID"] = filter.InstanceID
+	}
+	if filter.MsgState != nil {
+		selector["msgState"] = *filter.MsgState
+	}
+	if filter.SendMspID != "" {
+		selector["sendMspID"] = filter.SendMspID
+	}
+	if filter.ReceiveMspID != "" {
+		selector["receiveMspID"] = filter.ReceiveMspID
+	}
+	if filter.Format != "" {
+		selector["format"] = filter.Format
+	}
+	queryString, err := json.Marshal(map[string]interface{}{"selector": selector})
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, responseMetadata, err := ctx.GetStub().GetQueryResultWithPagination(string(queryString), pageSize, bookmark)
+	if err != nil {
+		return nil, fmt.Errorf("获取状态数据时出错: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	result := &MessageQueryResult{
+		Messages:            []*Message{},
+		Bookmark:            responseMetadata.GetBookmark(),
+		FetchedRecordsCount: responseMetadata.GetFetchedRecordsCount(),
+	}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("迭代状态数据时出错: %v", err)
+		}
+
+		var message Message
+		if err := unmarshalNumbers(queryResponse.Value, &message); err != nil {
+			return nil, fmt.Errorf("反序列化消息数据时出错: %v", err)
+		}
+		result.Messages = append(result.Messages, &message)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,56 @@
+package chaincode_test
+
+import (
+	"chaincode-go-bpmn/chaincode"
+	"chaincode-go-bpmn/chaincode/mocks"
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestQueryMessages(t *testing.T) {
+	asset := &chaincode.Message{DocType: chaincode.MessageDocType, InstanceID: "instance1", MessageID: "asset1", MsgState: chaincode.ENABLE}
+	bytes, err := json.Marshal(asset)
+	require.NoError(t, err)
+
+	iterator := &mocks.StateQueryIterator{}
+	iterator.HasNextReturnsOnCall(0, true)
+	iterator.HasNextReturnsOnCall(1, false)
+	iterator.NextReturns(&queryresult.KV{Value: bytes}, nil)
+
+	chaincodeStub := &mocks.ChaincodeStub{}
+	transactionContext := &mocks.TransactionContext{}
+	transactionContext.GetStubReturns(chaincodeStub)
+	chaincodeStub.GetQueryResultWithPaginationReturns(iterator, &peer.QueryResponseMetadata{FetchedRecordsCount: 1, Bookmark: "next"}, nil)
+
+	messageTransfer := &chaincode.SmartContract{}
+	result, err := messageTransfer.QueryMessages(transactionContext, `{"instanceID":"instance1","msgState":1,"receiveMspID":"HotelMSP"}`, 10, "")
+	require.NoError(t, err)
+	require.Equal(t, []*chaincode.Message{asset}, result.Messages)
+	require.Equal(t, "next", result.Bookmark)
+	require.Equal(t, int32(1), result.FetchedRecordsCount)
+
+	query, pageSize, bookmark := chaincodeStub.GetQueryResultWithPaginationArgsForCall(0)
+	require.JSONEq(t, `{"selector":{"docType":"message","instanceID":"instance1","msgState":1,"receiveMspID":"HotelMSP"}}`, query)
+	require.Equal(t, int32(10), pageSize)
+	require.Equal(t, "", bookmark)
+
+	_, err = messageTransfer.QueryMessages(transactionContext, "", 10, "next")
+	require.NoError(t, err)
+	query, _, bookmark = chaincodeStub.GetQueryResultWithPaginationArgsForCall(1)
+	require.JSONEq(t, `{"selector":{"docType":"message"}}`, query)
+	require.Equal(t, "next", bookmark)
+
+	_, err = messageTransfer.QueryMessages(transactionContext, "", 0, "")
+	require.EqualError(t, err, "Page size 0 must be positive")
+
+	_, err = messageTransfer.QueryMessages(transactionContext, "[]", 10, "")
+	require.ErrorContains(t, err, "filter is not a JSON object")
+
+	chaincodeStub.GetQueryResultWithPaginationReturns(nil, nil, fmt.Errorf("rich queries are not supported"))
+	_, err = messageTransfer.QueryMessages(transactionContext, "", 10, "")
+	require.EqualError(t, err, "获取状态数据时出错: rich queries are not supported")
+}
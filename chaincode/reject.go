@@ -0,0 +1,145 @@
+package chaincode
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// RejectMessage is called by the receiver instead of ConfirmMessage when the message that
+// arrived through FireFly is not acceptable. The attempt is kept in the message history
+// together with reasonCode and reason, and the message is enabled again for the sender
+// to resend. A private payload is removed from the collection. Once a message was rejected more often than its policy allows
+// (MessageDefinition.MaxRejections), the instance fails instead.
+func (cc *SmartContract) RejectMessage(ctx contractapi.TransactionContextInterface, instanceID string, messageID string, reasonCode string, reason string) error {
+	stub := ctx.GetStub()
+	instance, chor, err := cc.runningInstance(ctx, instanceID)
+	if err != nil {
+		return err
+	}
+	def, ok := chor.Messages[messageID]
+	if !ok {
+		return fmt.Errorf("Message %s is not part of choreography %s", messageID, chor.ChoreographyID)
+	}
+	msg, err := cc.ReadMsg(ctx, instanceID, messageID)
+	if err != nil {
+		return err
+	}
+
+	if msg.MsgState != WAITFORCONFIRM {
+		errorMessage := fmt.Sprintf("Msg state %s is not allowed", msg.MessageID)
+		fmt.Println(errorMessage)
+		return errors.New(errorMessage)
+	}
+
+	if err := cc.authorizeParticipant(ctx, instanceID, def.ReceiveParticipant); err != nil {
+		return err
+	}
+
+	if reasonCode == "" {
+		errorMessage := fmt.Sprintf("Rejection of message %s needs a reason code", messageID)
+		fmt.Println(errorMessage)
+		return errors.New(errorMessage)
+	}
+
+	if err := cc.withdrawAttempt(ctx, instanceID, msg, AttemptRejected, reasonCode, reason); err != nil {
+		return err
+	}
+
+	rejections := 0
+	for _, attempt := range msg.History {
+		if attempt.Outcome == AttemptRejected {
+			rejections++
+		}
+	}
+	if rejections > def.MaxRejections {
+		if err := cc.failInstance(ctx, instance, chor); err != nil {
+			return err
+		}
+		return stub.SetEvent("instanceFailedEvent", []byte(fmt.Sprintf("%s has been rejected %d times, instance %s failed", messageID, rejections, instanceID)))
+	}
+
+	return stub.SetEvent(messageID, []byte(fmt.Sprintf("%s has been rejected: %s", messageID, reasonCode)))
+}
+
+// RetractMessage lets the sender withdraw a message that has not been confirmed yet, e.g. to
+// correct a wrong FireFly transaction ID or payload. The attempt is kept in the message history
+// and the message is enabled again. A private payload is removed from the collection.
+func (cc *SmartContract) RetractMessage(ctx contractapi.TransactionContextInterface, instanceID string, messageID string, reason string) error {
+	_, chor, err := cc.runningInstance(ctx, instanceID)
+	if err != nil {
+		return err
+	}
+	def, ok := chor.Messages[messageID]
+	if !ok {
+		return fmt.Errorf("Message %s is not part of choreography %s", messageID, chor.ChoreographyID)
+	}
+	msg, err := cc.ReadMsg(ctx, instanceID, messageID)
+	if err != nil {
+		return err
+	}
+
+	if msg.MsgState != WAITFORCONFIRM {
+		errorMessage := fmt.Sprintf("Msg state %s is not allowed", msg.MessageID)
+		fmt.Println(errorMessage)
+		return errors.New(errorMessage)
+	}
+
+	if err := cc.authorizeParticipant(ctx, instanceID, def.SendParticipant); err != nil {
+		return err
+	}
+
+	if err := cc.withdrawAttempt(ctx, instanceID, msg, AttemptRetracted, "", reason); err != nil {
+		return err
+	}
+
+	return ctx.GetStub().SetEvent(messageID, []byte(fmt.Sprintf("%s has been retracted", messageID)))
+}
+
+// withdrawAttempt moves the current send of a message into its history and enables it again.
+// A private payload is removed from the collection, nothing would point to it any more.
+func (cc *SmartContract) withdrawAttempt(ctx contractapi.TransactionContextInterface, instanceID string, msg *Message, outcome string, reasonCode string, reason string) error {
+	if msg.PayloadCollection != "" {
+		if err := cc.deletePrivatePayload(ctx, instanceID, msg); err != nil {
+			return err
+		}
+	}
+	msg.History = append(msg.History, MessageAttempt{
+		Outcome:       outcome,
+		FireflyTranID: msg.FireflyTranID,
+		PayloadHash:   msg.PayloadHash,
+		ReasonCode:    reasonCode,
+		Reason:        reason,
+		TxID:          ctx.GetStub().GetTxID(),
+	})
+	msg.MsgState = ENABLE
+	msg.FireflyTranID = ""
+	msg.PayloadHash = ""
+	msg.PayloadCollection = ""
+	msg.Variables = nil
+	if err := putElement(ctx, instanceID, msg.MessageID, msg); err != nil {
+		return err
+	}
+	return cc.updateWorklist(ctx, instanceID, msg, WAITFORCONFIRM)
+}
+
+// failInstance stops an instance: messages still waiting to be sent or confirmed are
+// disabled and leave the worklists, and no further transaction may advance it
+func (cc *SmartContract) failInstance(ctx contractapi.TransactionContextInterface, instance *Instance, chor *Choreography) error {
+	for _, messageID := range sortedMessageIDs(chor) {
+		msg, err := cc.ReadMsg(ctx, instance.InstanceID, messageID)
+		if err != nil {
+			return err
+		}
+		if msg.MsgState == ENABLE || msg.MsgState == WAITFORCONFIRM {
+			if err := cc.changeMsgState(ctx, instance.InstanceID, messageID, DISABLE); err != nil {
+				return err
+			}
+		}
+	}
+
+	failed := *instance
+	failed.Status = InstanceFailed
+	return cc.putInstance(ctx, &failed)
+}
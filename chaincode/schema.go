@@ -0,0 +1,98 @@
+package chaincode
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// SchemaTextFormat marks the documentation of a BPMN message that holds its JSON Schema
+const SchemaTextFormat = "application/schema+json"
+
+// SchemaFormat validates payloads against a JSON Schema (draft 7), for messages
+// carrying nested documents that the field list can not describe.
+type SchemaFormat struct {
+	source string
+	schema *gojsonschema.Schema
+}
+
+// IsSchemaFormat tells a JSON Schema apart from a field list
+func IsSchemaFormat(format string) bool {
+	return strings.HasPrefix(strings.TrimSpace(format), "{")
+}
+
+// ParseSchemaFormat compiles a JSON Schema. Every peer has to reach the same result
+// without network access, so only references inside the schema ("#/definitions/...") are allowed.
+func ParseSchemaFormat(format string) (*SchemaFormat, error) {
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, []byte(format)); err != nil {
+		return nil, fmt.Errorf("schema is not valid JSON: %v", err)
+	}
+	var document interface{}
+	if err := json.Unmarshal(compact.Bytes(), &document); err != nil {
+		return nil, fmt.Errorf("schema is not valid JSON: %v", err)
+	}
+	if err := checkSchemaRefs(document); err != nil {
+		return nil, err
+	}
+
+	loader := gojsonschema.NewSchemaLoader()
+	loader.Draft = gojsonschema.Draft7
+	loader.AutoDetect = false
+	loader.Validate = true
+	schema, err := loader.Compile(gojsonschema.NewBytesLoader(compact.Bytes()))
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema: %v", err)
+	}
+	return &SchemaFormat{source: compact.String(), schema: schema}, nil
+}
+
+func checkSchemaRefs(node interface{}) error {
+	switch v := node.(type) {
+	case map[string]interface{}:
+		if ref, ok := v["$ref"].(string); ok && !strings.HasPrefix(ref, "#") {
+			return fmt.Errorf("schema reference %s is not allowed, only local references are supported", ref)
+		}
+		for _, child := range v {
+			if err := checkSchemaRefs(child); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, child := range v {
+			if err := checkSchemaRefs(child); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ParsePayload decodes a JSON payload and validates it against the schema
+func (f *SchemaFormat) ParsePayload(payloadJSON string) (map[string]interface{}, error) {
+	payload, err := decodePayload(payloadJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := f.schema.Validate(gojsonschema.NewGoLoader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("payload could not be validated: %v", err)
+	}
+	if !result.Valid() {
+		var problems []string
+		for _, resultError := range result.Errors() {
+			problems = append(problems, resultError.String())
+		}
+		return nil, fmt.Errorf("payload does not match the schema: %s", strings.Join(problems, "; "))
+	}
+	return payload, nil
+}
+
+// String returns the compacted schema, as stored in Message.Format
+func (f *SchemaFormat) String() string {
+	return f.source
+}
@@ -1,6 +1,7 @@
 package chaincode
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,7 +11,17 @@ import (
 // SmartContract provides functions for managing an Asset
 type SmartContract struct {
 	contractapi.Contract
-	currentMemory StateMemory
+}
+
+// NewSmartContract returns the contract with a read-your-own-writes state cache
+// installed around every transaction
+func NewSmartContract() *SmartContract {
+	return &SmartContract{
+		Contract: contractapi.Contract{
+			TransactionContextHandler: new(TransactionContext),
+			AfterTransaction:          flushStateCache,
+		},
+	}
 }
 
 // Asset describes basic details of what makes up a simple asset
@@ -23,35 +34,73 @@ const (
 	ENABLE
 	WAITFORCONFIRM
 	DONE
+	CANCELLED // the instance was cancelled before the element completed
+)
+
+// docType of every record stored under an instance, so listings can tell them apart
+const (
+	MessageDocType = "message"
+	GatewayDocType = "gateway"
+	EventDocType   = "event"
+
+	ParticipantDocType = "participant"
 )
 
 type Message struct {
-	MessageID     string       `json:"messageID"`
-	SendMspID     string       `json:"sendMspID"`
-	ReceiveMspID  string       `json:"receiveMspID"`
-	FireflyTranID string       `json:"fireflyTranID"`
-	MsgState      ElementState `json:"msgState"`
-	Format        string       `json:"format"` //存下（string name， boolean confirm， int id）
+	DocType           string           `json:"docType"`
+	InstanceID        string           `json:"instanceID"`
+	MessageID         string           `json:"messageID"`
+	SendMspID         string           `json:"sendMspID"`
+	ReceiveMspID      string           `json:"receiveMspID"`
+	FireflyTranID     string           `json:"fireflyTranID"`
+	PayloadHash       string           `json:"payloadHash,omitempty"`       // SHA-256 of the payload, set by the sender
+	PayloadCollection string           `json:"payloadCollection,omitempty"` // private data collection of a transient payload
+	MsgState          ElementState     `json:"msgState"`
+	Format            string           `json:"format"`              //存下（string name， boolean confirm， int id）
+	History           []MessageAttempt `json:"history,omitempty"`   // sends that were not confirmed
+	Variables         StateMemory      `json:"variables,omitempty"` // process variables the send writes once it is confirmed
+}
+
+// MessageAttempt is a send of a message that the receiver rejected or the sender retracted
+type MessageAttempt struct {
+	Outcome       string `json:"outcome"` // AttemptRejected or AttemptRetracted
+	FireflyTranID string `json:"fireflyTranID"`
+	PayloadHash   string `json:"payloadHash,omitempty"`
+	ReasonCode    string `json:"reasonCode,omitempty"`
+	Reason        string `json:"reason,omitempty"`
+	TxID          string `json:"txID"` // transaction that ended the attempt
 }
 
+const (
+	AttemptRejected  = "rejected"
+	AttemptRetracted = "retracted"
+)
+
 type Gateway struct {
+	DocType      string       `json:"docType"`
+	InstanceID   string       `json:"instanceID"`
 	GatewayID    string       `json:"gatewayID"`
 	GatewayState ElementState `json:"gatewayState"`
+	Arrived      []string     `json:"arrived,omitempty"`  // incoming flows a join has received the token on
+	Expected     int          `json:"expected,omitempty"` // branches an inclusive join waits for
+	Chosen       string       `json:"chosen,omitempty"`   // outgoing flow an event-based gateway took
 }
 
 type ActionEvent struct {
+	DocType    string       `json:"docType"`
+	InstanceID string       `json:"instanceID"`
 	EventID    string       `json:"eventID"`
 	EventState ElementState `json:"eventState"`
 }
 
-type StateMemory struct { // 这里字段需要小写
-	Confirm bool `json:"confirm"`
-	Cancel  bool `json:"cancel"`
-}
+// StateMemory holds the process variables of an instance that gateway conditions
+// are evaluated against. It is persisted in the world state, never kept in the peer.
+type StateMemory map[string]interface{}
 
 // Construct
 func NewMessage(messageID, sendMspID, receiveMspID, fireflyTranID string, msgState ElementState) *Message {
 	return &Message{
+		DocType:       MessageDocType,
 		MessageID:     messageID,
 		SendMspID:     sendMspID,
 		ReceiveMspID:  receiveMspID,
@@ -62,24 +111,22 @@ func NewMessage(messageID, sendMspID, receiveMspID, fireflyTranID string, msgSta
 
 func NewGateway(gatewayID string, gatewayState ElementState) *Gateway { //返回实际值是新建一个对象副本
 	return &Gateway{
+		DocType:      GatewayDocType,
 		GatewayID:    gatewayID,
 		GatewayState: gatewayState,
 	}
 }
 
-func NewStateMemory(confirm, cancel bool) *StateMemory {
-	return &StateMemory{
-		Confirm: confirm,
-		Cancel:  cancel,
-	}
-}
-
-// Create function
-func (cc *SmartContract) CreateMessage(ctx contractapi.TransactionContextInterface, messageID string, sendMspID string, receiveMspID string, fireflyTranID string, msgState ElementState, format string) (*Message, error) {
+// Create function, unexported so that only the engine creates elements, never a transaction
+func (cc *SmartContract) createMessage(ctx contractapi.TransactionContextInterface, instanceID string, messageID string, sendMspID string, receiveMspID string, fireflyTranID string, msgState ElementState, format string) (*Message, error) {
 	stub := ctx.GetStub()
+	key, err := elementKey(ctx, instanceID, messageID)
+	if err != nil {
+		return nil, err
+	}
 
 	// 检查是否存在具有相同ID的记录
-	existingData, err := stub.GetState(messageID)
+	existingData, err := stub.GetState(key)
 	if err != nil {
 		return nil, fmt.Errorf("获取状态数据时出错: %v", err)
 	}
@@ -89,6 +136,8 @@ func (cc *SmartContract) CreateMessage(ctx contractapi.TransactionContextInterfa
 
 	// 创建消息对象
 	msg := &Message{
+		DocType:       MessageDocType,
+		InstanceID:    instanceID,
 		MessageID:     messageID,
 		SendMspID:     sendMspID,
 		ReceiveMspID:  receiveMspID,
@@ -102,19 +151,26 @@ func (cc *SmartContract) CreateMessage(ctx contractapi.TransactionContextInterfa
 	if err != nil {
 		return nil, fmt.Errorf("序列化消息数据时出错: %v", err)
 	}
-	err = stub.PutState(messageID, msgJSON)
+	err = stub.PutState(key, msgJSON)
 	if err != nil {
 		return nil, fmt.Errorf("保存消息数据时出错: %v", err)
 	}
+	if err := cc.updateWorklist(ctx, instanceID, msg, DISABLE); err != nil {
+		return nil, err
+	}
 
 	return msg, nil
 }
 
-func (cc *SmartContract) CreateGateway(ctx contractapi.TransactionContextInterface, gatewayID string, gatewayState ElementState) (*Gateway, error) {
+func (cc *SmartContract) createGateway(ctx contractapi.TransactionContextInterface, instanceID string, gatewayID string, gatewayState ElementState) (*Gateway, error) {
 	stub := ctx.GetStub()
+	key, err := elementKey(ctx, instanceID, gatewayID)
+	if err != nil {
+		return nil, err
+	}
 
 	// 检查是否存在具有相同ID的记录
-	existingData, err := stub.GetState(gatewayID)
+	existingData, err := stub.GetState(key)
 	if err != nil {
 		return nil, fmt.Errorf("获取状态数据时出错: %v", err)
 	}
@@ -124,6 +180,8 @@ func (cc *SmartContract) CreateGateway(ctx contractapi.TransactionContextInterfa
 
 	// 创建网关对象
 	gtw := &Gateway{
+		DocType:      GatewayDocType,
+		InstanceID:   instanceID,
 		GatewayID:    gatewayID,
 		GatewayState: gatewayState,
 	}
@@ -133,7 +191,7 @@ func (cc *SmartContract) CreateGateway(ctx contractapi.TransactionContextInterfa
 	if err != nil {
 		return nil, fmt.Errorf("序列化网关数据时出错: %v", err)
 	}
-	err = stub.PutState(gatewayID, gtwJSON)
+	err = stub.PutState(key, gtwJSON)
 	if err != nil {
 		return nil, fmt.Errorf("保存网关数据时出错: %v", err)
 	}
@@ -141,11 +199,17 @@ func (cc *SmartContract) CreateGateway(ctx contractapi.TransactionContextInterfa
 	return gtw, nil
 }
 
-func (cc *SmartContract) CreateActionEvent(ctx contractapi.TransactionContextInterface, eventID string, eventState ElementState) (*ActionEvent, error) {
+func (cc *SmartContract) createActionEvent(ctx contractapi.TransactionContextInterface, instanceID string, eventID string, eventState ElementState) (*ActionEvent, error) {
 	stub := ctx.GetStub()
+	key, err := elementKey(ctx, instanceID, eventID)
+	if err != nil {
+		return nil, err
+	}
 
 	// 创建ActionEvent对象
 	actionEvent := &ActionEvent{
+		DocType:    EventDocType,
+		InstanceID: instanceID,
 		EventID:    eventID,
 		EventState: eventState,
 	}
@@ -155,7 +219,7 @@ func (cc *SmartContract) CreateActionEvent(ctx contractapi.TransactionContextInt
 	if err != nil {
 		return nil, fmt.Errorf("序列化事件数据时出错: %v", err)
 	}
-	err = stub.PutState(eventID, actionEventJSON)
+	err = stub.PutState(key, actionEventJSON)
 	if err != nil {
 		return nil, fmt.Errorf("保存事件数据时出错: %v", err)
 	}
@@ -164,21 +228,25 @@ func (cc *SmartContract) CreateActionEvent(ctx contractapi.TransactionContextInt
 }
 
 // Read function
-func (c *SmartContract) ReadMsg(ctx contractapi.TransactionContextInterface, messageID string) (*Message, error) {
-	msgJSON, err := ctx.GetStub().GetState(messageID)
+func (c *SmartContract) ReadMsg(ctx contractapi.TransactionContextInterface, instanceID string, messageID string) (*Message, error) {
+	key, err := elementKey(ctx, instanceID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	msgJSON, err := ctx.GetStub().GetState(key)
 	if err != nil {
 		fmt.Println(err.Error())
 		return nil, err
 	}
 
-	if msgJSON == nil {
+	if msgJSON == nil || docTypeOf(msgJSON) != MessageDocType {
 		errorMessage := fmt.Sprintf("Message %s does not exist", messageID)
 		fmt.Println(errorMessage)
 		return nil, errors.New(errorMessage)
 	}
 
 	var msg Message
-	err = json.Unmarshal(msgJSON, &msg)
+	err = unmarshalNumbers(msgJSON, &msg)
 	if err != nil {
 		fmt.Println(err.Error())
 		return nil, err
@@ -187,14 +255,18 @@ func (c *SmartContract) ReadMsg(ctx contractapi.TransactionContextInterface, mes
 	return &msg, nil
 }
 
-func (c *SmartContract) ReadGtw(ctx contractapi.TransactionContextInterface, gatewayID string) (*Gateway, error) {
-	gtwJSON, err := ctx.GetStub().GetState(gatewayID)
+func (c *SmartContract) ReadGtw(ctx contractapi.TransactionContextInterface, instanceID string, gatewayID string) (*Gateway, error) {
+	key, err := elementKey(ctx, instanceID, gatewayID)
+	if err != nil {
+		return nil, err
+	}
+	gtwJSON, err := ctx.GetStub().GetState(key)
 	if err != nil {
 		fmt.Println(err.Error())
 		return nil, err
 	}
 
-	if gtwJSON == nil {
+	if gtwJSON == nil || docTypeOf(gtwJSON) != GatewayDocType {
 		errorMessage := fmt.Sprintf("Gateway %s does not exist", gatewayID)
 		fmt.Println(errorMessage)
 		return nil, errors.New(errorMessage)
@@ -210,14 +282,18 @@ func (c *SmartContract) ReadGtw(ctx contractapi.TransactionContextInterface, gat
 	return &gtw, nil
 }
 
-func (c *SmartContract) ReadEvent(ctx contractapi.TransactionContextInterface, eventID string) (*ActionEvent, error) {
-	eventJSON, err := ctx.GetStub().GetState(eventID)
+func (c *SmartContract) ReadEvent(ctx contractapi.TransactionContextInterface, instanceID string, eventID string) (*ActionEvent, error) {
+	key, err := elementKey(ctx, instanceID, eventID)
+	if err != nil {
+		return nil, err
+	}
+	eventJSON, err := ctx.GetStub().GetState(key)
 	if err != nil {
 		fmt.Println(err.Error())
 		return nil, err
 	}
 
-	if eventJSON == nil {
+	if eventJSON == nil || docTypeOf(eventJSON) != EventDocType {
 		errorMessage := fmt.Sprintf("Event state %s does not exist", eventID)
 		fmt.Println(errorMessage)
 		return nil, errors.New(errorMessage)
@@ -233,15 +309,16 @@ func (c *SmartContract) ReadEvent(ctx contractapi.TransactionContextInterface, e
 	return &event, nil
 }
 
-// Change State  function
-func (c *SmartContract) ChangeMsgState(ctx contractapi.TransactionContextInterface, messageID string, msgState ElementState) error {
+// Change State function, unexported so that states only move through the engine, never through a transaction
+func (c *SmartContract) changeMsgState(ctx contractapi.TransactionContextInterface, instanceID string, messageID string, msgState ElementState) error {
 	stub := ctx.GetStub()
 
-	msg, err := c.ReadMsg(ctx, messageID)
+	msg, err := c.ReadMsg(ctx, instanceID, messageID)
 	if err != nil {
 		return err
 	}
 
+	previous := msg.MsgState
 	msg.MsgState = msgState
 
 	msgJSON, err := json.Marshal(msg)
@@ -250,19 +327,23 @@ func (c *SmartContract) ChangeMsgState(ctx contractapi.TransactionContextInterfa
 		return err
 	}
 
-	err = stub.PutState(messageID, msgJSON)
+	key, err := elementKey(ctx, instanceID, messageID)
+	if err != nil {
+		return err
+	}
+	err = stub.PutState(key, msgJSON)
 	if err != nil {
 		fmt.Println(err.Error())
 		return err
 	}
 
-	return nil
+	return c.updateWorklist(ctx, instanceID, msg, previous)
 }
 
-func (c *SmartContract) ChangeGtwState(ctx contractapi.TransactionContextInterface, gatewayID string, gtwState ElementState) error {
+func (c *SmartContract) changeGtwState(ctx contractapi.TransactionContextInterface, instanceID string, gatewayID string, gtwState ElementState) error {
 	stub := ctx.GetStub()
 
-	gtw, err := c.ReadGtw(ctx, gatewayID)
+	gtw, err := c.ReadGtw(ctx, instanceID, gatewayID)
 	if err != nil {
 		return err
 	}
@@ -275,7 +356,11 @@ func (c *SmartContract) ChangeGtwState(ctx contractapi.TransactionContextInterfa
 		return err
 	}
 
-	err = stub.PutState(gatewayID, gtwJSON)
+	key, err := elementKey(ctx, instanceID, gatewayID)
+	if err != nil {
+		return err
+	}
+	err = stub.PutState(key, gtwJSON)
 	if err != nil {
 		fmt.Println(err.Error())
 		return err
@@ -284,10 +369,10 @@ func (c *SmartContract) ChangeGtwState(ctx contractapi.TransactionContextInterfa
 	return nil
 }
 
-func (c *SmartContract) ChangeEventState(ctx contractapi.TransactionContextInterface, eventID string, eventState ElementState) error {
+func (c *SmartContract) changeEventState(ctx contractapi.TransactionContextInterface, instanceID string, eventID string, eventState ElementState) error {
 	stub := ctx.GetStub()
 
-	actionEvent, err := c.ReadEvent(ctx, eventID)
+	actionEvent, err := c.ReadEvent(ctx, instanceID, eventID)
 	if err != nil {
 		return err
 	}
@@ -300,7 +385,11 @@ func (c *SmartContract) ChangeEventState(ctx contractapi.TransactionContextInter
 		return err
 	}
 
-	err = stub.PutState(eventID, actionEventJSON)
+	key, err := elementKey(ctx, instanceID, eventID)
+	if err != nil {
+		return err
+	}
+	err = stub.PutState(key, actionEventJSON)
 	if err != nil {
 		fmt.Println(err.Error())
 		return err
@@ -309,1393 +398,162 @@ func (c *SmartContract) ChangeEventState(ctx contractapi.TransactionContextInter
 	return nil
 }
 
-//get all message
+// unmarshalNumbers decodes a record holding process variables. Numbers stay json.Number,
+// so integers beyond 2^53 keep their precision, as they do when a payload is parsed.
+func unmarshalNumbers(recordJSON []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(recordJSON))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
 
-func (cc *SmartContract) GetAllMessages(ctx contractapi.TransactionContextInterface) ([]*Message, error) {
-	resultsIterator, err := ctx.GetStub().GetStateByRange("", "")
-	if err != nil {
-		return nil, fmt.Errorf("获取状态数据时出错: %v", err) //直接err也行
+// docTypeOf reads the discriminator of a stored record, "" if it has none
+func docTypeOf(recordJSON []byte) string {
+	var record struct {
+		DocType string `json:"docType"`
 	}
-	defer resultsIterator.Close()
-
-	var messages []*Message
-	for resultsIterator.HasNext() {
-		queryResponse, err := resultsIterator.Next()
-		if err != nil {
-			return nil, fmt.Errorf("迭代状态数据时出错: %v", err)
-		}
-
-		var message Message
-		err = json.Unmarshal(queryResponse.Value, &message)
-		if err != nil {
-			return nil, fmt.Errorf("反序列化消息数据时出错: %v", err)
-		}
-
-		// 可以添加更多的筛选条件来仅获取特定类型或状态的消息
-		messages = append(messages, &message)
+	if err := json.Unmarshal(recordJSON, &record); err != nil {
+		return ""
 	}
+	return record.DocType
+}
 
-	return messages, nil
+// InstanceElements groups the element records of an instance by type
+type InstanceElements struct {
+	Messages []*Message     `json:"messages"`
+	Gateways []*Gateway     `json:"gateways"`
+	Events   []*ActionEvent `json:"events"`
 }
 
-func (cc *SmartContract) GetAllFormats(ctx contractapi.TransactionContextInterface) ([]string, error) {
-	resultsIterator, err := ctx.GetStub().GetStateByRange("", "")
+// GetInstanceElements returns every element record of an instance
+func (cc *SmartContract) GetInstanceElements(ctx contractapi.TransactionContextInterface, instanceID string) (*InstanceElements, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(elementObjectType, []string{instanceID})
 	if err != nil {
-		return nil, fmt.Errorf("获取状态数据时出错: %v", err)
+		return nil, fmt.Errorf("获取状态数据时出错: %v", err) //直接err也行
 	}
 	defer resultsIterator.Close()
 
-	formatSet := make(map[string]bool)
-	var formats []string
+	elements := &InstanceElements{}
 	for resultsIterator.HasNext() {
 		queryResponse, err := resultsIterator.Next()
 		if err != nil {
 			return nil, fmt.Errorf("迭代状态数据时出错: %v", err)
 		}
 
-		var message Message
-		err = json.Unmarshal(queryResponse.Value, &message)
-		if err != nil {
-			return nil, fmt.Errorf("反序列化消息数据时出错: %v", err)
-		}
-
-		if _, exists := formatSet[message.Format]; !exists && message.Format != "" {
-			formats = append(formats, message.Format)
-			formatSet[message.Format] = true
+		// 按 docType 区分记录类型，其他数据直接跳过
+		switch docTypeOf(queryResponse.Value) {
+		case MessageDocType:
+			var message Message
+			if err := unmarshalNumbers(queryResponse.Value, &message); err != nil {
+				return nil, fmt.Errorf("反序列化消息数据时出错: %v", err)
+			}
+			elements.Messages = append(elements.Messages, &message)
+		case GatewayDocType:
+			var gateway Gateway
+			if err := json.Unmarshal(queryResponse.Value, &gateway); err != nil {
+				return nil, fmt.Errorf("反序列化网关数据时出错: %v", err)
+			}
+			elements.Gateways = append(elements.Gateways, &gateway)
+		case EventDocType:
+			var event ActionEvent
+			if err := json.Unmarshal(queryResponse.Value, &event); err != nil {
+				return nil, fmt.Errorf("反序列化事件数据时出错: %v", err)
+			}
+			elements.Events = append(elements.Events, &event)
 		}
 	}
 
-	return formats, nil
+	return elements, nil
 }
 
-// InitLedger adds a base set of assets to the ledger
-
-var isInited bool = false
-
-func (cc *SmartContract) InitLedger(ctx contractapi.TransactionContextInterface) error {
-	stub := ctx.GetStub()
-
-	// Determines whether the chain code is initialized
-	if isInited {
-		errorMessage := "Chaincode has already been initialized"
-		fmt.Println(errorMessage)
-		return fmt.Errorf(errorMessage)
-	}
-
-	cc.CreateActionEvent(ctx, "StartEvent_1jtgn3j", ENABLE)
-
-	cc.CreateGateway(ctx, "ExclusiveGateway_0hs3ztq", DISABLE)
-	cc.CreateGateway(ctx, "ExclusiveGateway_106je4z", DISABLE)
-	cc.CreateGateway(ctx, "EventBasedGateway_1fxpmyn", DISABLE)
-	cc.CreateGateway(ctx, "ExclusiveGateway_0nzwv7v", DISABLE)
-	// cc.CreateGateway(ctx, "EndEvent_0366pfz", DISABLE)
+//get all message of an instance
 
-	// mspid    hotel:Participant_0sktaei       client:Participant_1080bkg
-	cc.CreateMessage(ctx, "Message_045i10y", "Participant_1080bkg", "Participant_0sktaei", "", DISABLE, "date:string, bedrooms:int") // Check_room(string date, uint bedrooms)"
-	cc.CreateMessage(ctx, "Message_0r9lypd", "Participant_0sktaei", "Participant_1080bkg", "", DISABLE, "confirm:bool")              // Give_availability(bool confirm)
-	cc.CreateMessage(ctx, "Message_1em0ee4", "Participant_0sktaei", "Participant_1080bkg", "", DISABLE, "quotation:int")             // Price_quotation(uint quotation)
-	cc.CreateMessage(ctx, "Message_1nlagx2", "Participant_1080bkg", "Participant_0sktaei", "", DISABLE, "confirmation:bool")         // Book_room(bool confirmation)
-	cc.CreateMessage(ctx, "Message_0o8eyir", "Participant_1080bkg", "Participant_0sktaei", "", DISABLE, "")                          // payment0(address payable to)
-	cc.CreateMessage(ctx, "Message_1ljlm4g", "Participant_0sktaei", "Participant_1080bkg", "", DISABLE, "bookingId:string")          // Give_ID(string booking_id)
-	cc.CreateMessage(ctx, "Message_0m9p3da", "Participant_1080bkg", "Participant_0sktaei", "", DISABLE, "cancel:bool")               // cancel_order(bool cancel)
-	cc.CreateMessage(ctx, "Message_1joj7ca", "Participant_1080bkg", "Participant_0sktaei", "", DISABLE, "ID:string")                 // ask_refund(string ID)
-	cc.CreateMessage(ctx, "Message_1etcmvl", "Participant_0sktaei", "Participant_1080bkg", "", DISABLE, "")                          // payment1(address payable to)
-	cc.CreateMessage(ctx, "Message_1xm9dxy", "Participant_1080bkg", "Participant_0sktaei", "", DISABLE, "motivation:string")         // Cancel_order(string motivation)
-
-	cc.CreateActionEvent(ctx, "EndEvent_146eii4", DISABLE)
-	cc.CreateActionEvent(ctx, "EndEvent_08edp7f", DISABLE)
-	cc.CreateActionEvent(ctx, "EndEvent_0366pfz", DISABLE)
-
-	isInited = true
-
-	stub.SetEvent("initLedgerEvent", []byte("Contract has been initialized successfully"))
-	return nil
-}
-
-// =================================================================================================
-func (cc *SmartContract) StartEvent_1jtgn3j(ctx contractapi.TransactionContextInterface) error {
-	stub := ctx.GetStub()
-	actionEvent, err := cc.ReadEvent(ctx, "StartEvent_1jtgn3j")
+func (cc *SmartContract) GetAllMessages(ctx contractapi.TransactionContextInterface, instanceID string) ([]*Message, error) {
+	elements, err := cc.GetInstanceElements(ctx, instanceID)
 	if err != nil {
-		return err
-	}
-
-	if actionEvent.EventState != ENABLE {
-		errorMessage := fmt.Sprintf("Event state %s is not allowed", actionEvent.EventID)
-		fmt.Println(errorMessage)
-		return fmt.Errorf(errorMessage)
+		return nil, err
 	}
-
-	//actionEvent.EventState = DONE
-	//actionEventJSON, err := json.Marshal(actionEvent)
-	//if err != nil {
-	//	fmt.Println(err.Error())
-	//	return err
-	//}
-	//err = stub.PutState("StartEvent_1jtgn3j", actionEventJSON)
-
-	cc.ChangeMsgState(ctx, "StartEvent_1jtgn3j", DONE)
-
-	//if err != nil {
-	//	fmt.Println(err.Error())
-	//	return err
-	//}
-
-	stub.SetEvent("StartEvent_1jtgn3j", []byte("Contract has been started successfully"))
-
-	//
-
-	//gtw, err := cc.ReadGtw(ctx, "ExclusiveGateway_0hs3ztq")
-	//if err != nil {
-	//	return err
-	//}
-
-	//gtw.GatewayState = ENABLE
-	//gtwJSON, err := json.Marshal(gtw)
-	//if err != nil {
-	//	fmt.Println(err.Error())
-	//	return err
-	//}
-	//err = stub.PutState("ExclusiveGateway_0hs3ztq", gtwJSON)
-
-	cc.ChangeGtwState(ctx, "ExclusiveGateway_0hs3ztq", ENABLE)
-
-	//if err != nil {
-	//	fmt.Println(err.Error())
-	//	return err
-	//}
-
-	cc.ExclusiveGateway_0hs3ztq(ctx)
-
-	return nil
+	return elements.Messages, nil
 }
 
-func (cc *SmartContract) ExclusiveGateway_0hs3ztq(ctx contractapi.TransactionContextInterface) error {
-	stub := ctx.GetStub()
-	gtw, err := cc.ReadGtw(ctx, "ExclusiveGateway_0hs3ztq")
-	if err != nil {
-		return err
-	}
-
-	if gtw.GatewayState != ENABLE {
-		errorMessage := fmt.Sprintf("Gateway state %s is not allowed", gtw.GatewayID)
-		fmt.Println(errorMessage)
-		return fmt.Errorf(errorMessage)
-	}
-
-	gtw.GatewayState = DONE
-	gtwJSON, err := json.Marshal(gtw)
-	if err != nil {
-		fmt.Println(err.Error())
-		return err
-	}
-	err = stub.PutState("ExclusiveGateway_0hs3ztq", gtwJSON)
-	if err != nil {
-		fmt.Println(err.Error())
-		return err
-	}
-
-	stub.SetEvent("ExclusiveGateway_0hs3ztq", []byte("ExclusiveGateway_0hs3ztq has been done"))
-
-	msg2, err := cc.ReadMsg(ctx, "Message_045i10y")
-	if err != nil {
-		return err
-	}
-
-	msg2.MsgState = ENABLE
-	msg2JSON, err := json.Marshal(msg2)
-	if err != nil {
-		fmt.Println(err.Error())
-		return err
-	}
-	err = stub.PutState("Message_045i10y", msg2JSON)
+func (cc *SmartContract) GetAllGateways(ctx contractapi.TransactionContextInterface, instanceID string) ([]*Gateway, error) {
+	elements, err := cc.GetInstanceElements(ctx, instanceID)
 	if err != nil {
-		fmt.Println(err.Error())
-		return err
+		return nil, err
 	}
-
-	return nil
+	return elements.Gateways, nil
 }
 
-func (cc *SmartContract) Message_045i10y_Send(ctx contractapi.TransactionContextInterface, fireflyTranID string) error {
-	stub := ctx.GetStub()
-	msg, err := cc.ReadMsg(ctx, "Message_045i10y")
-	if err != nil {
-		return err
-	}
-
-	// TODO: 待确认如何确认有权限的msp ID
-	clientMspID, err := ctx.GetClientIdentity().GetMSPID()
-	if err != nil {
-		return err
-	}
-	if clientMspID != msg.SendMspID {
-		errorMessage := fmt.Sprintf("Msp denied")
-		fmt.Println(errorMessage)
-		return fmt.Errorf(errorMessage)
-	}
-
-	if msg.MsgState != ENABLE {
-		errorMessage := fmt.Sprintf("Msg state %s is not allowed", msg.MessageID)
-		fmt.Println(errorMessage)
-		return fmt.Errorf(errorMessage)
-	}
-
-	msg.MsgState = WAITFORCONFIRM
-	msg.FireflyTranID = fireflyTranID
-	msgJSON, err := json.Marshal(msg)
-	if err != nil {
-		fmt.Println(err.Error())
-		return err
-	}
-	err = stub.PutState("Message_045i10y", msgJSON)
+func (cc *SmartContract) GetAllEvents(ctx contractapi.TransactionContextInterface, instanceID string) ([]*ActionEvent, error) {
+	elements, err := cc.GetInstanceElements(ctx, instanceID)
 	if err != nil {
-		fmt.Println(err.Error())
-		return err
+		return nil, err
 	}
-
-	stub.SetEvent("Message_045i10y", []byte("Message_045i10y need to be confirm"))
-
-	//msg2, err := cc.ReadMsg(ctx, "Message_0r9lypd")
-	//if err != nil {
-	//	return err
-	//}
-	//msg2.MsgState = ENABLE
-	//msg2JSON, err := json.Marshal(msg2)
-	//if err != nil {
-	//	fmt.Println(err.Error())
-	//	return err
-	//}
-	//err = stub.PutState("Message_0r9lypd", msg2JSON)
-	//if err != nil {
-	//	fmt.Println(err.Error())
-	//	return err
-	//}
-
-	return nil
+	return elements.Events, nil
 }
 
-func (cc *SmartContract) Message_045i10y_Confirm(ctx contractapi.TransactionContextInterface) error {
-	stub := ctx.GetStub()
-	msg, err := cc.ReadMsg(ctx, "Message_045i10y")
+// GetAllFormats lists the message formats of the deployed choreographies, each format once.
+// It reads the definitions, not the elements of their instances.
+func (cc *SmartContract) GetAllFormats(ctx contractapi.TransactionContextInterface) ([]string, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(choreographyObjectType, []string{})
 	if err != nil {
-		return err
-	}
-
-	if msg.MsgState != WAITFORCONFIRM {
-		errorMessage := fmt.Sprintf("Msg state %s does not allowed", msg.MessageID)
-		fmt.Println(errorMessage)
-		return errors.New(fmt.Sprintf("Msg state %s does not allowed", msg.MessageID))
-	}
-
-	clientIdentity := ctx.GetClientIdentity()
-	clientMspID, _ := clientIdentity.GetMSPID()
-
-	if clientMspID != msg.ReceiveMspID {
-		errorMessage := fmt.Sprintf("Msp denied")
-		fmt.Println(errorMessage)
-		return errors.New(fmt.Sprintf("Msp denied"))
+		return nil, fmt.Errorf("获取状态数据时出错: %v", err)
 	}
+	defer resultsIterator.Close()
 
-	msg.MsgState = DONE
+	formatSet := make(map[string]bool)
+	var formats []string
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("迭代状态数据时出错: %v", err)
+		}
 
-	cc.ChangeMsgState(ctx, "Message_045i10y", DONE)
-	err = stub.SetEvent("Message_045i10y", []byte("Message_1nlagx2 has been done"))
+		var chor Choreography
+		if err := json.Unmarshal(queryResponse.Value, &chor); err != nil {
+			return nil, fmt.Errorf("反序列化编排数据时出错: %v", err)
+		}
 
-	msg2, err := cc.ReadMsg(ctx, "Message_0r9lypd")
-	if err != nil {
-		return err
-	}
-	msg2.MsgState = ENABLE
-	msg2JSON, err := json.Marshal(msg2)
-	if err != nil {
-		fmt.Println(err.Error())
-		return err
-	}
-	err = stub.PutState("Message_0r9lypd", msg2JSON)
-	if err != nil {
-		fmt.Println(err.Error())
-		return err
+		for _, messageID := range sortedMessageIDs(&chor) {
+			format := chor.Messages[messageID].Format
+			if _, exists := formatSet[format]; !exists && format != "" {
+				formats = append(formats, format)
+				formatSet[format] = true
+			}
+		}
 	}
 
-	return nil
+	return formats, nil
 }
 
-func (cc *SmartContract) Message_0r9lypd_Send(ctx contractapi.TransactionContextInterface, fireflyTranID string, confirm bool) error {
+// InitLedger adds a base set of assets to the ledger
+func (cc *SmartContract) InitLedger(ctx contractapi.TransactionContextInterface) error {
 	stub := ctx.GetStub()
-	msg, err := cc.ReadMsg(ctx, "Message_0r9lypd")
-	if err != nil {
-		return err
-	}
-
-	// 获取客户端MSP ID
-	clientMspID, err := ctx.GetClientIdentity().GetMSPID()
-	if err != nil {
-		return err
-	}
-	if clientMspID != msg.SendMspID {
-		errorMessage := fmt.Sprintf("Msp denied")
-		fmt.Println(errorMessage)
-		return fmt.Errorf(errorMessage)
-	}
-
-	if msg.MsgState != ENABLE {
-		errorMessage := fmt.Sprintf("Msg state %s is not allowed", msg.MessageID)
-		fmt.Println(errorMessage)
-		return fmt.Errorf(errorMessage)
-	}
 
-	msg.MsgState = WAITFORCONFIRM
-	msg.FireflyTranID = fireflyTranID
-	msgJSON, err := json.Marshal(msg)
-	if err != nil {
-		fmt.Println(err.Error())
-		return err
-	}
-	err = stub.PutState("Message_0r9lypd", msgJSON)
+	// Determines whether the chain code is initialized
+	metadata, err := cc.readLedgerMetadata(ctx)
 	if err != nil {
-		fmt.Println(err.Error())
 		return err
 	}
-
-	stub.SetEvent("Message_0r9lypd", []byte("Message_0r9lypd need to be confirm"))
-
-	// 设置当前内存的确认字段
-	cc.currentMemory.Confirm = confirm
-
-	//gtw, err := cc.ReadGtw(ctx, "ExclusiveGateway_106je4z")
-	//if err != nil {
-	//	return err
-	//}
-	//gtw.GatewayState = ENABLE
-	//gtwJSON, err := json.Marshal(gtw)
-	//if err != nil {
-	//	fmt.Println(err.Error())
-	//	return err
-	//}
-	//err = stub.PutState("ExclusiveGateway_106je4z", gtwJSON)
-	//if err != nil {
-	//	fmt.Println(err.Error())
-	//	return err
-	//}
-	//
-	//// 调用ExclusiveGateway_106je4z函数
-	//cc.ExclusiveGateway_106je4z(ctx)
-
-	return nil
-}
-
-func (cc *SmartContract) Message_0r9lypd_Confirm(ctx contractapi.TransactionContextInterface, fireflyTranID string, confirm bool) error {
-	stub := ctx.GetStub()
-	msg, _ := cc.ReadMsg(ctx, "Message_0r9lypd")
-
-	if msg.MsgState != WAITFORCONFIRM {
-		errorMessage := fmt.Sprintf("Msg state %s does not allowed", msg.MessageID)
-		fmt.Println(errorMessage)
-		return errors.New(fmt.Sprintf("Msg state %s does not allowed", msg.MessageID))
-	}
-
-	clientIdentity := ctx.GetClientIdentity()
-	clientMspID, _ := clientIdentity.GetMSPID()
-
-	if clientMspID != msg.ReceiveMspID {
-		errorMessage := fmt.Sprintf("Msp denied")
+	if metadata != nil && metadata.Initialized {
+		errorMessage := "Chaincode has already been initialized"
 		fmt.Println(errorMessage)
-		return errors.New(fmt.Sprintf("Msp denied"))
+		return errors.New(errorMessage)
 	}
 
-	msg.MsgState = DONE
-
-	cc.ChangeMsgState(ctx, "Message_0r9lypd", DONE)
-	_ = stub.SetEvent("Message_0r9lypd", []byte("Message_1nlagx2 has been done"))
-
-	gtw, err := cc.ReadGtw(ctx, "ExclusiveGateway_106je4z")
-	if err != nil {
-		return err
-	}
-	gtw.GatewayState = ENABLE
-	gtwJSON, err := json.Marshal(gtw)
-	if err != nil {
-		fmt.Println(err.Error())
-		return err
-	}
-	err = stub.PutState("ExclusiveGateway_106je4z", gtwJSON)
+	// mspid    hotel:Participant_0sktaei       client:Participant_1080bkg
+	chor, err := ParseChoreography(hotelBookingBPMN)
 	if err != nil {
-		fmt.Println(err.Error())
 		return err
 	}
-
-	// 调用ExclusiveGateway_106je4z函数
-	cc.ExclusiveGateway_106je4z(ctx)
-
-	return nil
-}
-
-func (c *SmartContract) ExclusiveGateway_106je4z(ctx contractapi.TransactionContextInterface) error {
-	stub := ctx.GetStub()
-	gtw, err := c.ReadGtw(ctx, "ExclusiveGateway_106je4z")
-	if err != nil {
+	if err := cc.deployChoreography(ctx, chor); err != nil {
 		return err
 	}
 
-	if gtw.GatewayState != ENABLE {
-		errorMessage := fmt.Sprintf("Gateway state %s is not allowed", gtw.GatewayID)
-		fmt.Println(errorMessage)
-		return fmt.Errorf("%s", errorMessage)
-	}
-
-	gtw.GatewayState = DONE
-	sortedJson, err := json.Marshal(gtw)
-	if err != nil {
-		fmt.Println(err.Error())
-		return err
-	}
-
-	err = stub.PutState("ExclusiveGateway_106je4z", sortedJson)
-	if err != nil {
-		fmt.Println(err.Error())
-		return err
-	}
-
-	stub.SetEvent("ExclusiveGateway_106je4z", []byte("ExclusiveGateway_106je4z has been done"))
-
-	if c.currentMemory.Confirm {
-		msg2, err := c.ReadMsg(ctx, "Message_1em0ee4")
-		if err != nil {
-			return err
-		}
-		msg2.MsgState = ENABLE
-		sortedJson2, err := json.Marshal(msg2)
-		if err != nil {
-			fmt.Println(err.Error())
-			return err
-		}
-
-		err = stub.PutState("Message_1em0ee4", sortedJson2)
-		if err != nil {
-			fmt.Println(err.Error())
-			return err
-		}
-	} else {
-		gtw2, err := c.ReadGtw(ctx, "ExclusiveGateway_0hs3ztq")
-		if err != nil {
-			return err
-		}
-		gtw2.GatewayState = ENABLE
-		sortedJson2, err := json.Marshal(gtw2)
-		if err != nil {
-			fmt.Println(err.Error())
-			return err
-		}
-
-		err = stub.PutState("ExclusiveGateway_0hs3ztq", sortedJson2)
-		if err != nil {
-			fmt.Println(err.Error())
-			return err
-		}
-
-		err = c.ExclusiveGateway_0hs3ztq(ctx)
-		if err != nil {
-			return err
-		}
-	}
-
-	return nil
-}
-
-func (s *SmartContract) Message_1em0ee4_Send(ctx contractapi.TransactionContextInterface, fireflyTranID string) error {
-	stub := ctx.GetStub()
-
-	// 读取消息
-	msg, err := s.ReadMsg(ctx, "Message_1em0ee4")
-	if err != nil {
-		return err
-	}
-
-	// 获取客户端MSP ID
-	clientMspID, err := ctx.GetClientIdentity().GetMSPID()
-	if err != nil {
-		return err
-	}
-	if clientMspID != msg.SendMspID {
-		errorMessage := fmt.Sprintf("Msp denied")
-		fmt.Println(errorMessage)
-		return fmt.Errorf(errorMessage)
-	}
-
-	// 检查消息状态
-	if msg.MsgState != ENABLE {
-		errorMessage := fmt.Sprintf("Msg state %s does not allowed", msg.MessageID)
-		fmt.Println(errorMessage)
-		return errors.New(fmt.Sprintf("Msg state %s does not allowed", msg.MessageID))
-	}
-
-	// 更新消息状态
-	msg.MsgState = WAITFORCONFIRM
-	msg.FireflyTranID = fireflyTranID
-
-	// 序列化并保存消息
-	msgJSON, err := json.Marshal(msg)
-	if err != nil {
-		fmt.Println(err.Error())
-		return err
-	}
-	err = stub.PutState("Message_1em0ee4", msgJSON)
-	if err != nil {
-		fmt.Println(err.Error())
-		return err
-	}
-
-	// 设置事件
-	err = stub.SetEvent("Message_1em0ee4", []byte("Message_1em0ee4 need to be confirm"))
-	if err != nil {
-		fmt.Println(err.Error())
-		return err
-	}
-
-	// 更新消息状态为ENABLE
-	//err = s.ChangeMsgState(ctx, "Message_1nlagx2", ENABLE)
-	//if err != nil {
-	//	return err
-	//}
-	//
-	return nil
-}
-
-func (cc *SmartContract) Message_1em0ee4_Confirm(ctx contractapi.TransactionContextInterface, fireflyTranID string) error {
-	stub := ctx.GetStub()
-
-	// 读取消息
-	msg, err := cc.ReadMsg(ctx, "Message_1em0ee4")
-	if err != nil {
-		return err
-	}
-
-	if msg.MsgState != WAITFORCONFIRM {
-		errorMessage := fmt.Sprintf("Msg state %s does not allowed", msg.MessageID)
-		fmt.Println(errorMessage)
-		return errors.New(fmt.Sprintf("Msg state %s does not allowed", msg.MessageID))
-	}
-
-	clientIdentity := ctx.GetClientIdentity()
-	clientMspID, _ := clientIdentity.GetMSPID()
-
-	if clientMspID != msg.ReceiveMspID {
-		errorMessage := fmt.Sprintf("Msp denied")
-		fmt.Println(errorMessage)
-		return errors.New(fmt.Sprintf("Msp denied"))
-	}
-
-	cc.ChangeMsgState(ctx, "Message_1em0ee4", DONE)
-	err = stub.SetEvent("Message_1em0ee4", []byte("Message_1nlagx2 has been done"))
-
-	err = cc.ChangeMsgState(ctx, "Message_1nlagx2", ENABLE)
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
-
-func (cc *SmartContract) Message_1nlagx2_confirm(ctx contractapi.TransactionContextInterface) error {
-	stub := ctx.GetStub()
-
-	msg, _ := cc.ReadMsg(ctx, "Message_1nlagx2")
-
-	if msg.MsgState != WAITFORCONFIRM {
-		errorMessage := fmt.Sprintf("Msg state %s does not allowed", msg.MessageID)
-		fmt.Println(errorMessage)
-		return errors.New(fmt.Sprintf("Msg state %s does not allowed", msg.MessageID))
-	}
-
-	clientIdentity := ctx.GetClientIdentity()
-	clientMspID, _ := clientIdentity.GetMSPID()
-
-	if clientMspID != msg.ReceiveMspID {
-		errorMessage := fmt.Sprintf("Msp denied")
-		fmt.Println(errorMessage)
-		return errors.New(fmt.Sprintf("Msp denied"))
-	}
-
-	cc.ChangeMsgState(ctx, "Message_1nlagx2", DONE)
-	err := stub.SetEvent("Message_1nlagx2", []byte("Message_1nlagx2 has been done"))
-
-	// 更新网关状态为ENABLE
-	err = cc.ChangeGtwState(ctx, "EventBasedGateway_1fxpmyn", ENABLE)
-	if err != nil {
-		return err
-	}
-
-	// 调用EventBasedGateway_1fxpmyn方法
-	err = cc.EventBasedGateway_1fxpmyn(ctx)
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
-
-func (s *SmartContract) Message_1nlagx2_Send(ctx contractapi.TransactionContextInterface, fireflyTranID string) error {
-	stub := ctx.GetStub()
-
-	// 读取消息
-	msg, err := s.ReadMsg(ctx, "Message_1nlagx2")
-	if err != nil {
-		return err
-	}
-
-	// 获取客户端身份
-	clientIdentity := ctx.GetClientIdentity()
-	clientMspID, _ := clientIdentity.GetMSPID()
-
-	// 检查权限
-	if clientMspID != msg.SendMspID {
-		errorMessage := fmt.Sprintf("Msp denied")
-		fmt.Println(errorMessage)
-		return errors.New(fmt.Sprintf("Msp denied"))
-	}
-
-	// 检查消息状态
-	if msg.MsgState != ENABLE {
-		errorMessage := fmt.Sprintf("Msg state %s does not allowed", msg.MessageID)
-		fmt.Println(errorMessage)
-		return errors.New(fmt.Sprintf("Msg state %s does not allowed", msg.MessageID))
-	}
-
-	// 更新消息状态
-	msg.MsgState = WAITFORCONFIRM
-	msg.FireflyTranID = fireflyTranID
-
-	// 序列化并保存消息
-	msgJSON, err := json.Marshal(msg)
-	if err != nil {
-		fmt.Println(err.Error())
-		return err
-	}
-	err = stub.PutState("Message_1nlagx2", msgJSON)
-	if err != nil {
-		fmt.Println(err.Error())
-		return err
-	}
-
-	// 设置事件
-	err = stub.SetEvent("Message_1nlagx2", []byte("Message_1nlagx2 wait for confirm"))
-	if err != nil {
-		fmt.Println(err.Error())
-		return err
-	}
-
-	//// 更新网关状态为ENABLE
-	//err = s.ChangeGtwState(ctx, "EventBasedGateway_1fxpmyn", ENABLE)
-	//if err != nil {
-	//	return err
-	//}
-	//
-	//// 调用EventBasedGateway_1fxpmyn方法
-	//err = s.EventBasedGateway_1fxpmyn(ctx)
-	//if err != nil {
-	//	return err
-	//}
-
-	return nil
-}
-
-func (s *SmartContract) EventBasedGateway_1fxpmyn(ctx contractapi.TransactionContextInterface) error {
-	stub := ctx.GetStub()
-
-	// 读取网关状态
-	gtw, err := s.ReadGtw(ctx, "EventBasedGateway_1fxpmyn")
-	if err != nil {
-		return err
-	}
-
-	// 检查网关状态
-	if gtw.GatewayState != ENABLE {
-		errorMessage := fmt.Sprintf("Gateway state %s does not allowed", gtw.GatewayID)
-		fmt.Println(errorMessage)
-		return errors.New(fmt.Sprintf("Gateway state %s does not allowed", gtw.GatewayID))
-	}
-
-	// 更新网关状态为DONE
-	gtw.GatewayState = DONE
-
-	// 序列化并保存网关状态
-	gtwJSON, err := json.Marshal(gtw)
-	if err != nil {
-		fmt.Println(err.Error())
-		return err
-	}
-	err = stub.PutState("EventBasedGateway_1fxpmyn", gtwJSON)
-	if err != nil {
-		fmt.Println(err.Error())
-		return err
-	}
-
-	// 设置事件
-	err = stub.SetEvent("EventBasedGateway_1fxpmyn", []byte("EventBasedGateway_1fxpmyn has been done"))
-	if err != nil {
-		fmt.Println(err.Error())
-		return err
-	}
-
-	// 更新消息状态为ENABLE
-	err = s.ChangeMsgState(ctx, "Message_0o8eyir", ENABLE)
-	if err != nil {
-		return err
-	}
-
-	err = s.ChangeMsgState(ctx, "Message_1xm9dxy", ENABLE)
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
-
-func (s *SmartContract) Message_0o8eyir_Send(ctx contractapi.TransactionContextInterface, cancel bool, fireflyTranID string) error {
-	stub := ctx.GetStub()
-
-	// 读取消息状态
-	msg, err := s.ReadMsg(ctx, "Message_0o8eyir")
-	if err != nil {
-		return err
-	}
-
-	// 检查客户端MspId
-	clientIdentity := ctx.GetClientIdentity()
-	clientMspId, _ := clientIdentity.GetMSPID()
-	if clientMspId != msg.SendMspID {
-		errorMessage := fmt.Sprintf("Msp denied")
-		fmt.Println(errorMessage)
-		return errors.New(fmt.Sprintf("Msp denied"))
-	}
-
-	// 检查消息状态
-	if msg.MsgState != ENABLE {
-		errorMessage := fmt.Sprintf("Msg state %s does not allowed", msg.MessageID)
-		fmt.Println(errorMessage)
-		return errors.New(fmt.Sprintf("Msg state %s does not allowed", msg.MessageID))
-	}
-
-	// 更新消息状态为DONE
-	msg.MsgState = WAITFORCONFIRM
-	msg.FireflyTranID = fireflyTranID
-
-	// 序列化并保存消息状态
-	msgJSON, err := json.Marshal(msg)
-	if err != nil {
-		fmt.Println(err.Error())
-		return err
-	}
-	err = stub.PutState("Message_0o8eyir", msgJSON)
-	if err != nil {
-		fmt.Println(err.Error())
-		return err
-	}
-
-	// 设置事件
-	err = stub.SetEvent("Message_0o8eyir", []byte("Message_0o8eyir need to be confirm"))
-	if err != nil {
-		fmt.Println(err.Error())
-		return err
-	}
-
-	//// 更新消息状态为DISABLE
-	//err = s.ChangeMsgState(ctx, "Message_1xm9dxy", DISABLE)
-	//if err != nil {
-	//	return err
-	//}
-	//
-	//// 更新网关状态为ENABLE
-	//err = s.ChangeGtwState(ctx, "ExclusiveGateway_0nzwv7v", ENABLE)
-	//if err != nil {
-	//	return err
-	//}
-
-	// 设置当前内存状态
-	s.currentMemory.Cancel = cancel
-
-	// 跳转到ExclusiveGateway_0nzwv7v
-	return s.ExclusiveGateway_0nzwv7v(ctx)
-}
-
-func (cc *SmartContract) Message_0o8eyir_Confirm(ctx contractapi.TransactionContextInterface, cancel bool, fireflyTranID string) error {
-	stub := ctx.GetStub()
-
-	// 读取消息状态
-	msg, err := cc.ReadMsg(ctx, "Message_0o8eyir")
-	if err != nil {
-		return err
-	}
-
-	if msg.MsgState != WAITFORCONFIRM {
-		errorMessage := fmt.Sprintf("Msg state %s does not allowed", msg.MessageID)
-		fmt.Println(errorMessage)
-		return errors.New(fmt.Sprintf("Msg state %s does not allowed", msg.MessageID))
-	}
-
-	clientIdentity := ctx.GetClientIdentity()
-	clientMspID, _ := clientIdentity.GetMSPID()
-
-	if clientMspID != msg.ReceiveMspID {
-		errorMessage := fmt.Sprintf("Msp denied")
-		fmt.Println(errorMessage)
-		return errors.New(fmt.Sprintf("Msp denied"))
-	}
-
-	cc.ChangeMsgState(ctx, "Message_0o8eyir", DONE)
-	err = stub.SetEvent("Message_0o8eyir", []byte("Message_0o8eyir has been done"))
-
-	// 更新消息状态为DISABLE
-	err = cc.ChangeMsgState(ctx, "Message_1xm9dxy", DISABLE)
-	if err != nil {
-		return err
-	}
-
-	// 更新网关状态为ENABLE
-	err = cc.ChangeGtwState(ctx, "ExclusiveGateway_0nzwv7v", ENABLE)
-	if err != nil {
-		return err
-	}
-
-	return cc.ExclusiveGateway_0nzwv7v(ctx)
-
-}
-
-func (s *SmartContract) ExclusiveGateway_0nzwv7v(ctx contractapi.TransactionContextInterface) error {
-	stub := ctx.GetStub()
-
-	// 读取网关状态
-	gtw, err := s.ReadGtw(ctx, "ExclusiveGateway_0nzwv7v")
-	if err != nil {
-		return err
-	}
-
-	// 检查网关状态
-	if gtw.GatewayState != ENABLE {
-		errorMessage := fmt.Sprintf("Gateway state %s does not allowed", gtw.GatewayID)
-		fmt.Println(errorMessage)
-		return errors.New(fmt.Sprintf("Gateway state %s does not allowed", gtw.GatewayID))
-	}
-
-	// 更新网关状态为DONE
-	gtw.GatewayState = DONE
-
-	// 序列化并保存网关状态
-	gtwJSON, err := json.Marshal(gtw)
-	if err != nil {
-		fmt.Println(err.Error())
-		return err
-	}
-	err = stub.PutState("ExclusiveGateway_0nzwv7v", gtwJSON)
-	if err != nil {
-		fmt.Println(err.Error())
-		return err
-	}
-
-	// 设置事件
-	err = stub.SetEvent("ExclusiveGateway_0nzwv7v", []byte("ExclusiveGateway_0nzwv7v has been done"))
-	if err != nil {
-		fmt.Println(err.Error())
-		return err
-	}
-
-	if s.currentMemory.Cancel {
-		// 如果取消标志为true，则启用消息
-		msg2, err := s.ReadMsg(ctx, "Message_1joj7ca")
-		if err != nil {
-			return err
-		}
-		msg2.MsgState = ENABLE
-
-		// 序列化并保存消息状态
-		msg2JSON, err := json.Marshal(msg2)
-		if err != nil {
-			fmt.Println(err.Error())
-			return err
-		}
-		err = stub.PutState("Message_1joj7ca", msg2JSON)
-		if err != nil {
-			fmt.Println(err.Error())
-			return err
-		}
-	} else {
-		// 启用结束事件
-		event, err := s.ReadEvent(ctx, "EndEvent_08edp7f")
-		if err != nil {
-			return err
-		}
-		event.EventState = ENABLE
-
-		// 序列化并保存事件状态
-		eventJSON, err := json.Marshal(event)
-		if err != nil {
-			fmt.Println(err.Error())
-			return err
-		}
-		err = stub.PutState("EndEvent_08edp7f", eventJSON)
-		if err != nil {
-			fmt.Println(err.Error())
-			return err
-		}
-
-		// 跳转到EndEvent_08edp7f
-		return s.EndEvent_08edp7f(ctx)
-	}
-
-	return nil
-}
-
-func (s *SmartContract) Message_1joj7ca_Send(ctx contractapi.TransactionContextInterface, fireflyTranID string) error {
-	stub := ctx.GetStub()
-
-	// 读取消息状态
-	msg, err := s.ReadMsg(ctx, "Message_1joj7ca")
-	if err != nil {
-		return err
-	}
-
-	// 获取客户端身份信息
-	clientIdentity := ctx.GetClientIdentity()
-	clientMspID, err := clientIdentity.GetMSPID()
-	if err != nil {
-		fmt.Println(err.Error())
-		return err
-	}
-
-	// 检查MSPID是否匹配
-	if clientMspID != msg.SendMspID {
-		errorMessage := "Msp denied"
-		fmt.Println(errorMessage)
-		return errors.New(errorMessage)
-	}
-
-	// 检查消息状态
-	if msg.MsgState != ENABLE {
-		errorMessage := fmt.Sprintf("Msg state %s does not allowed", msg.MessageID)
-		fmt.Println(errorMessage)
-		return errors.New(errorMessage)
-	}
-
-	// 更新消息状态为DONE
-	msg.MsgState = WAITFORCONFIRM
-	msg.FireflyTranID = fireflyTranID
-
-	// 序列化并保存消息状态
-	msgJSON, err := json.Marshal(msg)
-	if err != nil {
-		fmt.Println(err.Error())
-		return err
-	}
-	err = stub.PutState("Message_1joj7ca", msgJSON)
-	if err != nil {
-		fmt.Println(err.Error())
-		return err
-	}
-
-	// 设置事件
-	err = stub.SetEvent("Message_1joj7ca", []byte("Message_1joj7ca need to be confirm"))
-	if err != nil {
-		fmt.Println(err.Error())
-		return err
-	}
-
-	// 启用下一条消息状态
-	return s.ChangeMsgState(ctx, "Message_1etcmvl", ENABLE)
-}
-
-func (s *SmartContract) Message_1joj7ca_Confirm(ctx contractapi.TransactionContextInterface, fireflyTranID string) error {
-	stub := ctx.GetStub()
-
-	// 读取消息状态
-	msg, err := s.ReadMsg(ctx, "Message_1joj7ca")
-	if err != nil {
-		return err
-	}
-
-	if msg.MsgState != WAITFORCONFIRM {
-		errorMessage := fmt.Sprintf("Msg state %s does not allowed", msg.MessageID)
-		fmt.Println(errorMessage)
-		return errors.New(fmt.Sprintf("Msg state %s does not allowed", msg.MessageID))
-	}
-
-	clientIdentity := ctx.GetClientIdentity()
-	clientMspID, _ := clientIdentity.GetMSPID()
-
-	if clientMspID != msg.ReceiveMspID {
-		errorMessage := fmt.Sprintf("Msp denied")
-		fmt.Println(errorMessage)
-		return errors.New(fmt.Sprintf("Msp denied"))
-	}
-
-	s.ChangeMsgState(ctx, "Message_1joj7ca", DONE)
-	err = stub.SetEvent("Message_1joj7ca", []byte("Message_1joj7ca has been done"))
-
-	return s.ChangeMsgState(ctx, "Message_1etcmvl", ENABLE)
-}
-
-func (s *SmartContract) Message_1etcmvl_Send(ctx contractapi.TransactionContextInterface, fireflyTranID string) error {
-	stub := ctx.GetStub()
-
-	// 读取消息状态
-	msg, err := s.ReadMsg(ctx, "Message_1etcmvl")
-	if err != nil {
-		return err
-	}
-
-	// 获取客户端身份信息
-	clientIdentity := ctx.GetClientIdentity()
-	clientMspID, err := clientIdentity.GetMSPID()
-	if err != nil {
-		fmt.Println(err.Error())
-		return err
-	}
-
-	// 检查MSPID是否匹配
-	if clientMspID != msg.SendMspID {
-		errorMessage := "Msp denied"
-		fmt.Println(errorMessage)
-		return errors.New(errorMessage)
-	}
-
-	// 检查消息状态
-	if msg.MsgState != ENABLE {
-		errorMessage := fmt.Sprintf("Msg state %s does not allowed", msg.MessageID)
-		fmt.Println(errorMessage)
-		return errors.New(errorMessage)
-	}
-
-	// 更新消息状态为DONE
-	msg.MsgState = WAITFORCONFIRM
-	msg.FireflyTranID = fireflyTranID
-
-	// 序列化并保存消息状态
-	msgJSON, err := json.Marshal(msg)
-	if err != nil {
-		fmt.Println(err.Error())
-		return err
-	}
-	err = stub.PutState("Message_1etcmvl", msgJSON)
-	if err != nil {
-		fmt.Println(err.Error())
-		return err
-	}
-
-	// 设置事件
-	err = stub.SetEvent("Message_1etcmvl", []byte("Message_1etcmvl need to be confirm"))
-	if err != nil {
-		fmt.Println(err.Error())
-		return err
-	}
-
-	//// 完成事件
-	//event, _ := s.ReadEvent(ctx, "EndEvent_146eii4")
-	//event.EventState = ENABLE
-	//
-	//// 序列化并保存事件状态
-	//eventJSON, err := json.Marshal(event)
-	//if err != nil {
-	//	fmt.Println(err.Error())
-	//	return err
-	//}
-	//err = stub.PutState("EndEvent_146eii4", eventJSON)
-	//if err != nil {
-	//	fmt.Println(err.Error())
-	//	return err
-	//}
-	//
-	//// 执行EndEvent_146eii4方法
-	//return s.EndEvent_146eii4(ctx)
-	return nil
-}
-
-func (s *SmartContract) Message_1etcmvl_Confirm(ctx contractapi.TransactionContextInterface, fireflyTranID string) error {
-	stub := ctx.GetStub()
-
-	// 读取消息状态
-	msg, err := s.ReadMsg(ctx, "Message_1etcmvl")
-	if err != nil {
-		return err
-	}
-
-	if msg.MsgState != WAITFORCONFIRM {
-		errorMessage := fmt.Sprintf("Msg state %s does not allowed", msg.MessageID)
-		fmt.Println(errorMessage)
-		return errors.New(fmt.Sprintf("Msg state %s does not allowed", msg.MessageID))
-	}
-
-	clientIdentity := ctx.GetClientIdentity()
-	clientMspID, _ := clientIdentity.GetMSPID()
-
-	if clientMspID != msg.ReceiveMspID {
-		errorMessage := fmt.Sprintf("Msp denied")
-		fmt.Println(errorMessage)
-		return errors.New(fmt.Sprintf("Msp denied"))
-	}
-
-	s.ChangeMsgState(ctx, "Message_1etcmvl", DONE)
-	err = stub.SetEvent("Message_1etcmvl", []byte("Message_1etcmvl has been done"))
-	// 完成事件
-	event, _ := s.ReadEvent(ctx, "EndEvent_146eii4")
-	event.EventState = ENABLE
-
-	// 序列化并保存事件状态
-	eventJSON, err := json.Marshal(event)
-	if err != nil {
-		fmt.Println(err.Error())
-		return err
-	}
-	err = stub.PutState("EndEvent_146eii4", eventJSON)
-	if err != nil {
-		fmt.Println(err.Error())
-		return err
-	}
-
-	// 执行EndEvent_146eii4方法
-	return s.EndEvent_146eii4(ctx)
-}
-
-func (s *SmartContract) Message_1xm9dxy_Send(ctx contractapi.TransactionContextInterface, fireflyTranID string) error {
-	stub := ctx.GetStub()
-
-	// 读取消息状态
-	msg, err := s.ReadMsg(ctx, "Message_1xm9dxy")
-	if err != nil {
-		return err
-	}
-
-	// 获取客户端身份信息
-	clientIdentity := ctx.GetClientIdentity()
-	clientMspID, err := clientIdentity.GetMSPID()
-	if err != nil {
-		fmt.Println(err.Error())
-		return err
-	}
-
-	// 检查MSPID是否匹配
-	if clientMspID != msg.SendMspID {
-		errorMessage := "Msp denied"
-		fmt.Println(errorMessage)
-		return errors.New(errorMessage)
-	}
-
-	// 检查消息状态
-	if msg.MsgState != ENABLE {
-		errorMessage := fmt.Sprintf("Msg state %s does not allowed", msg.MessageID)
-		fmt.Println(errorMessage)
-		return errors.New(errorMessage)
-	}
-
-	// 更新消息状态为ENABLE
-	msg.MsgState = WAITFORCONFIRM
-	msg.FireflyTranID = fireflyTranID
-
-	// 序列化并保存消息状态
-	msgJSON, err := json.Marshal(msg)
-	if err != nil {
-		fmt.Println(err.Error())
-		return err
-	}
-	err = stub.PutState("Message_1xm9dxy", msgJSON)
-	if err != nil {
-		fmt.Println(err.Error())
-		return err
-	}
-
-	// 设置事件
-	err = stub.SetEvent("Message_1xm9dxy", []byte("Message_1xm9dxy need to be confirm"))
-	if err != nil {
-		fmt.Println(err.Error())
-		return err
-	}
-
-	//// 完成事件
-	//event, _ := s.ReadEvent(ctx, "EndEvent_0366pfz")
-	//event.EventState = ENABLE
-	//
-	//// 序列化并保存事件状态
-	//eventJSON, err := json.Marshal(event)
-	//if err != nil {
-	//	fmt.Println(err.Error())
-	//	return err
-	//}
-	//err = stub.PutState("EndEvent_0366pfz", eventJSON)
-	//if err != nil {
-	//	fmt.Println(err.Error())
-	//	return err
-	//}
-	//
-	//// 执行EndEvent_0366pfz方法
-	//return s.EndEvent_0366pfz(ctx)
-	return nil
-}
-
-func (s *SmartContract) Message_1xm9dxy_Confirm(ctx contractapi.TransactionContextInterface, fireflyTranID string) error {
-	stub := ctx.GetStub()
-
-	// 读取消息状态
-	msg, err := s.ReadMsg(ctx, "Message_1xm9dxy")
-	if err != nil {
-		return err
-	}
-
-	if msg.MsgState != WAITFORCONFIRM {
-		errorMessage := fmt.Sprintf("Msg state %s does not allowed", msg.MessageID)
-		fmt.Println(errorMessage)
-		return errors.New(fmt.Sprintf("Msg state %s does not allowed", msg.MessageID))
-	}
-
-	clientIdentity := ctx.GetClientIdentity()
-	clientMspID, _ := clientIdentity.GetMSPID()
-
-	if clientMspID != msg.ReceiveMspID {
-		errorMessage := fmt.Sprintf("Msp denied")
-		fmt.Println(errorMessage)
-		return errors.New(fmt.Sprintf("Msp denied"))
-	}
-
-	s.ChangeMsgState(ctx, "Message_1xm9dxy", DONE)
-	err = stub.SetEvent("Message_1xm9dxy", []byte("Message_1xm9dxy has been done"))
-
-	// 完成事件
-	event, _ := s.ReadEvent(ctx, "EndEvent_0366pfz")
-	event.EventState = ENABLE
-
-	// 序列化并保存事件状态
-	eventJSON, err := json.Marshal(event)
-	if err != nil {
-		fmt.Println(err.Error())
-		return err
-	}
-	err = stub.PutState("EndEvent_0366pfz", eventJSON)
-	if err != nil {
-		fmt.Println(err.Error())
-		return err
-	}
-
-	// 执行EndEvent_0366pfz方法
-	return s.EndEvent_0366pfz(ctx)
-}
-
-func (s *SmartContract) EndEvent_08edp7f(ctx contractapi.TransactionContextInterface) error {
-	stub := ctx.GetStub()
-
-	// 读取事件状态
-	event, err := s.ReadEvent(ctx, "EndEvent_08edp7f")
-	if err != nil {
-		return err
-	}
-
-	// 检查事件状态
-	if event.EventState != ENABLE {
-		errorMessage := fmt.Sprintf("Event state %s does not allowed", event.EventID)
-		fmt.Println(errorMessage)
-		return errors.New(errorMessage)
-	}
-
-	// 更新事件状态为DONE
-	event.EventState = DONE
-
-	// 序列化并保存事件状态
-	eventJSON, err := json.Marshal(event)
-	if err != nil {
-		fmt.Println(err.Error())
-		return err
-	}
-	err = stub.PutState("EndEvent_08edp7f", eventJSON)
-	if err != nil {
-		fmt.Println(err.Error())
-		return err
-	}
-
-	// 设置事件
-	err = stub.SetEvent("EndEvent_08edp7f", []byte("EndEvent_08edp7f has been done"))
-	if err != nil {
-		fmt.Println(err.Error())
-		return err
-	}
-
-	return nil
-}
-
-func (s *SmartContract) EndEvent_146eii4(ctx contractapi.TransactionContextInterface) error {
-	stub := ctx.GetStub()
-
-	// 读取事件状态
-	event, err := s.ReadEvent(ctx, "EndEvent_146eii4")
-	if err != nil {
-		return err
-	}
-
-	// 检查事件状态
-	if event.EventState != ENABLE {
-		errorMessage := fmt.Sprintf("Event state %s does not allowed", event.EventID)
-		fmt.Println(errorMessage)
-		return errors.New(errorMessage)
-	}
-
-	// 更新事件状态为DONE
-	event.EventState = DONE
-
-	// 序列化并保存事件状态
-	eventJSON, err := json.Marshal(event)
-	if err != nil {
-		fmt.Println(err.Error())
-		return err
-	}
-	err = stub.PutState("EndEvent_146eii4", eventJSON)
-	if err != nil {
-		fmt.Println(err.Error())
-		return err
-	}
-
-	// 设置事件
-	err = stub.SetEvent("EndEvent_146eii4", []byte("EndEvent_146eii4 has been done"))
-	if err != nil {
-		fmt.Println(err.Error())
-		return err
-	}
-
-	return nil
-}
-
-func (s *SmartContract) EndEvent_0366pfz(ctx contractapi.TransactionContextInterface) error {
-	stub := ctx.GetStub()
-
-	// 读取事件状态
-	event, err := s.ReadEvent(ctx, "EndEvent_0366pfz")
-	if err != nil {
-		return err
-	}
-
-	// 检查事件状态
-	if event.EventState != ENABLE {
-		errorMessage := fmt.Sprintf("Event state %s does not allowed", event.EventID)
-		fmt.Println(errorMessage)
-		return errors.New(errorMessage)
-	}
-
-	// 更新事件状态为DONE
-	event.EventState = DONE
-
-	// 序列化并保存事件状态
-	eventJSON, err := json.Marshal(event)
-	if err != nil {
-		fmt.Println(err.Error())
-		return err
-	}
-	err = stub.PutState("EndEvent_0366pfz", eventJSON)
-	if err != nil {
-		fmt.Println(err.Error())
-		return err
-	}
-
-	// 设置事件
-	err = stub.SetEvent("EndEvent_0366pfz", []byte("EndEvent_0366pfz has been done"))
-	if err != nil {
-		fmt.Println(err.Error())
+	if err := cc.putLedgerMetadata(ctx); err != nil {
 		return err
 	}
 
+	// 每次预订通过 CreateInstance 创建独立的实例
+	stub.SetEvent("initLedgerEvent", []byte("Contract has been initialized successfully"))
 	return nil
 }
@@ -0,0 +1,101 @@
+package chaincode
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// StateCache gives a transaction read-your-own-writes semantics on top of the chaincode stub.
+// Fabric only applies writes at commit time, so a GetState after a PutState of the same key
+// would otherwise return the value from before the transaction. Writes are kept in memory
+// and handed to the real stub once, by Flush, at the end of the transaction.
+// Range and composite key queries still only see committed state.
+type StateCache struct {
+	shim.ChaincodeStubInterface
+	writes  map[string][]byte
+	deleted map[string]bool
+}
+
+func NewStateCache(stub shim.ChaincodeStubInterface) *StateCache {
+	return &StateCache{
+		ChaincodeStubInterface: stub,
+		writes:                 make(map[string][]byte),
+		deleted:                make(map[string]bool),
+	}
+}
+
+func (c *StateCache) GetState(key string) ([]byte, error) {
+	if c.deleted[key] {
+		return nil, nil
+	}
+	if value, ok := c.writes[key]; ok {
+		return value, nil
+	}
+	return c.ChaincodeStubInterface.GetState(key)
+}
+
+func (c *StateCache) PutState(key string, value []byte) error {
+	if key == "" {
+		return errors.New("key must not be an empty string")
+	}
+	delete(c.deleted, key)
+	c.writes[key] = value
+	return nil
+}
+
+func (c *StateCache) DelState(key string) error {
+	if key == "" {
+		return errors.New("key must not be an empty string")
+	}
+	delete(c.writes, key)
+	c.deleted[key] = true
+	return nil
+}
+
+// Flush hands the pending writes to the underlying stub in key order
+func (c *StateCache) Flush() error {
+	keys := make([]string, 0, len(c.writes)+len(c.deleted))
+	for key := range c.writes {
+		keys = append(keys, key)
+	}
+	for key := range c.deleted {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		var err error
+		if c.deleted[key] {
+			err = c.ChaincodeStubInterface.DelState(key)
+		} else {
+			err = c.ChaincodeStubInterface.PutState(key, c.writes[key])
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	c.writes = make(map[string][]byte)
+	c.deleted = make(map[string]bool)
+	return nil
+}
+
+// TransactionContext wraps the stub of every transaction in a StateCache
+type TransactionContext struct {
+	contractapi.TransactionContext
+}
+
+func (ctx *TransactionContext) SetStub(stub shim.ChaincodeStubInterface) {
+	ctx.TransactionContext.SetStub(NewStateCache(stub))
+}
+
+// flushStateCache runs after every transaction and writes the cached state to the ledger
+func flushStateCache(ctx contractapi.TransactionContextInterface) error {
+	if cache, ok := ctx.GetStub().(*StateCache); ok {
+		return cache.Flush()
+	}
+	return nil
+}
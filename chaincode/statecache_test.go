@@ -0,0 +1,123 @@
+package chaincode
+
+import (
+	"testing"
+
+	"chaincode-go-bpmn/chaincode/mocks"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/stretchr/testify/require"
+)
+
+// newPeerStub behaves like a peer: reads only see what earlier transactions committed.
+// endTransaction commits or discards the writes of the current transaction.
+func newPeerStub() (*mocks.ChaincodeStub, func(commit bool)) {
+	committed := make(map[string][]byte)
+	pending := make(map[string][]byte)
+	chaincodeStub := &mocks.ChaincodeStub{}
+	chaincodeStub.GetStateStub = func(key string) ([]byte, error) {
+		return committed[key], nil
+	}
+	chaincodeStub.PutStateStub = func(key string, value []byte) error {
+		pending[key] = value
+		return nil
+	}
+	chaincodeStub.DelStateStub = func(key string) error {
+		pending[key] = nil
+		return nil
+	}
+	chaincodeStub.CreateCompositeKeyStub = shim.CreateCompositeKey
+	chaincodeStub.GetTxIDReturns("tx1")
+
+	endTransaction := func(commit bool) {
+		if commit {
+			for key, value := range pending {
+				if value == nil {
+					delete(committed, key)
+				} else {
+					committed[key] = value
+				}
+			}
+		}
+		pending = make(map[string][]byte)
+	}
+	return chaincodeStub, endTransaction
+}
+
+func TestStateCache(t *testing.T) {
+	chaincodeStub, endTransaction := newPeerStub()
+	cache := NewStateCache(chaincodeStub)
+
+	require.NoError(t, cache.PutState("a", []byte("1")))
+	value, err := cache.GetState("a")
+	require.NoError(t, err)
+	require.Equal(t, []byte("1"), value)
+	require.Equal(t, 0, chaincodeStub.PutStateCallCount())
+
+	require.NoError(t, cache.PutState("a", []byte("2")))
+	require.NoError(t, cache.PutState("b", []byte("3")))
+	require.NoError(t, cache.Flush())
+	endTransaction(true)
+	require.Equal(t, 2, chaincodeStub.PutStateCallCount())
+	key, value := chaincodeStub.PutStateArgsForCall(0)
+	require.Equal(t, "a", key)
+	require.Equal(t, []byte("2"), value)
+
+	require.NoError(t, cache.DelState("a"))
+	value, err = cache.GetState("a")
+	require.NoError(t, err)
+	require.Nil(t, value)
+	value, err = chaincodeStub.GetState("a")
+	require.NoError(t, err)
+	require.Equal(t, []byte("2"), value)
+
+	require.NoError(t, cache.Flush())
+	endTransaction(true)
+	require.Equal(t, 1, chaincodeStub.DelStateCallCount())
+
+	require.EqualError(t, cache.PutState("", nil), "key must not be an empty string")
+}
+
+func TestChainedActivationOnPeer(t *testing.T) {
+	chaincodeStub, endTransaction := newPeerStub()
+	identity := &mocks.ClientIdentity{}
+	identity.GetIDReturns(adminID, nil)
+	identity.GetMSPIDReturns(hotelMsp, nil)
+
+	cc := NewSmartContract()
+	invoke := func(fn func(ctx contractapi.TransactionContextInterface) error) error {
+		ctx := new(TransactionContext)
+		ctx.SetStub(chaincodeStub)
+		ctx.SetClientIdentity(identity)
+		err := fn(ctx)
+		if err == nil {
+			err = flushStateCache(ctx)
+		}
+		endTransaction(err == nil)
+		return err
+	}
+
+	var instanceID string
+	require.NoError(t, invoke(cc.InitLedger))
+	require.NoError(t, invoke(func(ctx contractapi.TransactionContextInterface) (err error) {
+		instanceID, err = cc.CreateInstance(ctx, hotelBooking, bindings)
+		return err
+	}))
+
+	// without the cache the merge gateway re-reads its own activation as DISABLE
+	plain := &mocks.TransactionContext{}
+	plain.GetStubReturns(chaincodeStub)
+	require.EqualError(t, cc.StartChoreography(plain, instanceID), "Gateway state ExclusiveGateway_0hs3ztq is not allowed")
+	endTransaction(false)
+
+	require.NoError(t, invoke(func(ctx contractapi.TransactionContextInterface) error {
+		return cc.StartChoreography(ctx, instanceID)
+	}))
+	require.NoError(t, invoke(func(ctx contractapi.TransactionContextInterface) error {
+		requireMsgState(t, cc, ctx, instanceID, "Message_045i10y", ENABLE)
+		gtw, err := cc.ReadGtw(ctx, instanceID, "ExclusiveGateway_0hs3ztq")
+		require.NoError(t, err)
+		require.Equal(t, ElementState(DONE), gtw.GatewayState)
+		return nil
+	}))
+}
@@ -0,0 +1,188 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const worklistObjectType = "worklist~msp~instance~message"
+
+// WorkItem is a message an organization has to act on
+type WorkItem struct {
+	InstanceID  string       `json:"instanceID"`
+	MessageID   string       `json:"messageID"`
+	MsgState    ElementState `json:"msgState"`
+	Transaction string       `json:"transaction"`
+	Parameters  []string     `json:"parameters"`
+	Format      string       `json:"format"`
+}
+
+// workItem returns the MSP that has to act on a message in the given state and what it has to call
+func workItem(instanceID string, msg *Message, state ElementState) (string, *WorkItem) {
+	switch state {
+	case ENABLE:
+		return msg.SendMspID, &WorkItem{
+			InstanceID:  instanceID,
+			MessageID:   msg.MessageID,
+			MsgState:    state,
+			Transaction: "SendMessage",
+			Parameters:  []string{"instanceID", "messageID", "fireflyTranID", "payloadJSON", "payloadHash"},
+			Format:      msg.Format,
+		}
+	case WAITFORCONFIRM:
+		return msg.ReceiveMspID, &WorkItem{
+			InstanceID:  instanceID,
+			MessageID:   msg.MessageID,
+			MsgState:    state,
+			Transaction: "ConfirmMessage",
+			Parameters:  []string{"instanceID", "messageID", "payloadHash"},
+			Format:      msg.Format,
+		}
+	}
+	return "", nil
+}
+
+// workItemMsps returns the MSPs that have to act on a message in the given state. A message
+// whose sender binds itself on its first send is offered to every candidate of the sender.
+func (cc *SmartContract) workItemMsps(ctx contractapi.TransactionContextInterface, instanceID string, msg *Message, state ElementState) ([]string, *WorkItem, error) {
+	mspID, item := workItem(instanceID, msg, state)
+	if item == nil {
+		return nil, nil, nil
+	}
+	if mspID != "" {
+		return []string{mspID}, item, nil
+	}
+	if state != ENABLE {
+		return nil, item, nil
+	}
+
+	_, chor, err := cc.readInstance(ctx, instanceID)
+	if err != nil {
+		return nil, nil, err
+	}
+	binding, err := cc.ReadParticipant(ctx, instanceID, chor.Messages[msg.MessageID].SendParticipant)
+	if err != nil {
+		return nil, nil, err
+	}
+	if binding.BindBy != SelfBinding {
+		return nil, item, nil
+	}
+	return binding.Candidates, item, nil
+}
+
+// updateWorklist moves the work item of a message after its state changed from previous
+func (cc *SmartContract) updateWorklist(ctx contractapi.TransactionContextInterface, instanceID string, msg *Message, previous ElementState) error {
+	if previous == msg.MsgState {
+		return nil
+	}
+	if err := cc.deleteWorkItems(ctx, instanceID, msg, previous); err != nil {
+		return err
+	}
+	return cc.putWorkItem(ctx, instanceID, msg)
+}
+
+// deleteWorkItems removes the work items of a message in the given state
+func (cc *SmartContract) deleteWorkItems(ctx contractapi.TransactionContextInterface, instanceID string, msg *Message, state ElementState) error {
+	mspIDs, _, err := cc.workItemMsps(ctx, instanceID, msg, state)
+	if err != nil {
+		return err
+	}
+	for _, mspID := range mspIDs {
+		key, err := ctx.GetStub().CreateCompositeKey(worklistObjectType, []string{mspID, instanceID, msg.MessageID})
+		if err != nil {
+			return err
+		}
+		if err := ctx.GetStub().DelState(key); err != nil {
+			return fmt.Errorf("删除待办事项时出错: %v", err)
+		}
+	}
+	return nil
+}
+
+// putWorkItem adds the work item of a message in its current state. Messages of
+// participants that are not bound yet get theirs once the participant is bound.
+func (cc *SmartContract) putWorkItem(ctx contractapi.TransactionContextInterface, instanceID string, msg *Message) error {
+	mspIDs, item, err := cc.workItemMsps(ctx, instanceID, msg, msg.MsgState)
+	if err != nil || item == nil {
+		return err
+	}
+	itemJSON, err := json.Marshal(item)
+	if err != nil {
+		return fmt.Errorf("序列化待办事项时出错: %v", err)
+	}
+	for _, mspID := range mspIDs {
+		key, err := ctx.GetStub().CreateCompositeKey(worklistObjectType, []string{mspID, instanceID, msg.MessageID})
+		if err != nil {
+			return err
+		}
+		if err := ctx.GetStub().PutState(key, itemJSON); err != nil {
+			return fmt.Errorf("保存待办事项时出错: %v", err)
+		}
+	}
+	return nil
+}
+
+// withdrawWorklist removes the work items of every message of a suspended or cancelled
+// instance, restoreWorklist adds them again when it is resumed
+func (cc *SmartContract) withdrawWorklist(ctx contractapi.TransactionContextInterface, instanceID string, chor *Choreography) error {
+	for _, messageID := range sortedMessageIDs(chor) {
+		msg, err := cc.ReadMsg(ctx, instanceID, messageID)
+		if err != nil {
+			return err
+		}
+		if err := cc.deleteWorkItems(ctx, instanceID, msg, msg.MsgState); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (cc *SmartContract) restoreWorklist(ctx contractapi.TransactionContextInterface, instanceID string, chor *Choreography) error {
+	for _, messageID := range sortedMessageIDs(chor) {
+		msg, err := cc.ReadMsg(ctx, instanceID, messageID)
+		if err != nil {
+			return err
+		}
+		if err := cc.putWorkItem(ctx, instanceID, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetMyWorklist returns the messages the caller's organization has to send or confirm,
+// across all instances or, if instanceID is not empty, in a single instance
+func (cc *SmartContract) GetMyWorklist(ctx contractapi.TransactionContextInterface, instanceID string) ([]*WorkItem, error) {
+	clientMspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, err
+	}
+	attributes := []string{clientMspID}
+	if instanceID != "" {
+		attributes = append(attributes, instanceID)
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(worklistObjectType, attributes)
+	if err != nil {
+		return nil, fmt.Errorf("获取状态数据时出错: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	items := []*WorkItem{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("迭代状态数据时出错: %v", err)
+		}
+
+		var item WorkItem
+		if err := json.Unmarshal(queryResponse.Value, &item); err != nil {
+			return nil, fmt.Errorf("反序列化待办事项时出错: %v", err)
+		}
+		items = append(items, &item)
+	}
+
+	return items, nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"chaincode-go-bpmn/chaincode"
+)
+
+// collectionConfig is one entry of collections_config.json, as read by
+// peer lifecycle chaincode approveformyorg --collections-config
+type collectionConfig struct {
+	Name              string            `json:"name"`
+	Policy            string            `json:"policy"`
+	RequiredPeerCount int               `json:"requiredPeerCount"`
+	MaxPeerCount      int               `json:"maxPeerCount"`
+	BlockToLive       int               `json:"blockToLive"`
+	MemberOnlyRead    bool              `json:"memberOnlyRead"`
+	MemberOnlyWrite   bool              `json:"memberOnlyWrite"`
+	EndorsementPolicy endorsementPolicy `json:"endorsementPolicy"`
+}
+
+type endorsementPolicy struct {
+	SignaturePolicy string `json:"signaturePolicy"`
+}
+
+// CollectionOptions controls how many peers a private payload is copied to. The endorsing
+// peer only endorses SendMessage once RequiredPeerCount other peers hold the payload, so
+// it is not lost with the endorsing peer.
+type CollectionOptions struct {
+	RequiredPeerCount int
+	MaxPeerCount      int
+}
+
+// DefaultCollectionOptions copies every payload to at least one other peer
+var DefaultCollectionOptions = CollectionOptions{RequiredPeerCount: 1, MaxPeerCount: 2}
+
+// mspCandidates lists the MSP IDs a participant may be bound to. In -bindings it is either a
+// single MSP ID or, for a participant bound late, the array of its candidates.
+type mspCandidates []string
+
+func (c *mspCandidates) UnmarshalJSON(data []byte) error {
+	var mspID string
+	if err := json.Unmarshal(data, &mspID); err == nil {
+		*c = mspCandidates{mspID}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(c))
+}
+
+// GenerateCollections renders collections_config.json with one collection for every pair of
+// organizations that may exchange a message, named like chaincode.PairCollection does.
+// bindings maps the participants of the choreography, by BPMN ID or name, to their candidate
+// MSP IDs, so that a participant bound late at CreateInstance or BindParticipant finds the
+// collection of whichever candidate it ends up with.
+func GenerateCollections(chor *chaincode.Choreography, bindings map[string]mspCandidates, opts CollectionOptions) ([]byte, error) {
+	if opts.RequiredPeerCount < 1 {
+		return nil, fmt.Errorf("requiredPeerCount %d does not copy the private payloads to any other peer", opts.RequiredPeerCount)
+	}
+	if opts.MaxPeerCount < opts.RequiredPeerCount {
+		return nil, fmt.Errorf("maxPeerCount %d is lower than requiredPeerCount %d", opts.MaxPeerCount, opts.RequiredPeerCount)
+	}
+
+	collections := make(map[string]*collectionConfig)
+	for _, def := range chor.Messages {
+		var candidates [2]mspCandidates
+		for i, participantID := range []string{def.SendParticipant, def.ReceiveParticipant} {
+			mspIDs, ok := bindings[participantID]
+			if !ok {
+				mspIDs = bindings[chor.Participants[participantID].Name]
+			}
+			if len(mspIDs) == 0 {
+				return nil, fmt.Errorf("participant %s is not bound to an MSP", participantID)
+			}
+			candidates[i] = mspIDs
+		}
+
+		for _, sendMsp := range candidates[0] {
+			for _, receiveMsp := range candidates[1] {
+				addCollection(collections, sendMsp, receiveMsp, opts)
+			}
+		}
+	}
+
+	names := make([]string, 0, len(collections))
+	for name := range collections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	configs := make([]*collectionConfig, 0, len(names))
+	for _, name := range names {
+		configs = append(configs, collections[name])
+	}
+
+	configJSON, err := json.MarshalIndent(configs, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(configJSON, '\n'), nil
+}
+
+// addCollection adds the collection of a pair of organizations, unless it is already there
+func addCollection(collections map[string]*collectionConfig, mspA string, mspB string, opts CollectionOptions) {
+	msps := []string{mspA, mspB}
+	sort.Strings(msps)
+
+	name := chaincode.PairCollection(msps[0], msps[1])
+	if _, exists := collections[name]; exists {
+		return
+	}
+	policy := fmt.Sprintf("OR('%s.member', '%s.member')", msps[0], msps[1])
+	collections[name] = &collectionConfig{
+		Name:              name,
+		Policy:            policy,
+		RequiredPeerCount: opts.RequiredPeerCount,
+		MaxPeerCount:      opts.MaxPeerCount,
+		BlockToLive:       0,
+		MemberOnlyRead:    true,
+		MemberOnlyWrite:   true,
+		EndorsementPolicy: endorsementPolicy{SignaturePolicy: policy},
+	}
+}
@@ -0,0 +1,610 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"chaincode-go-bpmn/chaincode"
+)
+
+type Options struct {
+	Package       string
+	MocksImport   string
+	RuntimeImport string // package providing CompileFormat and NormalizePayloadHash
+}
+
+// Generate renders smartcontract.go and smartcontract_test.go for the choreography
+func Generate(chor *chaincode.Choreography, opts Options) (map[string][]byte, error) {
+	model, err := buildModel(chor, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string][]byte)
+	for name, tmpl := range map[string]*template.Template{
+		"smartcontract.go":      contractTemplate,
+		"smartcontract_test.go": testTemplate,
+	} {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, model); err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		src, err := format.Source(buf.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("%s: generated code does not compile: %v", name, err)
+		}
+		files[name] = src
+	}
+	return files, nil
+}
+
+type contractModel struct {
+	Package       string
+	MocksImport   string
+	RuntimeImport string
+	Choreography  string
+	StartEvent    string
+	Participants  []*participantModel
+	// ExampleBindings documents InitLedger, TestBindings is what the generated tests pass to it
+	ExampleBindings string
+	TestBindings    string
+	Fields          []*fieldModel
+	Messages        []*messageModel
+	Gateways        []*gatewayModel
+	Events          []*eventModel
+}
+
+type participantModel struct {
+	ID      string
+	Name    string
+	Role    string
+	TestMsp string // MSP ID bound in the generated tests
+}
+
+type fieldModel struct {
+	Name   string // name in the message format
+	GoName string // exported struct field
+	GoType string
+	Sample interface{} // test value
+}
+
+type messageModel struct {
+	ID          string
+	Method      string
+	Name        string
+	Format      string
+	Schema      bool   // Format is a JSON Schema
+	Send        string // BPMN participant IDs
+	Receive     string
+	SendMsp     string // MSP IDs bound to them in the generated tests
+	ReceiveMsp  string
+	Fields      []*fieldModel
+	Payload     string     // sample payload for the generated test
+	PayloadHash string     // its canonical hash
+	OtherHash   string     // hash of a different payload
+	Race        *raceModel // preceding event-based gateway
+	OnConfirm   string
+}
+
+// raceModel is the branch of an event-based gateway that the first message of a task starts
+type raceModel struct {
+	Gateway string
+	Flow    string
+	Message string
+	Others  []string // messages of the other branches
+}
+
+type gatewayModel struct {
+	ID       string
+	Method   string
+	Kind     chaincode.ElementType
+	Branches []branchModel
+	Default  string
+	All      []string
+}
+
+type branchModel struct {
+	Condition string
+	Variables string // quoted names of the fields the condition reads
+	Code      string
+}
+
+type eventModel struct {
+	ID     string
+	Method string
+	Start  bool
+	Next   string
+}
+
+var goTypes = map[chaincode.FieldType]string{
+	chaincode.StringField:  "string",
+	chaincode.AddressField: "string",
+	chaincode.BoolField:    "bool",
+	chaincode.IntField:     "int64",
+	chaincode.UintField:    "uint64",
+	chaincode.DecimalField: "json.Number",
+}
+
+var samples = map[chaincode.FieldType]interface{}{
+	chaincode.StringField:  "sample",
+	chaincode.AddressField: "0x5B38Da6a701c568545dCfcB03FcB875f56beddC4",
+	chaincode.BoolField:    true,
+	chaincode.IntField:     1,
+	chaincode.UintField:    1,
+	chaincode.DecimalField: "12.50",
+}
+
+func buildModel(chor *chaincode.Choreography, opts Options) (*contractModel, error) {
+	model := &contractModel{
+		Package:       opts.Package,
+		MocksImport:   opts.MocksImport,
+		RuntimeImport: opts.RuntimeImport,
+		Choreography:  chor.ChoreographyID,
+	}
+
+	participantIDs := make([]string, 0, len(chor.Participants))
+	for id := range chor.Participants {
+		participantIDs = append(participantIDs, id)
+	}
+	sort.Strings(participantIDs)
+	testMsps := make(map[string]string)
+	example := make(map[string]string)
+	for i, id := range participantIDs {
+		p := chor.Participants[id]
+		testMsps[id] = fmt.Sprintf("Org%dMSP", i+1)
+		example[id] = testMsps[id]
+		model.Participants = append(model.Participants, &participantModel{
+			ID:      id,
+			Name:    p.Name,
+			Role:    strings.ToLower(p.Name),
+			TestMsp: testMsps[id],
+		})
+	}
+	if len(model.Participants) == 0 {
+		return nil, fmt.Errorf("choreography %s has no participants", chor.ChoreographyID)
+	}
+	exampleJSON, err := json.Marshal(example)
+	if err != nil {
+		return nil, err
+	}
+	model.ExampleBindings = string(exampleJSON)
+	model.TestBindings = string(exampleJSON)
+
+	// decision tables live on the ledger of the generic engine only
+	if refs := chor.DecisionRefs(); len(refs) > 0 {
+		referencing := make([]string, 0, len(refs))
+		for id := range refs {
+			referencing = append(referencing, id)
+		}
+		sort.Strings(referencing)
+		id := referencing[0]
+		return nil, fmt.Errorf("decision %s referenced by %s is not supported, deploy the model with DeployChoreography instead", refs[id], id)
+	}
+
+	fields := make(map[string]*fieldModel)
+	elementIDs := make([]string, 0, len(chor.Elements))
+	for id := range chor.Elements {
+		elementIDs = append(elementIDs, id)
+	}
+	sort.Strings(elementIDs)
+
+	for _, id := range elementIDs {
+		el := chor.Elements[id]
+		switch el.Type {
+		case chaincode.StartEventElement, chaincode.EndEventElement:
+			ev := &eventModel{ID: id, Method: unexported(id), Start: el.Type == chaincode.StartEventElement}
+			if ev.Start {
+				// start events are the only events triggered by a client
+				ev.Method = identifier(id)
+				if model.StartEvent == "" {
+					model.StartEvent = id
+				}
+				ev.Next = activation(chor, chor.Flows[el.Outgoing[0]].TargetRef)
+			}
+			model.Events = append(model.Events, ev)
+
+		case chaincode.ChoreographyTaskElement:
+			for i, messageID := range el.Messages {
+				def := chor.Messages[messageID]
+				msg := &messageModel{
+					ID:         messageID,
+					Method:     identifier(messageID),
+					Name:       def.Name,
+					Format:     def.Format,
+					Schema:     chaincode.IsSchemaFormat(def.Format),
+					Send:       def.SendParticipant,
+					Receive:    def.ReceiveParticipant,
+					SendMsp:    testMsps[def.SendParticipant],
+					ReceiveMsp: testMsps[def.ReceiveParticipant],
+				}
+				var msgFields []*fieldModel
+				var err error
+				if msg.Schema {
+					msgFields, msg.Payload, err = schemaFields(def.Format)
+				} else {
+					msgFields, err = parseFields(def.Format)
+				}
+				if err != nil {
+					return nil, fmt.Errorf("message %s: %v", messageID, err)
+				}
+				for _, f := range msgFields {
+					if existing, ok := fields[f.Name]; ok && existing.GoType != f.GoType {
+						return nil, fmt.Errorf("field %s is declared as %s and %s", f.Name, existing.GoType, f.GoType)
+					}
+					fields[f.Name] = f
+				}
+				msg.Fields = msgFields
+				if msg.Payload == "" {
+					if msg.Payload, err = samplePayload(msgFields); err != nil {
+						return nil, err
+					}
+				}
+				if msg.PayloadHash, err = chaincode.CanonicalPayloadHash(msg.Payload); err != nil {
+					return nil, fmt.Errorf("message %s: %v", messageID, err)
+				}
+				if msg.OtherHash, err = chaincode.CanonicalPayloadHash(`{"unknown":1}`); err != nil {
+					return nil, err
+				}
+
+				if i == 0 {
+					msg.Race = race(chor, id)
+				}
+				if i+1 < len(el.Messages) {
+					msg.OnConfirm = fmt.Sprintf("return cc.changeMsgState(ctx, %q, ENABLE)", el.Messages[i+1])
+				} else {
+					msg.OnConfirm = activation(chor, chor.Flows[el.Outgoing[0]].TargetRef)
+				}
+				model.Messages = append(model.Messages, msg)
+			}
+
+		case chaincode.ParallelGatewayElement, chaincode.InclusiveGatewayElement:
+			// a join counts tokens arriving in earlier transactions and in its own,
+			// which the generated contract cannot read back without a state cache
+			return nil, fmt.Errorf("%s %s is not supported, deploy the model with DeployChoreography instead", gatewayKinds[el.Type], id)
+
+		case chaincode.ExclusiveGatewayElement, chaincode.EventBasedGatewayElement:
+			gtw := &gatewayModel{ID: id, Method: unexported(id), Kind: el.Type}
+			for _, flowID := range el.Outgoing {
+				flow := chor.Flows[flowID]
+				code := activation(chor, flow.TargetRef)
+				if el.Type == chaincode.EventBasedGatewayElement {
+					gtw.All = append(gtw.All, strings.TrimPrefix(code, "return "))
+					continue
+				}
+				if flowID == el.Default || len(el.Outgoing) == 1 {
+					gtw.Default = code
+					continue
+				}
+				gtw.Branches = append(gtw.Branches, branchModel{Condition: flow.Condition, Code: code})
+			}
+			model.Gateways = append(model.Gateways, gtw)
+		}
+	}
+
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		model.Fields = append(model.Fields, fields[name])
+	}
+
+	// conditions can only be translated once every field is known
+	for _, gtw := range model.Gateways {
+		for i := range gtw.Branches {
+			cond, variables, err := translateCondition(gtw.Branches[i].Condition, fields)
+			if err != nil {
+				return nil, fmt.Errorf("gateway %s: %v", gtw.ID, err)
+			}
+			gtw.Branches[i].Condition = cond
+			gtw.Branches[i].Variables = variables
+		}
+	}
+
+	return model, nil
+}
+
+var gatewayKinds = map[chaincode.ElementType]string{
+	chaincode.ParallelGatewayElement:  "parallel gateway",
+	chaincode.InclusiveGatewayElement: "inclusive gateway",
+}
+
+// activation returns the statement that hands the token over to an element
+func activation(chor *chaincode.Choreography, elementID string) string {
+	el := chor.Elements[elementID]
+	switch el.Type {
+	case chaincode.ChoreographyTaskElement:
+		return fmt.Sprintf("return cc.changeMsgState(ctx, %q, ENABLE)", el.Messages[0])
+	default:
+		return fmt.Sprintf("return cc.%s(ctx)", unexported(elementID))
+	}
+}
+
+// race returns the event-based gateway branch the given task is on, if any
+func race(chor *chaincode.Choreography, taskID string) *raceModel {
+	task := chor.Elements[taskID]
+	for _, inID := range task.Incoming {
+		gtw := chor.Elements[chor.Flows[inID].SourceRef]
+		if gtw.Type != chaincode.EventBasedGatewayElement {
+			continue
+		}
+		r := &raceModel{Gateway: gtw.ElementID, Flow: inID, Message: task.Messages[0]}
+		for _, outID := range gtw.Outgoing {
+			if target := chor.Flows[outID].TargetRef; target != taskID {
+				r.Others = append(r.Others, chor.Elements[target].Messages...)
+			}
+		}
+		return r
+	}
+	return nil
+}
+
+func parseFields(formatString string) ([]*fieldModel, error) {
+	messageFormat, err := chaincode.ParseFormat(formatString)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields []*fieldModel
+	for _, f := range messageFormat {
+		field := &fieldModel{
+			Name:   f.Name,
+			GoName: exported(f.Name),
+			GoType: goTypes[f.Type],
+			Sample: samples[f.Type],
+		}
+		if f.Array {
+			field.GoType = "[]" + field.GoType
+			field.Sample = []interface{}{field.Sample}
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// schemaTypes maps the JSON Schema types of top level properties, anything else is kept as raw JSON
+var schemaTypes = map[string]chaincode.FieldType{
+	"string":  chaincode.StringField,
+	"integer": chaincode.IntField,
+	"number":  chaincode.DecimalField,
+	"boolean": chaincode.BoolField,
+}
+
+// schemaFields turns the top level properties of a JSON Schema into fields. The sample
+// payload is the first of the schema's "examples", which nested properties require.
+func schemaFields(schemaString string) ([]*fieldModel, string, error) {
+	var schema struct {
+		Properties map[string]struct {
+			Type interface{} `json:"type"`
+		} `json:"properties"`
+		Examples []json.RawMessage `json:"examples"`
+	}
+	if err := json.Unmarshal([]byte(schemaString), &schema); err != nil {
+		return nil, "", err
+	}
+
+	names := make([]string, 0, len(schema.Properties))
+	for name := range schema.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var fields []*fieldModel
+	nested := ""
+	for _, name := range names {
+		field := &fieldModel{Name: name, GoName: exported(name), GoType: "json.RawMessage"}
+		typeName, _ := schema.Properties[name].Type.(string)
+		if fieldType, ok := schemaTypes[typeName]; ok {
+			field.GoType = goTypes[fieldType]
+			field.Sample = samples[fieldType]
+		} else if nested == "" {
+			nested = name
+		}
+		fields = append(fields, field)
+	}
+
+	if len(schema.Examples) > 0 {
+		return fields, string(schema.Examples[0]), nil
+	}
+	if nested != "" {
+		return nil, "", fmt.Errorf("property %s is not a primitive type, add an example payload to the schema", nested)
+	}
+	return fields, "", nil
+}
+
+func samplePayload(fields []*fieldModel) (string, error) {
+	payload := make(map[string]interface{})
+	for _, f := range fields {
+		payload[f.Name] = f.Sample
+	}
+	payloadJSON, err := json.Marshal(payload)
+	return string(payloadJSON), err
+}
+
+// translateCondition turns "quotation <= 500 and confirm = true" into
+// "memory.Quotation <= 500 && memory.Confirm == true" along with the fields it reads, `"confirm", "quotation"`
+func translateCondition(condition string, fields map[string]*fieldModel) (string, string, error) {
+	expr, err := chaincode.ParseExpression(condition)
+	if err != nil {
+		return "", "", fmt.Errorf("condition %q: %v", condition, err)
+	}
+	t := &conditionTranslator{condition: condition, fields: fields}
+	code, goType, err := t.translate(expr)
+	if err != nil {
+		return "", "", err
+	}
+	if goType != "bool" {
+		return "", "", fmt.Errorf("condition %q is not a boolean", condition)
+	}
+	var names []string
+	for _, name := range expr.Variables() {
+		names = append(names, strconv.Quote(name))
+	}
+	return code, strings.Join(names, ", "), nil
+}
+
+type conditionTranslator struct {
+	condition string
+	fields    map[string]*fieldModel
+}
+
+// numberLiteral is the type of a number in a condition, it takes the type of the field it is compared with
+const numberLiteral = "number"
+
+// translate returns the Go code of an expression and its Go type
+func (t *conditionTranslator) translate(expr *chaincode.Expression) (string, string, error) {
+	switch expr.Op {
+	case "":
+		if expr.Name != "" {
+			f, ok := t.fields[expr.Name]
+			if !ok {
+				return "", "", fmt.Errorf("condition %q refers to unknown field %s", t.condition, expr.Name)
+			}
+			return "memory." + f.GoName, f.GoType, nil
+		}
+		switch v := expr.Value.(type) {
+		case json.Number:
+			return v.String(), numberLiteral, nil
+		case string:
+			return strconv.Quote(v), "string", nil
+		}
+		return fmt.Sprint(expr.Value), "bool", nil
+	case chaincode.NotOp:
+		operand := expr.Operands[0]
+		code, err := t.boolean(operand, operand.Op != "")
+		return "!" + code, "bool", err
+	case chaincode.AndOp, chaincode.OrOp:
+		goOp := "&&"
+		if expr.Op == chaincode.OrOp {
+			goOp = "||"
+		}
+		// && 优先于 ||，只有 and 中的 or 需要括号
+		var codes []string
+		for _, operand := range expr.Operands {
+			code, err := t.boolean(operand, expr.Op == chaincode.AndOp && operand.Op == chaincode.OrOp)
+			if err != nil {
+				return "", "", err
+			}
+			codes = append(codes, code)
+		}
+		return strings.Join(codes, " "+goOp+" "), "bool", nil
+	}
+
+	var codes, goTypes []string
+	for _, operand := range expr.Operands {
+		code, goType, err := t.translate(operand)
+		if err != nil {
+			return "", "", err
+		}
+		if operand.Op != "" {
+			code = "(" + code + ")"
+		}
+		codes = append(codes, code)
+		goTypes = append(goTypes, goType)
+	}
+	goType, err := t.comparable(expr, goTypes[0], goTypes[1])
+	if err != nil {
+		return "", "", err
+	}
+	goOp := expr.Op
+	switch {
+	case goType == "bool" && goOp != "=" && goOp != "!=":
+		return "", "", fmt.Errorf("condition %q: booleans can not be compared with %s", t.condition, goOp)
+	case goOp == "=":
+		goOp = "=="
+	}
+	return codes[0] + " " + goOp + " " + codes[1], "bool", nil
+}
+
+// boolean translates an operand of "and", "or" or "not"
+func (t *conditionTranslator) boolean(expr *chaincode.Expression, parenthesize bool) (string, error) {
+	code, goType, err := t.translate(expr)
+	if err != nil {
+		return "", err
+	}
+	if goType != "bool" {
+		return "", fmt.Errorf("condition %q: %s is not a boolean", t.condition, operandText(expr))
+	}
+	if parenthesize {
+		code = "(" + code + ")"
+	}
+	return code, nil
+}
+
+// comparable checks the two sides of a comparison against each other and returns their type
+func (t *conditionTranslator) comparable(expr *chaincode.Expression, leftType string, rightType string) (string, error) {
+	left, right := expr.Operands[0], expr.Operands[1]
+	if leftType == numberLiteral {
+		left, right = right, left
+		leftType, rightType = rightType, leftType
+	}
+
+	switch {
+	case leftType == "json.Number" || rightType == "json.Number":
+		name := left.Name
+		if rightType == "json.Number" {
+			name = right.Name
+		}
+		return "", fmt.Errorf("condition %q: decimal field %s can not be compared", t.condition, name)
+	case leftType == "int64" || leftType == "uint64":
+		if rightType == leftType {
+			return leftType, nil
+		}
+		if rightType != numberLiteral {
+			return "", fmt.Errorf("condition %q: %s is not a number", t.condition, operandText(right))
+		}
+		n := right.Value.(json.Number).String()
+		if _, err := strconv.ParseInt(n, 10, 64); leftType == "int64" && err != nil {
+			return "", fmt.Errorf("condition %q: %s is not an int", t.condition, n)
+		}
+		if _, err := strconv.ParseUint(n, 10, 64); leftType == "uint64" && err != nil {
+			return "", fmt.Errorf("condition %q: %s is not a uint", t.condition, n)
+		}
+		return leftType, nil
+	case leftType == "string" || leftType == "bool" || leftType == numberLiteral:
+		if rightType != leftType {
+			return "", fmt.Errorf("condition %q: %s is not a %s", t.condition, operandText(right), leftType)
+		}
+		return leftType, nil
+	}
+	return "", fmt.Errorf("condition %q: field %s of type %s can not be compared", t.condition, left.Name, leftType)
+}
+
+// operandText renders an operand of a condition for an error message
+func operandText(expr *chaincode.Expression) string {
+	switch v := expr.Value.(type) {
+	case string:
+		return strconv.Quote(v)
+	case nil:
+		return expr.Name
+	}
+	return fmt.Sprint(expr.Value)
+}
+
+var nonIdentifier = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+func identifier(id string) string {
+	id = nonIdentifier.ReplaceAllString(id, "_")
+	if id == "" || !(id[0] >= 'A' && id[0] <= 'Z' || id[0] >= 'a' && id[0] <= 'z') {
+		id = "X" + id
+	}
+	return strings.ToUpper(id[:1]) + id[1:]
+}
+
+func exported(name string) string {
+	return identifier(name)
+}
+
+// unexported names the internal method that runs a gateway or end event
+func unexported(id string) string {
+	m := identifier(id)
+	return strings.ToLower(m[:1]) + m[1:]
+}
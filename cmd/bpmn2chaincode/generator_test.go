@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"chaincode-go-bpmn/chaincode"
+	"github.com/stretchr/testify/require"
+)
+
+func generateHotelBooking(t *testing.T) map[string][]byte {
+	data, err := os.ReadFile("../../chaincode/bpmn/hotel_booking.bpmn")
+	require.NoError(t, err)
+	chor, err := chaincode.ParseChoreography(data)
+	require.NoError(t, err)
+
+	files, err := Generate(chor, Options{Package: "hotelbooking", MocksImport: "chaincode-go-bpmn/chaincode/mocks", RuntimeImport: "chaincode-go-bpmn/chaincode"})
+	require.NoError(t, err)
+	return files
+}
+
+func TestGenerate(t *testing.T) {
+	files := generateHotelBooking(t)
+	require.Len(t, files, 2)
+
+	fset := token.NewFileSet()
+	for name, src := range files {
+		_, err := parser.ParseFile(fset, name, src, parser.AllErrors)
+		require.NoError(t, err, name)
+	}
+
+	src := string(files["smartcontract.go"])
+	require.Contains(t, src, "func (cc *SmartContract) StartEvent_1jtgn3j(ctx contractapi.TransactionContextInterface) error")
+	require.Contains(t, src, "func (cc *SmartContract) Message_045i10y_Send(ctx contractapi.TransactionContextInterface, fireflyTranID string, payloadJSON string, payloadHash string) error")
+	require.Contains(t, src, "func (cc *SmartContract) Message_045i10y_Confirm(ctx contractapi.TransactionContextInterface, payloadHash string) error")
+	require.Contains(t, src, "func (cc *SmartContract) exclusiveGateway_106je4z(ctx contractapi.TransactionContextInterface) error")
+	require.Contains(t, src, "func (cc *SmartContract) endEvent_0366pfz(ctx contractapi.TransactionContextInterface) error")
+	require.Contains(t, src, "// Check_room(string date, uint bedrooms)")
+	require.Contains(t, src, `"Message_045i10y", mspIDs["Participant_1080bkg"], mspIDs["Participant_0sktaei"], "", DISABLE, "date:string, bedrooms:uint"`)
+	require.Contains(t, src, `{ParticipantID: "Participant_0sktaei", Name: "Hotel", Role: "hotel"},`)
+	require.Contains(t, src, `if err := cc.authorize(ctx, "Participant_1080bkg"); err != nil {`)
+	require.Contains(t, src, "if memory.Confirm == true {")
+	require.Contains(t, src, "Bedrooms     uint64 `json:\"bedrooms\"`")
+	require.Contains(t, src, `cc.chooseAlternative(ctx, "EventBasedGateway_1fxpmyn", "SequenceFlow_0vbqdqk", "Message_0o8eyir", "Message_1xm9dxy")`)
+	require.Contains(t, src, `cc.chooseAlternative(ctx, "EventBasedGateway_1fxpmyn", "SequenceFlow_1l0sbvx", "Message_1xm9dxy", "Message_0o8eyir", "Message_1ljlm4g")`)
+	require.Contains(t, src, `cc.openGateway(ctx, "EventBasedGateway_1fxpmyn")`)
+
+	test := string(files["smartcontract_test.go"])
+	require.Contains(t, test, "func TestMessage_045i10y(t *testing.T)")
+	require.Contains(t, test, "const participantBindings = `{\"Participant_0sktaei\":\"Org1MSP\",\"Participant_1080bkg\":\"Org2MSP\"}`")
+	require.Contains(t, test, "`{\"bedrooms\":1,\"date\":\"sample\"}`")
+}
+
+// unsetVariableTest runs inside the generated package, next to its generated tests
+const unsetVariableTest = `package hotelbooking
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnsetVariable(t *testing.T) {
+	ctx, _ := newWorldState()
+	cc := &SmartContract{}
+	require.NoError(t, cc.InitLedger(ctx, participantBindings))
+	require.NoError(t, cc.changeGtwState(ctx, "ExclusiveGateway_106je4z", ENABLE))
+	require.EqualError(t, cc.exclusiveGateway_106je4z(ctx), "variable confirm is not set")
+}
+`
+
+func TestGeneratedChaincodeBuilds(t *testing.T) {
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go tool not available")
+	}
+	files := generateHotelBooking(t)
+	files["unset_variable_test.go"] = []byte(unsetVariableTest)
+
+	// the package gets a module of its own that replaces chaincode-go-bpmn with this
+	// checkout, so it imports the runtime and the mocks without touching the source tree
+	root, err := filepath.Abs("../..")
+	require.NoError(t, err)
+	goMod, err := os.ReadFile(filepath.Join(root, "go.mod"))
+	require.NoError(t, err)
+	goSum, err := os.ReadFile(filepath.Join(root, "go.sum"))
+	require.NoError(t, err)
+	files["go.mod"] = []byte(strings.Replace(string(goMod), "module chaincode-go-bpmn\n", "module generated\n", 1) +
+		fmt.Sprintf("\nrequire chaincode-go-bpmn v0.0.0\n\nreplace chaincode-go-bpmn => %s\n", root))
+	files["go.sum"] = goSum
+
+	dir := t.TempDir()
+	for name, src := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), src, 0o644))
+	}
+
+	for _, args := range [][]string{{"vet", "."}, {"test", "-count=1", "."}} {
+		cmd := exec.Command(goTool, args...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		require.NoError(t, err, "go %s:\n%s", strings.Join(args, " "), out)
+	}
+}
+
+func TestGenerateJoinGateways(t *testing.T) {
+	data, err := os.ReadFile("../../chaincode/bpmn/check_in.bpmn")
+	require.NoError(t, err)
+	chor, err := chaincode.ParseChoreography(data)
+	require.NoError(t, err)
+
+	_, err = Generate(chor, Options{Package: "checkin"})
+	require.EqualError(t, err, "parallel gateway ParallelGateway_documents_fork is not supported, deploy the model with DeployChoreography instead")
+
+	data, err = os.ReadFile("../../chaincode/bpmn/extra_services.bpmn")
+	require.NoError(t, err)
+	chor, err = chaincode.ParseChoreography(data)
+	require.NoError(t, err)
+
+	_, err = Generate(chor, Options{Package: "extraservices"})
+	require.EqualError(t, err, "inclusive gateway InclusiveGateway_services is not supported, deploy the model with DeployChoreography instead")
+}
+
+func TestGenerateDecisionRefs(t *testing.T) {
+	data, err := os.ReadFile("../../chaincode/bpmn/hotel_booking.bpmn")
+	require.NoError(t, err)
+	bpmnXML := strings.Replace(string(data),
+		`<bpmn2:exclusiveGateway id="ExclusiveGateway_0nzwv7v" default="SequenceFlow_0d5x9xp">`,
+		`<bpmn2:exclusiveGateway id="ExclusiveGateway_0nzwv7v" default="SequenceFlow_0d5x9xp"><bpmn2:documentation textFormat="application/vnd.choreography-policy+json">{"decisionRef":"Decision_refund"}</bpmn2:documentation>`, 1)
+	chor, err := chaincode.ParseChoreography([]byte(bpmnXML))
+	require.NoError(t, err)
+
+	_, err = Generate(chor, Options{Package: "hotelbooking"})
+	require.EqualError(t, err, "decision Decision_refund referenced by ExclusiveGateway_0nzwv7v is not supported, deploy the model with DeployChoreography instead")
+}
+
+func TestTranslateCondition(t *testing.T) {
+	fields := map[string]*fieldModel{
+		"confirm":   {Name: "confirm", GoName: "Confirm", GoType: "bool"},
+		"quotation": {Name: "quotation", GoName: "Quotation", GoType: "uint64"},
+		"reason":    {Name: "reason", GoName: "Reason", GoType: "string"},
+	}
+
+	cond, variables, err := translateCondition("confirm", fields)
+	require.NoError(t, err)
+	require.Equal(t, "memory.Confirm", cond)
+	require.Equal(t, `"confirm"`, variables)
+
+	cond, variables, err = translateCondition("quotation <= 500 and confirm = true", fields)
+	require.NoError(t, err)
+	require.Equal(t, "memory.Quotation <= 500 && memory.Confirm == true", cond)
+	require.Equal(t, `"confirm", "quotation"`, variables)
+
+	cond, _, err = translateCondition(`(quotation > 500 or reason = "late") and not confirm`, fields)
+	require.NoError(t, err)
+	require.Equal(t, `(memory.Quotation > 500 || memory.Reason == "late") && !memory.Confirm`, cond)
+
+	cond, _, err = translateCondition("not (quotation >= 100)", fields)
+	require.NoError(t, err)
+	require.Equal(t, "!(memory.Quotation >= 100)", cond)
+
+	cond, _, err = translateCondition("quotation != 500", fields)
+	require.NoError(t, err)
+	require.Equal(t, "memory.Quotation != 500", cond)
+
+	cond, _, err = translateCondition(`reason == "late"`, fields)
+	require.NoError(t, err)
+	require.Equal(t, `memory.Reason == "late"`, cond)
+
+	_, _, err = translateCondition("cancel = true", fields)
+	require.EqualError(t, err, `condition "cancel = true" refers to unknown field cancel`)
+
+	_, _, err = translateCondition(`quotation = "high"`, fields)
+	require.True(t, strings.Contains(err.Error(), "is not a number"))
+
+	_, _, err = translateCondition("quotation = -1", fields)
+	require.EqualError(t, err, `condition "quotation = -1": -1 is not a uint`)
+
+	_, _, err = translateCondition("confirm < true", fields)
+	require.EqualError(t, err, `condition "confirm < true": booleans can not be compared with <`)
+
+	_, _, err = translateCondition("quotation and confirm", fields)
+	require.EqualError(t, err, `condition "quotation and confirm": quotation is not a boolean`)
+
+	_, _, err = translateCondition("quotation <=", fields)
+	require.EqualError(t, err, `condition "quotation <=": unexpected end of condition`)
+
+	fields["price"] = &fieldModel{Name: "price", GoName: "Price", GoType: "json.Number"}
+	_, _, err = translateCondition("price = 1", fields)
+	require.EqualError(t, err, `condition "price = 1": decimal field price can not be compared`)
+}
+
+func TestParseFields(t *testing.T) {
+	fields, err := parseFields("to:address, amount:decimal, rooms?:uint[]")
+	require.NoError(t, err)
+	require.Equal(t, []*fieldModel{
+		{Name: "to", GoName: "To", GoType: "string", Sample: "0x5B38Da6a701c568545dCfcB03FcB875f56beddC4"},
+		{Name: "amount", GoName: "Amount", GoType: "json.Number", Sample: "12.50"},
+		{Name: "rooms", GoName: "Rooms", GoType: "[]uint64", Sample: []interface{}{1}},
+	}, fields)
+
+	_, err = parseFields("id:bytes32")
+	require.EqualError(t, err, "field id has unknown type bytes32")
+}
+
+func TestSchemaFields(t *testing.T) {
+	fields, payload, err := schemaFields(`{"type":"object","properties":{"date":{"type":"string"},"nights":{"type":"integer"}}}`)
+	require.NoError(t, err)
+	require.Equal(t, []*fieldModel{
+		{Name: "date", GoName: "Date", GoType: "string", Sample: "sample"},
+		{Name: "nights", GoName: "Nights", GoType: "int64", Sample: 1},
+	}, fields)
+	require.Empty(t, payload)
+
+	schema := `{"type":"object","properties":{"date":{"type":"string"},"guests":{"type":"array"}},"examples":[{"date":"2024-05-01","guests":["Ada"]}]}`
+	fields, payload, err = schemaFields(schema)
+	require.NoError(t, err)
+	require.Equal(t, "json.RawMessage", fields[1].GoType)
+	require.Equal(t, `{"date":"2024-05-01","guests":["Ada"]}`, payload)
+
+	_, _, err = schemaFields(`{"type":"object","properties":{"guests":{"type":"array"}}}`)
+	require.EqualError(t, err, "property guests is not a primitive type, add an example payload to the schema")
+}
+
+func TestGenerateCollections(t *testing.T) {
+	data, err := os.ReadFile("../../chaincode/bpmn/hotel_booking.bpmn")
+	require.NoError(t, err)
+	chor, err := chaincode.ParseChoreography(data)
+	require.NoError(t, err)
+
+	config, err := GenerateCollections(chor, map[string]mspCandidates{"Participant_1080bkg": {"Org1MSP"}, "Hotel": {"Org2MSP"}}, DefaultCollectionOptions)
+	require.NoError(t, err)
+	require.JSONEq(t, `[{
+		"name": "pair-Org1MSP-Org2MSP",
+		"policy": "OR('Org1MSP.member', 'Org2MSP.member')",
+		"requiredPeerCount": 1,
+		"maxPeerCount": 2,
+		"blockToLive": 0,
+		"memberOnlyRead": true,
+		"memberOnlyWrite": true,
+		"endorsementPolicy": {"signaturePolicy": "OR('Org1MSP.member', 'Org2MSP.member')"}
+	}]`, string(config))
+
+	// a hotel bound late gets a collection with the client for each of its candidates
+	var bindings map[string]mspCandidates
+	require.NoError(t, json.Unmarshal([]byte(`{"Client":"Org1MSP","Hotel":["Org2MSP","Org3MSP"]}`), &bindings))
+	config, err = GenerateCollections(chor, bindings, DefaultCollectionOptions)
+	require.NoError(t, err)
+	var configs []collectionConfig
+	require.NoError(t, json.Unmarshal(config, &configs))
+	require.Len(t, configs, 2)
+	require.Equal(t, "pair-Org1MSP-Org2MSP", configs[0].Name)
+	require.Equal(t, "pair-Org1MSP-Org3MSP", configs[1].Name)
+	require.Equal(t, "OR('Org1MSP.member', 'Org3MSP.member')", configs[1].Policy)
+
+	_, err = GenerateCollections(chor, map[string]mspCandidates{"Participant_1080bkg": {"Org1MSP"}}, DefaultCollectionOptions)
+	require.EqualError(t, err, "participant Participant_0sktaei is not bound to an MSP")
+	_, err = GenerateCollections(chor, map[string]mspCandidates{"Participant_1080bkg": {"Org1MSP"}, "Hotel": {}}, DefaultCollectionOptions)
+	require.EqualError(t, err, "participant Participant_0sktaei is not bound to an MSP")
+
+	// the caller chooses how widely payloads are copied, but they have to leave the endorsing peer
+	config, err = GenerateCollections(chor, bindings, CollectionOptions{RequiredPeerCount: 2, MaxPeerCount: 3})
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(config, &configs))
+	require.Equal(t, 2, configs[0].RequiredPeerCount)
+	require.Equal(t, 3, configs[0].MaxPeerCount)
+	_, err = GenerateCollections(chor, bindings, CollectionOptions{RequiredPeerCount: 0, MaxPeerCount: 1})
+	require.EqualError(t, err, "requiredPeerCount 0 does not copy the private payloads to any other peer")
+	_, err = GenerateCollections(chor, bindings, CollectionOptions{RequiredPeerCount: 2, MaxPeerCount: 1})
+	require.EqualError(t, err, "maxPeerCount 1 is lower than requiredPeerCount 2")
+}
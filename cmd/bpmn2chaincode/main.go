@@ -0,0 +1,78 @@
+// Command bpmn2chaincode compiles a BPMN 2.0 choreography into a Go chaincode package
+// with one transaction per message, gateway and event, in the style of chaincode/smartcontract.go.
+//
+//	bpmn2chaincode -in hotel_booking.bpmn -out ./hotelbooking -package hotelbooking
+//
+// With -bindings it also writes collections_config.json, the private data collections
+// of every pair of organizations exchanging messages. A participant bound late lists its
+// candidate MSP IDs, every one of them gets a collection with its counterparts:
+//
+//	bpmn2chaincode -in hotel_booking.bpmn -bindings '{"Participant_1080bkg":"Org1MSP","Participant_0sktaei":["Org2MSP","Org3MSP"]}'
+//
+// Private payloads are copied to at least -required-peers and at most -max-peers other
+// peers (1 and 2 by default) before SendMessage is endorsed.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+
+	"chaincode-go-bpmn/chaincode"
+)
+
+func main() {
+	in := flag.String("in", "", "BPMN choreography file")
+	out := flag.String("out", ".", "output directory")
+	pkg := flag.String("package", "chaincode", "name of the generated package")
+	mocks := flag.String("mocks", "chaincode-go-bpmn/chaincode/mocks", "import path of the counterfeiter fakes used by the generated tests")
+	runtime := flag.String("runtime", "chaincode-go-bpmn/chaincode", "import path of the package validating message payloads")
+	bindings := flag.String("bindings", "", "JSON object binding participants to MSP IDs or arrays of candidate MSP IDs, writes collections_config.json")
+	requiredPeers := flag.Int("required-peers", DefaultCollectionOptions.RequiredPeerCount, "peers a private payload has to be copied to before SendMessage is endorsed")
+	maxPeers := flag.Int("max-peers", DefaultCollectionOptions.MaxPeerCount, "peers a private payload is copied to at most")
+	flag.Parse()
+
+	if *in == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	data, err := os.ReadFile(*in)
+	if err != nil {
+		log.Fatalf("Error reading %s: %v", *in, err)
+	}
+	chor, err := chaincode.ParseChoreography(data)
+	if err != nil {
+		log.Fatalf("Error parsing %s: %v", *in, err)
+	}
+
+	files, err := Generate(chor, Options{Package: *pkg, MocksImport: *mocks, RuntimeImport: *runtime})
+	if err != nil {
+		log.Fatalf("Error generating chaincode: %v", err)
+	}
+
+	if *bindings != "" {
+		var participantMsps map[string]mspCandidates
+		if err := json.Unmarshal([]byte(*bindings), &participantMsps); err != nil {
+			log.Fatalf("Error parsing -bindings: %v", err)
+		}
+		collections, err := GenerateCollections(chor, participantMsps, CollectionOptions{RequiredPeerCount: *requiredPeers, MaxPeerCount: *maxPeers})
+		if err != nil {
+			log.Fatalf("Error generating collections: %v", err)
+		}
+		files["collections_config.json"] = collections
+	}
+
+	if err := os.MkdirAll(*out, 0o755); err != nil {
+		log.Fatalf("Error creating %s: %v", *out, err)
+	}
+	for name, src := range files {
+		path := filepath.Join(*out, name)
+		if err := os.WriteFile(path, src, 0o644); err != nil {
+			log.Fatalf("Error writing %s: %v", path, err)
+		}
+		log.Printf("wrote %s", path)
+	}
+}
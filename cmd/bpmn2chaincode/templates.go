@@ -0,0 +1,646 @@
+package main
+
+import "text/template"
+
+var contractTemplate = template.Must(template.New("contract").Parse(`// Code generated by bpmn2chaincode from choreography {{.Choreography}}. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	bpmn "{{.RuntimeImport}}"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// SmartContract provides the transactions of choreography {{.Choreography}}
+type SmartContract struct {
+	contractapi.Contract
+}
+
+type ElementState int
+
+const (
+	DISABLE = iota
+	ENABLE
+	WAITFORCONFIRM
+	DONE
+)
+
+type Message struct {
+	MessageID     string       ` + "`" + `json:"messageID"` + "`" + `
+	SendMspID     string       ` + "`" + `json:"sendMspID"` + "`" + `
+	ReceiveMspID  string       ` + "`" + `json:"receiveMspID"` + "`" + `
+	FireflyTranID string       ` + "`" + `json:"fireflyTranID"` + "`" + `
+	PayloadHash   string       ` + "`" + `json:"payloadHash,omitempty"` + "`" + `
+	MsgState      ElementState ` + "`" + `json:"msgState"` + "`" + `
+	Format        string       ` + "`" + `json:"format"` + "`" + `
+}
+
+type Gateway struct {
+	GatewayID    string       ` + "`" + `json:"gatewayID"` + "`" + `
+	GatewayState ElementState ` + "`" + `json:"gatewayState"` + "`" + `
+	Chosen       string       ` + "`" + `json:"chosen,omitempty"` + "`" + `
+}
+
+type ActionEvent struct {
+	EventID    string       ` + "`" + `json:"eventID"` + "`" + `
+	EventState ElementState ` + "`" + `json:"eventState"` + "`" + `
+}
+
+// Participant binds a BPMN participant to the organization playing it
+type Participant struct {
+	ParticipantID string ` + "`" + `json:"participantID"` + "`" + `
+	Name          string ` + "`" + `json:"name"` + "`" + `
+	Role          string ` + "`" + `json:"role"` + "`" + `
+	MspID         string ` + "`" + `json:"mspID"` + "`" + `
+}
+
+// StateMemory holds the message fields that gateway conditions are evaluated against
+type StateMemory struct {
+{{- range .Fields}}
+	{{.GoName}} {{.GoType}} ` + "`" + `json:"{{.Name}}"` + "`" + `
+{{- end}}
+
+	assigned map[string]bool // fields some message has set, the others only hold zero values
+}
+
+// require fails like the generic engine when a condition reads a field no message has set
+func (m *StateMemory) require(names ...string) error {
+	for _, name := range names {
+		if !m.assigned[name] {
+			return fmt.Errorf("variable %s is not set", name)
+		}
+	}
+	return nil
+}
+
+const memoryKey = "StateMemory"
+
+func (cc *SmartContract) getRecord(ctx contractapi.TransactionContextInterface, key string, record interface{}) (bool, error) {
+	recordJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return false, err
+	}
+	if recordJSON == nil {
+		return false, nil
+	}
+	return true, json.Unmarshal(recordJSON, record)
+}
+
+func (cc *SmartContract) putRecord(ctx contractapi.TransactionContextInterface, key string, record interface{}) error {
+	recordJSON, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(key, recordJSON)
+}
+
+// Create function, unexported so that only the contract creates elements, never a transaction
+func (cc *SmartContract) createMessage(ctx contractapi.TransactionContextInterface, messageID string, sendMspID string, receiveMspID string, fireflyTranID string, msgState ElementState, format string) (*Message, error) {
+	msg := &Message{
+		MessageID:     messageID,
+		SendMspID:     sendMspID,
+		ReceiveMspID:  receiveMspID,
+		FireflyTranID: fireflyTranID,
+		MsgState:      msgState,
+		Format:        format,
+	}
+	return msg, cc.putRecord(ctx, messageID, msg)
+}
+
+func (cc *SmartContract) createGateway(ctx contractapi.TransactionContextInterface, gatewayID string, gatewayState ElementState) (*Gateway, error) {
+	gtw := &Gateway{GatewayID: gatewayID, GatewayState: gatewayState}
+	return gtw, cc.putRecord(ctx, gatewayID, gtw)
+}
+
+func (cc *SmartContract) createActionEvent(ctx contractapi.TransactionContextInterface, eventID string, eventState ElementState) (*ActionEvent, error) {
+	actionEvent := &ActionEvent{EventID: eventID, EventState: eventState}
+	return actionEvent, cc.putRecord(ctx, eventID, actionEvent)
+}
+
+// Read function
+func (cc *SmartContract) ReadMsg(ctx contractapi.TransactionContextInterface, messageID string) (*Message, error) {
+	var msg Message
+	exists, err := cc.getRecord(ctx, messageID, &msg)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("Message %s does not exist", messageID)
+	}
+	return &msg, nil
+}
+
+func (cc *SmartContract) ReadGtw(ctx contractapi.TransactionContextInterface, gatewayID string) (*Gateway, error) {
+	var gtw Gateway
+	exists, err := cc.getRecord(ctx, gatewayID, &gtw)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("Gateway %s does not exist", gatewayID)
+	}
+	return &gtw, nil
+}
+
+func (cc *SmartContract) ReadEvent(ctx contractapi.TransactionContextInterface, eventID string) (*ActionEvent, error) {
+	var event ActionEvent
+	exists, err := cc.getRecord(ctx, eventID, &event)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("Event state %s does not exist", eventID)
+	}
+	return &event, nil
+}
+
+func (cc *SmartContract) ReadParticipant(ctx contractapi.TransactionContextInterface, participantID string) (*Participant, error) {
+	var participant Participant
+	exists, err := cc.getRecord(ctx, participantID, &participant)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("Participant %s is not bound to an MSP", participantID)
+	}
+	return &participant, nil
+}
+
+// authorize checks that the caller belongs to the organization bound to a participant
+func (cc *SmartContract) authorize(ctx contractapi.TransactionContextInterface, participantID string) error {
+	participant, err := cc.ReadParticipant(ctx, participantID)
+	if err != nil {
+		return err
+	}
+	clientMspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return err
+	}
+	if clientMspID != participant.MspID {
+		errorMessage := "Msp denied"
+		fmt.Println(errorMessage)
+		return errors.New(errorMessage)
+	}
+	return nil
+}
+
+// ReadMemory returns the message fields received so far
+func (cc *SmartContract) ReadMemory(ctx contractapi.TransactionContextInterface) (*StateMemory, error) {
+	var fields map[string]json.RawMessage
+	if _, err := cc.getRecord(ctx, memoryKey, &fields); err != nil {
+		return nil, err
+	}
+	memory := &StateMemory{assigned: make(map[string]bool)}
+	for name := range fields {
+		memory.assigned[name] = true
+	}
+	if _, err := cc.getRecord(ctx, memoryKey, memory); err != nil {
+		return nil, err
+	}
+	return memory, nil
+}
+
+// Change State function, unexported so that states only move through Send, Confirm and the gateways
+func (cc *SmartContract) changeMsgState(ctx contractapi.TransactionContextInterface, messageID string, msgState ElementState) error {
+	msg, err := cc.ReadMsg(ctx, messageID)
+	if err != nil {
+		return err
+	}
+	msg.MsgState = msgState
+	return cc.putRecord(ctx, messageID, msg)
+}
+
+func (cc *SmartContract) changeGtwState(ctx contractapi.TransactionContextInterface, gatewayID string, gtwState ElementState) error {
+	gtw, err := cc.ReadGtw(ctx, gatewayID)
+	if err != nil {
+		return err
+	}
+	gtw.GatewayState = gtwState
+	return cc.putRecord(ctx, gatewayID, gtw)
+}
+
+func (cc *SmartContract) changeEventState(ctx contractapi.TransactionContextInterface, eventID string, eventState ElementState) error {
+	actionEvent, err := cc.ReadEvent(ctx, eventID)
+	if err != nil {
+		return err
+	}
+	actionEvent.EventState = eventState
+	return cc.putRecord(ctx, eventID, actionEvent)
+}
+
+// InitLedger adds the elements of the choreography to the ledger. participantBindings maps
+// every participant, by BPMN ID or name, to an MSP ID, e.g. {{printf "%s" .ExampleBindings}}
+func (cc *SmartContract) InitLedger(ctx contractapi.TransactionContextInterface, participantBindings string) error {
+	stub := ctx.GetStub()
+
+	// Determines whether the chain code is initialized
+	existing, err := stub.GetState("{{.StartEvent}}")
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		errorMessage := "Chaincode has already been initialized"
+		fmt.Println(errorMessage)
+		return errors.New(errorMessage)
+	}
+
+	bindings := make(map[string]string)
+	if err := json.Unmarshal([]byte(participantBindings), &bindings); err != nil {
+		return fmt.Errorf("participant bindings are not a JSON object: %v", err)
+	}
+	participants := []*Participant{
+{{- range .Participants}}
+		{ParticipantID: {{printf "%q" .ID}}, Name: {{printf "%q" .Name}}, Role: {{printf "%q" .Role}}},
+{{- end}}
+	}
+	known := make(map[string]bool)
+	for _, p := range participants {
+		known[p.ParticipantID], known[p.Name] = true, true
+	}
+	for ref := range bindings {
+		if !known[ref] {
+			return fmt.Errorf("Participant %s is not part of choreography {{.Choreography}}", ref)
+		}
+	}
+	mspIDs := make(map[string]string)
+	for _, p := range participants {
+		if p.MspID = bindings[p.ParticipantID]; p.MspID == "" {
+			p.MspID = bindings[p.Name]
+		}
+		if p.MspID == "" {
+			errorMessage := fmt.Sprintf("Participant %s is not bound to an MSP", p.ParticipantID)
+			fmt.Println(errorMessage)
+			return errors.New(errorMessage)
+		}
+		if err := cc.putRecord(ctx, p.ParticipantID, p); err != nil {
+			return err
+		}
+		mspIDs[p.ParticipantID] = p.MspID
+	}
+
+{{- range .Events}}
+	if _, err := cc.createActionEvent(ctx, "{{.ID}}", {{if .Start}}ENABLE{{else}}DISABLE{{end}}); err != nil {
+		return err
+	}
+{{- end}}
+{{- range .Gateways}}
+	if _, err := cc.createGateway(ctx, "{{.ID}}", DISABLE); err != nil {
+		return err
+	}
+{{- end}}
+{{range .Messages}}
+	// {{.Name}}
+	if _, err := cc.createMessage(ctx, "{{.ID}}", mspIDs["{{.Send}}"], mspIDs["{{.Receive}}"], "", DISABLE, {{printf "%q" .Format}}); err != nil {
+		return err
+	}
+{{- end}}
+
+	return stub.SetEvent("initLedgerEvent", []byte("Contract has been initialized successfully"))
+}
+
+// completeEvent moves an enabled event to DONE
+func (cc *SmartContract) completeEvent(ctx contractapi.TransactionContextInterface, eventID string) error {
+	event, err := cc.ReadEvent(ctx, eventID)
+	if err != nil {
+		return err
+	}
+	if event.EventState != ENABLE {
+		errorMessage := fmt.Sprintf("Event state %s is not allowed", event.EventID)
+		fmt.Println(errorMessage)
+		return errors.New(errorMessage)
+	}
+	event.EventState = DONE
+	if err := cc.putRecord(ctx, eventID, event); err != nil {
+		return err
+	}
+	return ctx.GetStub().SetEvent(eventID, []byte(eventID+" has been done"))
+}
+
+// completeGateway records that the token has passed a gateway.
+// Gateways and end events are reached from within a transaction, so they go
+// straight to DONE: a peer does not return the transaction's own pending writes.
+func (cc *SmartContract) completeGateway(ctx contractapi.TransactionContextInterface, gatewayID string) error {
+	if err := cc.changeGtwState(ctx, gatewayID, DONE); err != nil {
+		return err
+	}
+	return ctx.GetStub().SetEvent(gatewayID, []byte(gatewayID+" has been done"))
+}
+
+// openGateway keeps an event-based gateway enabled until the first message of one of
+// its branches is sent
+func (cc *SmartContract) openGateway(ctx contractapi.TransactionContextInterface, gatewayID string) error {
+	gtw, err := cc.ReadGtw(ctx, gatewayID)
+	if err != nil {
+		return err
+	}
+	gtw.GatewayState = ENABLE
+	gtw.Chosen = ""
+	return cc.putRecord(ctx, gatewayID, gtw)
+}
+
+// chooseAlternative settles the race of an event-based gateway: the gateway records flowID
+// as chosen and the messages of the other branches are disabled. Sending on another branch
+// afterwards fails, resending on the chosen one is allowed.
+func (cc *SmartContract) chooseAlternative(ctx contractapi.TransactionContextInterface, gatewayID string, flowID string, messageID string, others ...string) error {
+	gtw, err := cc.ReadGtw(ctx, gatewayID)
+	if err != nil {
+		return err
+	}
+	if gtw.Chosen == flowID {
+		return nil
+	}
+	if gtw.Chosen != "" {
+		errorMessage := fmt.Sprintf("Alternative already chosen: gateway %s took %s, %s can not be sent", gatewayID, gtw.Chosen, messageID)
+		fmt.Println(errorMessage)
+		return errors.New(errorMessage)
+	}
+	if gtw.GatewayState != ENABLE {
+		return nil
+	}
+
+	for _, otherID := range others {
+		if err := cc.changeMsgState(ctx, otherID, DISABLE); err != nil {
+			return err
+		}
+	}
+	gtw.GatewayState = DONE
+	gtw.Chosen = flowID
+	return cc.putRecord(ctx, gatewayID, gtw)
+}
+
+// =================================================================================================
+{{range .Events}}
+{{- if .Start}}
+func (cc *SmartContract) {{.Method}}(ctx contractapi.TransactionContextInterface) error {
+	if err := cc.completeEvent(ctx, "{{.ID}}"); err != nil {
+		return err
+	}
+	{{.Next}}
+}
+{{else}}
+func (cc *SmartContract) {{.Method}}(ctx contractapi.TransactionContextInterface) error {
+	if err := cc.changeEventState(ctx, "{{.ID}}", DONE); err != nil {
+		return err
+	}
+	return ctx.GetStub().SetEvent("{{.ID}}", []byte("{{.ID}} has been done"))
+}
+{{end}}
+{{- end}}
+{{- range .Gateways}}
+func (cc *SmartContract) {{.Method}}(ctx contractapi.TransactionContextInterface) error {
+{{- if .All}}
+	if err := cc.openGateway(ctx, "{{.ID}}"); err != nil {
+		return err
+	}
+{{range .All}}
+	if err := {{.}}; err != nil {
+		return err
+	}
+{{- end}}
+	return nil
+{{- else}}
+	if err := cc.completeGateway(ctx, "{{.ID}}"); err != nil {
+		return err
+	}
+{{- if .Branches}}
+
+	memory, err := cc.ReadMemory(ctx)
+	if err != nil {
+		return err
+	}
+{{- range .Branches}}
+	if err := memory.require({{.Variables}}); err != nil {
+		return err
+	}
+	if {{.Condition}} {
+		{{.Code}}
+	}
+{{- end}}
+{{- end}}
+{{- if .Default}}
+	{{.Default}}
+{{- else}}
+	return errors.New("no outgoing flow of gateway {{.ID}} matches")
+{{- end}}
+{{- end}}
+}
+{{end}}
+{{- range .Messages}}
+// {{.Name}}
+{{- if .Schema}}
+// payloadJSON must match the JSON Schema of the message
+{{- else}}
+// payloadJSON must match the format {{printf "%q" .Format}}
+{{- end}}
+// payloadHash is the SHA-256 of the payload the receiver has to confirm
+func (cc *SmartContract) {{.Method}}_Send(ctx contractapi.TransactionContextInterface, fireflyTranID string, payloadJSON string, payloadHash string) error {
+	stub := ctx.GetStub()
+	msg, err := cc.ReadMsg(ctx, "{{.ID}}")
+	if err != nil {
+		return err
+	}
+
+	if err := cc.authorize(ctx, "{{.Send}}"); err != nil {
+		return err
+	}
+{{- with .Race}}
+
+	// 第一个被发送的分支赢得事件网关，其余分支立即失效
+	if err := cc.chooseAlternative(ctx, "{{.Gateway}}", "{{.Flow}}", "{{.Message}}"{{range .Others}}, "{{.}}"{{end}}); err != nil {
+		return err
+	}
+{{- end}}
+
+	if msg.MsgState != ENABLE {
+		errorMessage := fmt.Sprintf("Msg state %s is not allowed", msg.MessageID)
+		fmt.Println(errorMessage)
+		return errors.New(errorMessage)
+	}
+
+	format, err := bpmn.CompileFormat(msg.Format)
+	if err != nil {
+		return err
+	}
+{{- if .Fields}}
+	payload, err := format.ParsePayload(payloadJSON)
+	if err != nil {
+		return err
+	}
+{{- else}}
+	if _, err := format.ParsePayload(payloadJSON); err != nil {
+		return err
+	}
+{{- end}}
+	hash, err := bpmn.NormalizePayloadHash(payloadHash)
+	if err != nil {
+		return err
+	}
+	// 公开的载荷必须与哈希一致，只有不带载荷的发送才可以给出 FireFly 的数据哈希
+	if payloadJSON != "" {
+		canonical, err := bpmn.CanonicalPayloadHash(payloadJSON)
+		if err != nil {
+			return err
+		}
+		if canonical != hash {
+			return fmt.Errorf("Payload hash %s does not match the payload", hash)
+		}
+	}
+
+	msg.MsgState = WAITFORCONFIRM
+	msg.FireflyTranID = fireflyTranID
+	msg.PayloadHash = hash
+	if err := cc.putRecord(ctx, msg.MessageID, msg); err != nil {
+		return err
+	}
+{{- if .Fields}}
+
+	// 校验过的字段写入 StateMemory，未收到的字段不写入
+	fields := make(map[string]interface{})
+	if _, err := cc.getRecord(ctx, memoryKey, &fields); err != nil {
+		return err
+	}
+	for name, value := range payload {
+		fields[name] = value
+	}
+	if err := cc.putRecord(ctx, memoryKey, fields); err != nil {
+		return err
+	}
+{{- end}}
+
+	return stub.SetEvent("{{.ID}}", []byte("{{.ID}} need to be confirm"))
+}
+
+func (cc *SmartContract) {{.Method}}_Confirm(ctx contractapi.TransactionContextInterface, payloadHash string) error {
+	stub := ctx.GetStub()
+	msg, err := cc.ReadMsg(ctx, "{{.ID}}")
+	if err != nil {
+		return err
+	}
+
+	if msg.MsgState != WAITFORCONFIRM {
+		errorMessage := fmt.Sprintf("Msg state %s is not allowed", msg.MessageID)
+		fmt.Println(errorMessage)
+		return errors.New(errorMessage)
+	}
+
+	if err := cc.authorize(ctx, "{{.Receive}}"); err != nil {
+		return err
+	}
+
+	hash, err := bpmn.NormalizePayloadHash(payloadHash)
+	if err != nil {
+		return err
+	}
+	if hash != msg.PayloadHash {
+		errorMessage := fmt.Sprintf("Payload hash %s does not match %s sent with message %s", hash, msg.PayloadHash, msg.MessageID)
+		fmt.Println(errorMessage)
+		return errors.New(errorMessage)
+	}
+
+	if err := cc.changeMsgState(ctx, "{{.ID}}", DONE); err != nil {
+		return err
+	}
+	if err := stub.SetEvent("{{.ID}}", []byte("{{.ID}} has been done")); err != nil {
+		return err
+	}
+
+	{{.OnConfirm}}
+}
+{{end}}`))
+
+var testTemplate = template.Must(template.New("test").Parse(`// Code generated by bpmn2chaincode from choreography {{.Choreography}}. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"testing"
+
+	"{{.MocksImport}}"
+	"github.com/stretchr/testify/require"
+)
+
+const participantBindings = ` + "`" + `{{.TestBindings}}` + "`" + `
+
+// newWorldState backs the fake stub with an in-memory key/value store
+func newWorldState() (*mocks.TransactionContext, *mocks.ClientIdentity) {
+	state := make(map[string][]byte)
+	chaincodeStub := &mocks.ChaincodeStub{}
+	chaincodeStub.GetStateStub = func(key string) ([]byte, error) {
+		return state[key], nil
+	}
+	chaincodeStub.PutStateStub = func(key string, value []byte) error {
+		state[key] = value
+		return nil
+	}
+
+	clientIdentity := &mocks.ClientIdentity{}
+	transactionContext := &mocks.TransactionContext{}
+	transactionContext.GetStubReturns(chaincodeStub)
+	transactionContext.GetClientIdentityReturns(clientIdentity)
+	return transactionContext, clientIdentity
+}
+
+func TestInitLedger(t *testing.T) {
+	ctx, _ := newWorldState()
+	cc := &SmartContract{}
+
+	require.EqualError(t, cc.InitLedger(ctx, "{}"), "Participant {{(index .Participants 0).ID}} is not bound to an MSP")
+	require.EqualError(t, cc.InitLedger(ctx, ` + "`" + `{"Participant_unknown":"Org9MSP"}` + "`" + `), "Participant Participant_unknown is not part of choreography {{.Choreography}}")
+	require.NoError(t, cc.InitLedger(ctx, participantBindings))
+	require.EqualError(t, cc.InitLedger(ctx, participantBindings), "Chaincode has already been initialized")
+
+	for participantID, mspID := range map[string]string{
+{{- range .Participants}}
+		"{{.ID}}": "{{.TestMsp}}",
+{{- end}}
+	} {
+		participant, err := cc.ReadParticipant(ctx, participantID)
+		require.NoError(t, err)
+		require.Equal(t, mspID, participant.MspID)
+	}
+}
+
+func Test{{.StartEvent}}(t *testing.T) {
+	ctx, _ := newWorldState()
+	cc := &SmartContract{}
+	require.NoError(t, cc.InitLedger(ctx, participantBindings))
+
+	require.NoError(t, cc.{{.StartEvent}}(ctx))
+	require.EqualError(t, cc.{{.StartEvent}}(ctx), "Event state {{.StartEvent}} is not allowed")
+}
+{{range .Messages}}
+func Test{{.Method}}(t *testing.T) {
+	ctx, identity := newWorldState()
+	cc := &SmartContract{}
+	require.NoError(t, cc.InitLedger(ctx, participantBindings))
+
+	identity.GetMSPIDReturns("{{.SendMsp}}", nil)
+	require.EqualError(t, cc.{{.Method}}_Send(ctx, "fireflyTranID", ` + "`" + `{{.Payload}}` + "`" + `, "{{.PayloadHash}}"), "Msg state {{.ID}} is not allowed")
+	require.NoError(t, cc.changeMsgState(ctx, "{{.ID}}", ENABLE))
+
+	identity.GetMSPIDReturns("{{.ReceiveMsp}}", nil)
+	require.EqualError(t, cc.{{.Method}}_Send(ctx, "fireflyTranID", ` + "`" + `{{.Payload}}` + "`" + `, "{{.PayloadHash}}"), "Msp denied")
+
+	identity.GetMSPIDReturns("{{.SendMsp}}", nil)
+{{- if not .Schema}}
+	require.ErrorContains(t, cc.{{.Method}}_Send(ctx, "fireflyTranID", ` + "`" + `{"unknown":1}` + "`" + `, "{{.PayloadHash}}"), "field unknown is not declared")
+{{- end}}
+	require.EqualError(t, cc.{{.Method}}_Send(ctx, "fireflyTranID", ` + "`" + `{{.Payload}}` + "`" + `, "{{.OtherHash}}"), "Payload hash {{.OtherHash}} does not match the payload")
+	require.NoError(t, cc.{{.Method}}_Send(ctx, "fireflyTranID", ` + "`" + `{{.Payload}}` + "`" + `, "{{.PayloadHash}}"))
+	require.EqualError(t, cc.{{.Method}}_Confirm(ctx, "{{.PayloadHash}}"), "Msp denied")
+
+	identity.GetMSPIDReturns("{{.ReceiveMsp}}", nil)
+	require.ErrorContains(t, cc.{{.Method}}_Confirm(ctx, "{{.OtherHash}}"), "does not match")
+	require.NoError(t, cc.{{.Method}}_Confirm(ctx, "{{.PayloadHash}}"))
+
+	msg, err := cc.ReadMsg(ctx, "{{.ID}}")
+	require.NoError(t, err)
+	require.Equal(t, ElementState(DONE), msg.MsgState)
+	require.Equal(t, "fireflyTranID", msg.FireflyTranID)
+	require.Equal(t, "{{.PayloadHash}}", msg.PayloadHash)
+}
+{{end}}`))